@@ -0,0 +1,42 @@
+package gonii
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/nifti"
+)
+
+// MergeChannels combines the red, green and blue UINT8 images into an RGB24 image with the geometry of r. This is the
+// inverse of Nii.SplitChannels for RGB24 images. The three images must have the same dimensions
+func MergeChannels(r, g, b *nifti.Nii) (*nifti.Nii, error) {
+	channels := []*nifti.Nii{r, g, b}
+	for c, channel := range channels {
+		if channel == nil {
+			return nil, fmt.Errorf("channel %d is nil", c)
+		}
+		if channel.Datatype != nifti.DT_UINT8 || channel.NByPer != 1 {
+			return nil, fmt.Errorf("expected a UINT8 channel %d, got %s", c, channel.GetDatatype())
+		}
+		if channel.Dim != r.Dim || len(channel.Volume) != len(r.Volume) {
+			return nil, fmt.Errorf("channel %d dimensions %v do not match %v", c, channel.GetImgShape(), r.GetImgShape())
+		}
+	}
+	if len(r.Volume) == 0 {
+		return nil, errors.New("channels have no voxel data")
+	}
+
+	out := *r
+	nByPer, swapSize := nifti.AssignDatatypeSize(nifti.DT_RGB24)
+	out.Datatype = nifti.DT_RGB24
+	out.NByPer, out.SwapSize = int32(nByPer), int32(swapSize)
+	out.SclSlope, out.SclInter = 0, 0
+	out.VolumeSlopes, out.VolumeInters = nil, nil
+	out.Nifti1Ext = append([]nifti.Nifti1Ext(nil), r.Nifti1Ext...)
+	out.Volume = make([]byte, len(r.Volume)*len(channels))
+	for i := range r.Volume {
+		for c, channel := range channels {
+			out.Volume[i*len(channels)+c] = channel.Volume[i]
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,32 @@
+package gonii
+
+import (
+	"github.com/okieraised/gonii/pkg/nifti"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMergeChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := ReadFile("./test_data/rgb24.nii.gz")
+	assert.NoError(err)
+	assert.Equal(nifti.DT_RGB24, img.Datatype)
+
+	channels, err := img.SplitChannels()
+	assert.NoError(err)
+	merged, err := MergeChannels(channels[0], channels[1], channels[2])
+	assert.NoError(err)
+	assert.Equal(nifti.DT_RGB24, merged.Datatype)
+	assert.Equal(img.Dim, merged.Dim)
+	assert.Equal(img.Volume, merged.Volume)
+
+	// Mismatched shapes and datatypes
+	_, err = MergeChannels(channels[0], channels[1], img)
+	assert.Error(err)
+	small := newTestImage(2, 2, 2, 1, nifti.DT_UINT8, img.ByteOrder)
+	_, err = MergeChannels(channels[0], channels[1], small)
+	assert.Error(err)
+	_, err = MergeChannels(channels[0], nil, channels[2])
+	assert.Error(err)
+}
@@ -1,11 +1,16 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	gzip "github.com/klauspost/pgzip"
 	"io"
+	"os"
 )
 
+// DeflateGzip inflates the gzip content. Concatenated gzip members are all inflated and any trailing bytes that are
+// not a valid gzip member are ignored
 func DeflateGzip(b []byte) ([]byte, error) {
 	br := bytes.NewReader(b)
 	g, err := gzip.NewReader(br)
@@ -14,10 +19,57 @@ func DeflateGzip(b []byte) ([]byte, error) {
 	}
 	defer g.Close()
 
-	p, err := io.ReadAll(g)
+	out := &bytes.Buffer{}
+	for {
+		// Read one member at a time so that trailing garbage after the last member can be detected
+		g.Multistream(false)
+		_, err = io.Copy(out, g)
+		if err != nil {
+			return nil, err
+		}
+
+		err = g.Reset(br)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// Trailing non-gzip bytes
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// ReadFileHead reads at most n bytes from the start of the file content, inflating the file on the fly if it is
+// gzipped. It also returns whether the file is gzipped. This avoids reading the whole file when only the header is needed
+func ReadFileHead(path string, n int) ([]byte, bool, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	magic, _ := br.Peek(2)
+	compressed := len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+	if compressed {
+		g, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compressed, err
+		}
+		defer g.Close()
+		r = g
 	}
 
-	return p, nil
+	buf := make([]byte, n)
+	read, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, compressed, err
+	}
+	return buf[:read], compressed, nil
 }
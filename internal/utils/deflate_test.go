@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"bytes"
+	gzip "github.com/klauspost/pgzip"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func gzipMember(assert *assert.Assertions, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(data)
+	assert.NoError(err)
+	assert.NoError(w.Close())
+	return buf.Bytes()
+}
+
+func TestDeflateGzip_MultiStream(t *testing.T) {
+	assert := assert.New(t)
+
+	first := bytes.Repeat([]byte("first member "), 100)
+	second := bytes.Repeat([]byte("second member "), 100)
+
+	bData := append(gzipMember(assert, first), gzipMember(assert, second)...)
+	inflated, err := DeflateGzip(bData)
+	assert.NoError(err)
+	assert.Equal(append(append([]byte{}, first...), second...), inflated)
+
+	// Trailing garbage after the last member is ignored
+	inflated, err = DeflateGzip(append(bData, []byte("trailing garbage")...))
+	assert.NoError(err)
+	assert.Equal(append(append([]byte{}, first...), second...), inflated)
+}
@@ -0,0 +1,173 @@
+package utils
+
+import "math"
+
+const (
+	distributionMaxIter = 300
+	distributionEpsilon = 3.0e-14
+	distributionFPMin   = 1.0e-300
+)
+
+// NormalSF returns the upper tail probability P(Z > z) of the standard normal distribution
+func NormalSF(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// StudentTSF returns the upper tail probability P(T > t) of the Student's t distribution with dof degrees of freedom
+func StudentTSF(t, dof float64) float64 {
+	if dof <= 0 {
+		return math.NaN()
+	}
+	// P(|T| > |t|) = I_x(dof/2, 1/2) with x = dof/(dof+t²)
+	twoTailed := RegIncBeta(dof/2, 0.5, dof/(dof+t*t))
+	if t > 0 {
+		return twoTailed / 2
+	}
+	return 1 - twoTailed/2
+}
+
+// FSF returns the upper tail probability P(F > f) of the F distribution with dof1 and dof2 degrees of freedom
+func FSF(f, dof1, dof2 float64) float64 {
+	if dof1 <= 0 || dof2 <= 0 {
+		return math.NaN()
+	}
+	if f <= 0 {
+		return 1
+	}
+	return RegIncBeta(dof2/2, dof1/2, dof2/(dof2+dof1*f))
+}
+
+// ChiSquareSF returns the upper tail probability P(X > x) of the chi-squared distribution with dof degrees of freedom
+func ChiSquareSF(x, dof float64) float64 {
+	if dof <= 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1
+	}
+	return RegIncGammaUpper(dof/2, x/2)
+}
+
+// RegIncBeta returns the regularized incomplete beta function I_x(a, b)
+func RegIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+
+	lgab, _ := math.Lgamma(a + b)
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges rapidly for x < (a+1)/(a+b+2), otherwise use the symmetry relation
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+// betaContinuedFraction evaluates the continued fraction of the incomplete beta function with the modified Lentz's
+// method
+func betaContinuedFraction(a, b, x float64) float64 {
+	qab := a + b
+	qap := a + 1
+	qam := a - 1
+
+	c := 1.0
+	d := 1 - qab*x/qap
+	if math.Abs(d) < distributionFPMin {
+		d = distributionFPMin
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1; m <= distributionMaxIter; m++ {
+		fm := float64(m)
+		m2 := 2 * fm
+
+		// Even step
+		aa := fm * (b - fm) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < distributionFPMin {
+			d = distributionFPMin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < distributionFPMin {
+			c = distributionFPMin
+		}
+		d = 1 / d
+		h *= d * c
+
+		// Odd step
+		aa = -(a + fm) * (qab + fm) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < distributionFPMin {
+			d = distributionFPMin
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < distributionFPMin {
+			c = distributionFPMin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+
+		if math.Abs(del-1) < distributionEpsilon {
+			break
+		}
+	}
+	return h
+}
+
+// RegIncGammaUpper returns the regularized upper incomplete gamma function Q(a, x)
+func RegIncGammaUpper(a, x float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+
+	lga, _ := math.Lgamma(a)
+
+	// Series representation of P(a, x) for x < a+1
+	if x < a+1 {
+		ap := a
+		sum := 1 / a
+		del := sum
+		for n := 1; n <= distributionMaxIter; n++ {
+			ap++
+			del *= x / ap
+			sum += del
+			if math.Abs(del) < math.Abs(sum)*distributionEpsilon {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lga)
+	}
+
+	// Continued fraction representation of Q(a, x) otherwise
+	b := x + 1 - a
+	c := 1 / distributionFPMin
+	d := 1 / b
+	h := d
+	for n := 1; n <= distributionMaxIter; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < distributionFPMin {
+			d = distributionFPMin
+		}
+		c = b + an/c
+		if math.Abs(c) < distributionFPMin {
+			c = distributionFPMin
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < distributionEpsilon {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lga) * h
+}
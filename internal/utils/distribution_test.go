@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDistribution_SF(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.InDelta(0.5, NormalSF(0), 1e-12)
+	assert.InDelta(0.0249979, NormalSF(1.96), 1e-6)
+	assert.InDelta(0.9750021, NormalSF(-1.96), 1e-6)
+
+	// Critical values at the 0.05 and 0.025 levels
+	assert.InDelta(0.025, StudentTSF(2.0860, 20), 1e-5)
+	assert.InDelta(0.975, StudentTSF(-2.0860, 20), 1e-5)
+	assert.InDelta(0.05, FSF(4.3512, 1, 20), 1e-5)
+	assert.InDelta(0.05, FSF(2.8661, 4, 20), 1e-5)
+	assert.InDelta(0.05, ChiSquareSF(3.8415, 1), 1e-5)
+	assert.InDelta(0.05, ChiSquareSF(18.307, 10), 1e-5)
+
+	// The F distribution with 1 numerator degree of freedom is the square of the t distribution
+	assert.InDelta(2*StudentTSF(2.5, 12), FSF(6.25, 1, 12), 1e-12)
+}
@@ -57,7 +57,6 @@ func Hist(bins int, input []float64) (Histogram, error) {
 		buckets[i] = Bucket{Min: bmin, Max: bmax}
 	}
 
-	minC, maxC := 0, 0
 	for _, val := range input {
 		minx := float64(min)
 		xdiff := val - minx
@@ -66,9 +65,8 @@ func Hist(bins int, input []float64) (Histogram, error) {
 			return Histogram{}, fmt.Errorf("invalid bi value: %d", bi)
 		}
 		buckets[bi].Count++
-		minC = iMin(minC, buckets[bi].Count)
-		maxC = iMax(maxC, buckets[bi].Count)
 	}
+	minC, maxC := bucketCountRange(buckets)
 
 	return Histogram{
 		Min:     minC,
@@ -78,16 +76,69 @@ func Hist(bins int, input []float64) (Histogram, error) {
 	}, nil
 }
 
+// HistRange creates a histogram partitioning input over `bins` buckets of the fixed [min, max] range. Values outside
+// the range are counted in the first or last bucket if clamp is true, otherwise they are dropped and not counted.
+func HistRange(bins int, min, max float64, input []float64, clamp bool) (Histogram, error) {
+	if bins <= 0 {
+		return Histogram{}, fmt.Errorf("invalid number of bins: %d", bins)
+	}
+	if !(min < max) {
+		return Histogram{}, fmt.Errorf("invalid range [%v, %v]", min, max)
+	}
+
+	scale := (max - min) / float64(bins)
+	buckets := make([]Bucket, bins)
+	for i := range buckets {
+		buckets[i] = Bucket{Min: float64(i)*scale + min, Max: float64(i+1)*scale + min}
+	}
+
+	count := 0
+	for _, val := range input {
+		if math.IsNaN(val) || (!clamp && (val < min || val > max)) {
+			continue
+		}
+		// Compare before converting to int, which overflows for infinite or huge values
+		bi := 0
+		switch {
+		case val >= max:
+			bi = len(buckets) - 1
+		case val > min:
+			bi = iMin(int((val-min)/scale), len(buckets)-1)
+		}
+		buckets[bi].Count++
+		count++
+	}
+
+	minC, maxC := bucketCountRange(buckets)
+
+	return Histogram{
+		Min:     minC,
+		Max:     maxC,
+		Count:   count,
+		Buckets: buckets,
+	}, nil
+}
+
 // PowerHist creates a histogram partitioning input over buckets of power `pow`.
+// The logarithm of zero or negative values is undefined, so the non-positive values are skipped and not counted. An
+// empty histogram is returned if there is no positive value.
 func PowerHist(power float64, input []float64) Histogram {
 	if len(input) == 0 || power <= 0 {
 		return Histogram{}
 	}
 
-	minx, maxx := input[0], input[0]
+	count := 0
+	minx, maxx := math.Inf(1), math.Inf(-1)
 	for _, val := range input {
+		if !(val > 0) {
+			continue
+		}
 		minx = math.Min(minx, val)
 		maxx = math.Max(maxx, val)
+		count++
+	}
+	if count == 0 {
+		return Histogram{}
 	}
 
 	fromPower := math.Floor(logBase(minx, power))
@@ -100,24 +151,67 @@ func PowerHist(power float64, input []float64) Histogram {
 		buckets[i] = bkt
 	}
 
-	minC := 0
-	maxC := 0
 	for _, val := range input {
+		if !(val > 0) {
+			continue
+		}
 		powAway := logBase(val, power) - fromPower
-		bi := int(math.Floor(powAway))
+		// Guard against rounding errors of the logarithm at the bucket bounds
+		bi := iMax(0, iMin(int(math.Floor(powAway)), len(buckets)-1))
 		buckets[bi].Count++
-		minC = iMin(buckets[bi].Count, minC)
-		maxC = iMax(buckets[bi].Count, maxC)
 	}
+	minC, maxC := bucketCountRange(buckets)
 
 	return Histogram{
 		Min:     minC,
 		Max:     maxC,
-		Count:   len(input),
+		Count:   count,
 		Buckets: buckets,
 	}
 }
 
+// CDF returns the cumulative counts of the buckets normalized by the total count, i.e. the fraction of the values that
+// fall in the bucket or the buckets before it.
+func (h Histogram) CDF() []float64 {
+	cdf := make([]float64, len(h.Buckets))
+	total := 0
+	for _, bkt := range h.Buckets {
+		total += bkt.Count
+	}
+	if total == 0 {
+		return cdf
+	}
+
+	cumulative := 0
+	for i, bkt := range h.Buckets {
+		cumulative += bkt.Count
+		cdf[i] = float64(cumulative) / float64(total)
+	}
+	return cdf
+}
+
+// Quantile returns the value at the quantile q (0 to 1), interpolated linearly within the bucket where the cumulative
+// distribution reaches q. NaN is returned if the histogram is empty or q is out of range.
+func (h Histogram) Quantile(q float64) float64 {
+	if len(h.Buckets) == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	cdf := h.CDF()
+	prev := 0.0
+	for i, bkt := range h.Buckets {
+		if cdf[i] >= q && bkt.Count > 0 {
+			frac := (q - prev) / (cdf[i] - prev)
+			return bkt.Min + frac*(bkt.Max-bkt.Min)
+		}
+		prev = cdf[i]
+	}
+	if cdf[len(cdf)-1] == 0 {
+		return math.NaN()
+	}
+	return h.Buckets[len(h.Buckets)-1].Max
+}
+
 // Scale gives the scaled count of the bucket at idx, using the provided scale func.
 func (h Histogram) Scale(s ScaleFunc, idx int) float64 {
 	bkt := h.Buckets[idx]
@@ -138,6 +232,19 @@ func Linear(width int) ScaleFunc {
 	}
 }
 
+// bucketCountRange returns the sizes of the smallest and the biggest buckets, once all the values have been counted
+func bucketCountRange(buckets []Bucket) (int, int) {
+	if len(buckets) == 0 {
+		return 0, 0
+	}
+	minC, maxC := buckets[0].Count, buckets[0].Count
+	for _, bkt := range buckets[1:] {
+		minC = iMin(minC, bkt.Count)
+		maxC = iMax(maxC, bkt.Count)
+	}
+	return minC, maxC
+}
+
 func logBase(a, base float64) float64 {
 	return math.Log2(a) / math.Log2(base)
 }
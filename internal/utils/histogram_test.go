@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestPowerHist_NonPositive(t *testing.T) {
+	assert := assert.New(t)
+
+	hist := PowerHist(10, []float64{0, -3, 1, 5, 10, 99, 100, 1000, 0, -0.5})
+	assert.Equal(6, hist.Count)
+	assert.Len(hist.Buckets, 4)
+	assert.Equal(1.0, hist.Buckets[0].Min)
+	assert.Equal(2, hist.Buckets[0].Count)
+	assert.Equal(2, hist.Buckets[1].Count)
+	assert.Equal(1, hist.Buckets[2].Count)
+	assert.Equal(1, hist.Buckets[3].Count)
+
+	// No positive values
+	hist = PowerHist(2, []float64{0, -1, -8})
+	assert.Equal(Histogram{}, hist)
+}
+
+func TestHist_MinMaxCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	// 4 buckets of width 1 over [0, 4], the max value falls in the last bucket
+	hist, err := Hist(4, []float64{0, 0.5, 0.9, 1, 1.5, 2, 3, 3.5, 4, 4})
+	assert.NoError(err)
+	assert.Len(hist.Buckets, 4)
+	assert.Equal(3, hist.Buckets[0].Count)
+	assert.Equal(2, hist.Buckets[1].Count)
+	assert.Equal(1, hist.Buckets[2].Count)
+	assert.Equal(4, hist.Buckets[3].Count)
+	assert.Equal(1, hist.Min)
+	assert.Equal(4, hist.Max)
+	assert.Equal(10, hist.Count)
+
+	// An empty bucket is the smallest
+	hist, err = Hist(3, []float64{0, 0, 3})
+	assert.NoError(err)
+	assert.Equal(0, hist.Min)
+	assert.Equal(2, hist.Max)
+
+	hist = PowerHist(10, []float64{1, 2, 3, 10, 100, 200})
+	assert.Equal(1, hist.Min)
+	assert.Equal(3, hist.Max)
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	assert := assert.New(t)
+
+	// Symmetric triangular distribution around 50
+	var input []float64
+	for v := 0; v <= 100; v++ {
+		weight := 51 - iAbs(v-50)
+		for i := 0; i < weight; i++ {
+			input = append(input, float64(v))
+		}
+	}
+	hist, err := Hist(20, input)
+	assert.NoError(err)
+
+	cdf := hist.CDF()
+	assert.Len(cdf, 20)
+	assert.InDelta(1.0, cdf[19], 1e-12)
+	for i := 1; i < len(cdf); i++ {
+		assert.GreaterOrEqual(cdf[i], cdf[i-1])
+	}
+	assert.InDelta(0.5, cdf[9], 0.05)
+
+	assert.InDelta(50, hist.Quantile(0.5), 1)
+	assert.Equal(0.0, hist.Quantile(0))
+	assert.Equal(100.0, hist.Quantile(1))
+	assert.Less(hist.Quantile(0.25), hist.Quantile(0.75))
+	assert.InDelta(100-hist.Quantile(0.25), hist.Quantile(0.75), 1)
+	assert.True(math.IsNaN(hist.Quantile(1.5)))
+	assert.True(math.IsNaN(Histogram{}.Quantile(0.5)))
+}
+
+func iAbs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func TestHistRange_ClampExtremes(t *testing.T) {
+	assert := assert.New(t)
+
+	input := []float64{math.Inf(1), math.Inf(-1), 1e30, -1e30, math.NaN(), 0.5, 1.5}
+
+	// The infinite and huge values are counted in the outer buckets, NaN is skipped
+	hist, err := HistRange(2, 0, 2, input, true)
+	assert.NoError(err)
+	assert.Equal(6, hist.Count)
+	assert.Equal(3, hist.Buckets[0].Count)
+	assert.Equal(3, hist.Buckets[1].Count)
+
+	// Without clamping, they are dropped
+	hist, err = HistRange(2, 0, 2, input, false)
+	assert.NoError(err)
+	assert.Equal(2, hist.Count)
+	assert.Equal(1, hist.Buckets[0].Count)
+	assert.Equal(1, hist.Buckets[1].Count)
+}
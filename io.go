@@ -1,12 +1,21 @@
 package gonii
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	gzip "github.com/klauspost/pgzip"
 	"github.com/okieraised/gonii/internal/utils"
 	"github.com/okieraised/gonii/pkg/nifti"
+	"io"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 )
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -22,6 +31,11 @@ import (
 //   - `WithReadImageFile(niiFile string)`       : Specify an image file path
 //   - `WithReadImageReader(r *bytes.Reader)`    : Specify a header file reader in case of separate .hdr/.img file
 //   - `WithReadHeaderReader(r *bytes.Reader)`   : Specify an image file reader
+//   - `WithReadForceVersion(version int)`       : Skip the version detection and parse as the specified version
+//   - `WithReadRobustRange(low, high float64)`  : Clip the intensities to the percentiles and store them as cal range
+//   - `WithReadStrictMagic(strictMagic bool)`   : Whether to reject an unknown magic string. The default is true
+//   - `WithReadHeaderOnly(headerOnly bool)`     : Parse only the header and the extensions, without the image data
+//   - `WithReadNoAutoFix(noAutoFix bool)`       : Reject the non-positive dimensions and a bitpix mismatch instead of fixing them
 func NewNiiReader(options ...func(*nifti.NiiReader) error) (nifti.Reader, error) {
 	// Init new reader
 	reader := new(nifti.NiiReader)
@@ -54,15 +68,63 @@ func WithReadRetainHeader(retainHeader bool) func(*nifti.NiiReader) error {
 	}
 }
 
+// WithReadForceVersion allows option to skip the version detection based on the header size and parse the file as the
+// specified NIfTI version (1 or 2). This is useful for files with a corrupted sizeof_hdr field
+func WithReadForceVersion(version int) func(*nifti.NiiReader) error {
+	return func(w *nifti.NiiReader) error {
+		if version != nifti.NIIVersion1 && version != nifti.NIIVersion2 {
+			return fmt.Errorf("invalid NIfTI version %d, must be 1 or 2", version)
+		}
+		w.SetForceVersion(version)
+		return nil
+	}
+}
+
+// WithReadRobustRange allows option to clip the voxel intensities to the lowPct and highPct percentiles (0 to 100)
+// after parsing and to store the clip values in CalMin/CalMax. This mimics the FSL robust range for display
+func WithReadRobustRange(lowPct, highPct float64) func(*nifti.NiiReader) error {
+	return func(w *nifti.NiiReader) error {
+		if lowPct < 0 || highPct > 100 || lowPct >= highPct {
+			return fmt.Errorf("invalid robust range [%v, %v], must satisfy 0 <= low < high <= 100", lowPct, highPct)
+		}
+		w.SetRobustRange(lowPct, highPct)
+		return nil
+	}
+}
+
+// WithReadStrictMagic allows option to reject the files with an unknown magic string. The default is true. In lenient
+// mode, an unknown magic string such as the one of Analyze or some vendor files is accepted as long as the header size
+// and the dimensions are sane
+func WithReadStrictMagic(strictMagic bool) func(*nifti.NiiReader) error {
+	return func(w *nifti.NiiReader) error {
+		w.SetStrictMagic(strictMagic)
+		return nil
+	}
+}
+
+// WithReadHeaderOnly allows option to parse only the header and the extensions, leaving the image data unread. The
+// default is false
+func WithReadHeaderOnly(headerOnly bool) func(*nifti.NiiReader) error {
+	return func(w *nifti.NiiReader) error {
+		w.SetHeaderOnly(headerOnly)
+		return nil
+	}
+}
+
+// WithReadNoAutoFix allows option to return an error listing the non-positive dimensions (up to dim[0]) instead of
+// silently setting them to 1, and an error if bitpix disagrees with the datatype instead of recording a warning (see
+// Reader.Warnings). The default is false
+func WithReadNoAutoFix(noAutoFix bool) func(*nifti.NiiReader) error {
+	return func(w *nifti.NiiReader) error {
+		w.SetNoAutoFix(noAutoFix)
+		return nil
+	}
+}
+
 // WithReadHeaderFile allows option to specify the separate header file in case of NIfTI pair .hdr/.img
 func WithReadHeaderFile(headerFile string) func(*nifti.NiiReader) error {
 	return func(w *nifti.NiiReader) error {
-		bData, err := os.ReadFile(headerFile)
-		if err != nil {
-			return err
-		}
-		// Check the content type to see if the file is gzipped. Do not depend on just the extensions of the file
-		bData, err = deflateFileContent(bData)
+		bData, err := readFileContent(headerFile)
 		if err != nil {
 			return err
 		}
@@ -72,18 +134,27 @@ func WithReadHeaderFile(headerFile string) func(*nifti.NiiReader) error {
 }
 
 // WithReadImageFile allows option to specify the NIfTI file (.nii.gz or .nii)
+//
+// If the file is the image of a NIfTI pair (.img or .img.gz) and no header has been specified, the sibling header
+// file (.hdr or .hdr.gz) is loaded automatically
 func WithReadImageFile(niiFile string) func(*nifti.NiiReader) error {
 	return func(w *nifti.NiiReader) error {
-		bData, err := os.ReadFile(niiFile)
-		if err != nil {
-			return err
-		}
-		// Check the content type to see if the file is gzipped. Do not depend on just the extensions of the file
-		bData, err = deflateFileContent(bData)
+		bData, err := readFileContent(niiFile)
 		if err != nil {
 			return err
 		}
 		w.SetReader(bytes.NewReader(bData))
+
+		if w.GetHdrReader() == nil {
+			headerFile, ok := findPairHeaderFile(niiFile)
+			if ok {
+				bHeader, err := readFileContent(headerFile)
+				if err != nil {
+					return err
+				}
+				w.SetHdrReader(bytes.NewReader(bHeader))
+			}
+		}
 		return nil
 	}
 }
@@ -117,7 +188,7 @@ func WithReadHeaderReader(r *bytes.Reader) func(*nifti.NiiReader) error {
 		if err != nil {
 			return err
 		}
-		w.SetHdrReader(r)
+		w.SetHdrReader(bytes.NewReader(bArr))
 		return nil
 	}
 }
@@ -134,6 +205,7 @@ func NewNiiWriter(filePath string, options ...func(*nifti.NiiWriter)) (nifti.Wri
 	writer.SetWriteHeaderFile(false)     // Default to false. Write to a single file only
 	writer.SetCompression(false)         // Default to false. No compression
 	writer.SetVersion(nifti.NIIVersion1) // Default to version 1
+	writer.SetAtomic(true)               // Default to true. Write to a temporary file then rename
 
 	// Other options
 	for _, opt := range options {
@@ -151,6 +223,35 @@ func WithWriteHeaderFile(writeHeaderFile bool) func(*nifti.NiiWriter) {
 	}
 }
 
+// WithWriteLegacyPairNames sets the option to name the header/image file pair as <filePath>_nifti.hdr and
+// <filePath>_nifti.img, which was the behavior of previous versions
+//
+// If false, the pair is named <base>.hdr and <base>.img (.hdr.gz and .img.gz if compressed). Default is false.
+func WithWriteLegacyPairNames(legacyPairNames bool) func(*nifti.NiiWriter) {
+	return func(w *nifti.NiiWriter) {
+		w.SetLegacyPairNames(legacyPairNames)
+	}
+}
+
+// WithWriteAtomic sets the option to write the output to a temporary file in the same directory then rename it to the
+// target path, so that a crash mid-write never leaves a partially written file behind
+//
+// If false, the output is written directly to the target path. Default is true.
+func WithWriteAtomic(atomic bool) func(*nifti.NiiWriter) {
+	return func(w *nifti.NiiWriter) {
+		w.SetAtomic(atomic)
+	}
+}
+
+// WithWriteNoClobber sets the option to return an error instead of overwriting a file that already exists
+//
+// If false, existing files are overwritten. Default is false.
+func WithWriteNoClobber(noClobber bool) func(*nifti.NiiWriter) {
+	return func(w *nifti.NiiWriter) {
+		w.SetNoClobber(noClobber)
+	}
+}
+
 // WithWriteCompression sets the option to write compressed NIfTI image to a single file (.nii.gz)
 //
 // If true, the whole file will be compressed. Default is false.
@@ -178,6 +279,20 @@ func WithWriteNii2Header(header *nifti.Nii2Header) func(*nifti.NiiWriter) {
 	}
 }
 
+// WithWritePreserveHeader sets the option to write the header provided with WithWriteNii1Header or WithWriteNii2Header
+// verbatim, e.g. the header retained by the reader, instead of updating its magic string and vox_offset for the output
+// file type. For a single file, the raw bytes between the header and vox_offset kept by the reader with
+// WithReadRetainHeader are written back as well, unless the extensions have changed, so that an unmodified image is
+// rewritten byte for byte. An error is returned if the header does not match the output file type or the image
+//
+// If false, the magic string and vox_offset of the header are updated and the bytes between the header and vox_offset
+// hold only the extensions, zero-padded. Default is false.
+func WithWritePreserveHeader(preserveHeader bool) func(*nifti.NiiWriter) {
+	return func(w *nifti.NiiWriter) {
+		w.SetPreserveHeader(preserveHeader)
+	}
+}
+
 // WithWriteNIfTIData sets the option to allow user to provide predefined NIfTI-1 data structure.
 func WithWriteNIfTIData(data *nifti.Nii) func(writer *nifti.NiiWriter) {
 	return func(w *nifti.NiiWriter) {
@@ -192,14 +307,303 @@ func WithWriteVersion(version int) func(writer *nifti.NiiWriter) {
 	}
 }
 
+// WithWriteASCII sets the option to write the NIfTI image as NIfTI-ASCII (.nia), i.e. a text header followed by the
+// binary image data
+//
+// If true, the header is written as text and the version option is ignored. Default is false.
+func WithWriteASCII(writeASCII bool) func(*nifti.NiiWriter) {
+	return func(w *nifti.NiiWriter) {
+		w.SetWriteASCII(writeASCII)
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Define one-shot methods
+//----------------------------------------------------------------------------------------------------------------------
+
+// ReadFile reads and parses the NIfTI file (.nii, .nii.gz or a .hdr/.img pair) and returns the image data. For a
+// NIfTI pair, either the header or the image file can be specified
+func ReadFile(path string) (*nifti.Nii, error) {
+	rd, err := newFileReader(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return rd.GetNiiData(), nil
+}
+
+// ReadFileWithHeader reads and parses the NIfTI-1 file (.nii, .nii.gz or a .hdr/.img pair) and returns the image data
+// and the raw header. An error is returned if the file is not NIfTI-1
+func ReadFileWithHeader(path string) (*nifti.Nii, *nifti.Nii1Header, error) {
+	rd, err := newFileReader(path, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, ok := rd.GetHeader(false).(*nifti.Nii1Header)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a NIfTI-1 file", path)
+	}
+	return rd.GetNiiData(), header, nil
+}
+
+// WriteFile writes the NIfTI image to the file, compressed with gzip if compression is true. The image is written as a
+// .hdr/.img pair if the path ends with '.hdr' or '.img', otherwise as a single file. The NIfTI version of the image is
+// kept, defaulting to NIfTI-1
+func WriteFile(path string, img *nifti.Nii, compression bool) error {
+	if img == nil {
+		return errors.New("image data structure is nil")
+	}
+
+	options := []func(*nifti.NiiWriter){
+		WithWriteNIfTIData(img),
+		WithWriteCompression(compression),
+	}
+	if img.Version == nifti.NIIVersion2 {
+		options = append(options, WithWriteVersion(nifti.NIIVersion2))
+	}
+	base := strings.TrimSuffix(path, nifti.NIFTI_COMPRESSED_EXT)
+	if strings.HasSuffix(base, nifti.NIFTI_PAIR_HDR_EXT) || strings.HasSuffix(base, nifti.NIFTI_PAIR_IMG_EXT) {
+		options = append(options, WithWriteHeaderFile(true))
+	}
+
+	writer, err := NewNiiWriter(path, options...)
+	if err != nil {
+		return err
+	}
+	return writer.WriteToFile()
+}
+
+// ReadDir finds the NIfTI files (.nii and .nii.gz) in the directory and its subdirectories and parses each of them with
+// the reader options, e.g. WithReadHeaderOnly(true) to skip the image data. A file that fails to parse does not abort
+// the batch: the parsed images are returned in lexical order of their paths along with an error for each failed file
+func ReadDir(dir string, options ...func(*nifti.NiiReader) error) ([]*nifti.Nii, []error) {
+	files, err := utils.ReadDirRecursively(dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var images []*nifti.Nii
+	var errs []error
+	for _, file := range files {
+		if !strings.HasSuffix(file, nifti.NIFTI_EXT) && !strings.HasSuffix(file, nifti.NIFTI_EXT+nifti.NIFTI_COMPRESSED_EXT) {
+			continue
+		}
+		rd, err := NewNiiReader(append([]func(*nifti.NiiReader) error{WithReadImageFile(file)}, options...)...)
+		if err == nil {
+			err = rd.Parse()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file, err))
+			continue
+		}
+		images = append(images, rd.GetNiiData())
+	}
+	return images, errs
+}
+
+// ReadFromArchive extracts the NIfTI file memberPath (.nii or .nii.gz) from the zip or tar (optionally gzipped)
+// archive into memory and parses it
+func ReadFromArchive(archivePath, memberPath string) (*nifti.Nii, error) {
+	bData, err := readArchiveMember(archivePath, memberPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	if err != nil {
+		return nil, err
+	}
+	err = rd.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return rd.GetNiiData(), nil
+}
+
+// EstimateMemory reads only the header of the NIfTI file (.nii, .nii.gz or a .hdr/.img pair) and returns the expected
+// memory usage in bytes of reading it with ReadFile. This is the size of the image data (NVox*NByPer), held once as
+// the file content and once as the parsed volume, plus the header and, for gzipped files, the compressed content read
+// before inflating
+func EstimateMemory(path string) (int64, error) {
+	headerFile, imageFile := path, path
+	if imgFile, ok := findPairFile(path, nifti.NIFTI_PAIR_HDR_EXT, nifti.NIFTI_PAIR_IMG_EXT); ok {
+		imageFile = imgFile
+	} else if hdrFile, ok := findPairHeaderFile(path); ok {
+		headerFile = hdrFile
+	}
+
+	bHeader, _, err := utils.ReadFileHead(headerFile, nifti.NII2HeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bHeader)))
+	if err != nil {
+		return 0, err
+	}
+	hdrReader := rd.(nifti.HeaderReader)
+	err = hdrReader.ParseHeader()
+	if err != nil {
+		return 0, err
+	}
+	img := rd.GetNiiData()
+	dataSize := img.NVox * int64(img.NByPer)
+
+	// The parsed volume and the inflated file content
+	estimate := 2 * dataSize
+	if headerFile != imageFile {
+		estimate += int64(len(hdrReader.RawHeaderBytes()))
+	} else {
+		estimate += int64(img.VoxOffset)
+	}
+
+	// The compressed file content
+	files := []string{imageFile}
+	if headerFile != imageFile {
+		files = append(files, headerFile)
+	}
+	for _, file := range files {
+		_, compressed, err := utils.ReadFileHead(file, 0)
+		if err != nil {
+			return 0, err
+		}
+		if compressed {
+			info, err := os.Stat(file)
+			if err != nil {
+				return 0, err
+			}
+			estimate += info.Size()
+		}
+	}
+	return estimate, nil
+}
+
+// newFileReader returns the reader after parsing the file. If the path is the header of a NIfTI pair, the image file
+// is loaded from the sibling file
+func newFileReader(path string, retainHeader bool) (nifti.Reader, error) {
+	options := []func(*nifti.NiiReader) error{WithReadRetainHeader(retainHeader)}
+	if imgFile, ok := findPairFile(path, nifti.NIFTI_PAIR_HDR_EXT, nifti.NIFTI_PAIR_IMG_EXT); ok {
+		options = append(options, WithReadHeaderFile(path), WithReadImageFile(imgFile))
+	} else {
+		options = append(options, WithReadImageFile(path))
+	}
+
+	rd, err := NewNiiReader(options...)
+	if err != nil {
+		return nil, err
+	}
+	err = rd.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 // Define Support function
 //----------------------------------------------------------------------------------------------------------------------
 
+// readFileContent reads the file and deflates its content if the file is gzipped
+func readFileContent(filePath string) ([]byte, error) {
+	bData, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// Check the content type to see if the file is gzipped. Do not depend on just the extensions of the file
+	return deflateFileContent(bData)
+}
+
+// readArchiveMember returns the content of the member of the zip or tar (optionally gzipped) archive. The archive is
+// read from the file, only the matching member is loaded into memory
+func readArchiveMember(archivePath, memberPath string) ([]byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	memberPath = path.Clean(memberPath)
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+
+	// Zip archive, whose central directory is read from the end of the file
+	if bytes.Equal(magic, []byte("PK\x03\x04")) {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			if path.Clean(zf.Name) != memberPath {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+		return nil, fmt.Errorf("member %s not found in %s", memberPath, archivePath)
+	}
+
+	// Tar archive, possibly gzipped, streamed until the member
+	var r io.Reader = br
+	if len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		g, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer g.Close()
+		r = g
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("member %s not found in %s", memberPath, archivePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(hdr.Name) == memberPath {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// findPairHeaderFile returns the path of the existing header file (.hdr or .hdr.gz) next to a NIfTI pair image file
+func findPairHeaderFile(imgFile string) (string, bool) {
+	return findPairFile(imgFile, nifti.NIFTI_PAIR_IMG_EXT, nifti.NIFTI_PAIR_HDR_EXT)
+}
+
+// findPairFile returns the path of the existing sibling file of a NIfTI pair, e.g. the header file (.hdr or .hdr.gz)
+// next to an image file (.img or .img.gz) when fromExt is '.img' and toExt is '.hdr'
+func findPairFile(filePath, fromExt, toExt string) (string, bool) {
+	base := strings.TrimSuffix(filePath, nifti.NIFTI_COMPRESSED_EXT)
+	if !strings.HasSuffix(base, fromExt) {
+		return "", false
+	}
+	base = strings.TrimSuffix(base, fromExt)
+
+	for _, candidate := range []string{
+		base + toExt,
+		base + toExt + nifti.NIFTI_COMPRESSED_EXT,
+	} {
+		info, err := os.Stat(candidate)
+		if err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
 // deflateFileContent deflates the gzipped binary to its original content
 func deflateFileContent(bData []byte) ([]byte, error) {
 	var err error
-	mimeType := http.DetectContentType(bData[:512])
+	// DetectContentType only considers the first 512 bytes, shorter content such as a bare .hdr file is fine
+	mimeType := http.DetectContentType(bData)
 	if mimeType == "application/x-gzip" {
 		bData, err = utils.DeflateGzip(bData)
 		if err != nil {
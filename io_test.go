@@ -1,13 +1,21 @@
 package gonii
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"fmt"
 	"github.com/okieraised/gonii/pkg/matrix"
 	"github.com/okieraised/gonii/pkg/nifti"
 	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -261,3 +269,1409 @@ func TestNiiOrientation(t *testing.T) {
 	err = writer.WriteToFile()
 	assert.NoError(err)
 }
+
+// newTestImage returns a small NIfTI-1 image structure with unit spacing and a zero-filled volume
+func newTestImage(nx, ny, nz, nt int64, datatype int32, byteOrder binary.ByteOrder) *nifti.Nii {
+	nByPer, swapSize := nifti.AssignDatatypeSize(datatype)
+	img := &nifti.Nii{
+		NDim:      4,
+		Nx:        nx,
+		Ny:        ny,
+		Nz:        nz,
+		Nt:        nt,
+		Nu:        1,
+		Nv:        1,
+		Nw:        1,
+		Dim:       [8]int64{4, nx, ny, nz, nt, 1, 1, 1},
+		NVox:      nx * ny * nz * nt,
+		NByPer:    int32(nByPer),
+		SwapSize:  int32(swapSize),
+		Datatype:  datatype,
+		Dx:        1,
+		Dy:        1,
+		Dz:        1,
+		Dt:        1,
+		PixDim:    [8]float64{1, 1, 1, 1, 1, 1, 1, 1},
+		ByteOrder: byteOrder,
+		Version:   nifti.NIIVersion1,
+	}
+	img.Volume = make([]byte, img.NVox*int64(nByPer))
+	return img
+}
+
+func TestNewNiiWriter_PreserveByteOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.BigEndian)
+	err := img.SetAt(1234, 3, 2, 1, 0)
+	assert.NoError(err)
+
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+	assert.Equal(int32(nifti.NII1HeaderSize), int32(binary.BigEndian.Uint32(bData[:4])))
+
+	// Read the big-endian image back and write it again
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(binary.BigEndian, rd.GetBinaryOrder())
+	assert.Equal(1234.0, rd.GetNiiData().GetAt(3, 2, 1, 0))
+
+	writer, err = NewNiiWriter("", WithWriteNIfTIData(rd.GetNiiData()))
+	assert.NoError(err)
+	bRewritten, err := writer.WriteToBytes()
+	assert.NoError(err)
+	assert.Equal(int32(nifti.NII1HeaderSize), int32(binary.BigEndian.Uint32(bRewritten[:4])))
+	assert.Equal(bData[nifti.NII1HeaderSize+nifti.DefaultHeaderPadding:], bRewritten[nifti.NII1HeaderSize+nifti.DefaultHeaderPadding:])
+}
+
+// makeNii1WithExtensions returns a single NIfTI-1 file content with the given extensions placed after the header
+func makeNii1WithExtensions(extensions []nifti.Nifti1Ext) []byte {
+	dim := [8]int16{3, 8, 8, 4, 1, 1, 1, 1}
+	header := nifti.MakeNewNii1Header(&dim, nifti.DT_INT16)
+
+	extBuf := &bytes.Buffer{}
+	extBuf.Write([]byte{1, 0, 0, 0})
+	for _, ext := range extensions {
+		_ = binary.Write(extBuf, binary.LittleEndian, ext.ESize)
+		_ = binary.Write(extBuf, binary.LittleEndian, ext.ECode)
+		extBuf.Write(ext.EData)
+	}
+	header.VoxOffset = float32(nifti.NII1HeaderSize + extBuf.Len())
+
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.LittleEndian, header)
+	buf.Write(extBuf.Bytes())
+	buf.Write(make([]byte, 8*8*4*2))
+	return buf.Bytes()
+}
+
+func TestNewNiiReader_DICOMExtension(t *testing.T) {
+	assert := assert.New(t)
+
+	dicom := make([]byte, 24)
+	copy(dicom, "DICM\x02\x00\x10\x00UI")
+	bData := makeNii1WithExtensions([]nifti.Nifti1Ext{
+		{ECode: nifti.NIFTI_ECODE_COMMENT, ESize: 16, EData: []byte("comment\x00")},
+		{ECode: nifti.NIFTI_ECODE_DICOM, ESize: 32, EData: dicom},
+	})
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+
+	assert.Equal(int32(2), rd.GetNiiData().NumExt)
+	eData, ok := rd.GetNiiData().DICOMExtension()
+	assert.True(ok)
+	assert.Equal(dicom, eData)
+
+	// Files without extensions do not report any
+	rd, err = NewNiiReader(WithReadImageFile("./test_data/int16.nii.gz"))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	_, ok = rd.GetNiiData().DICOMExtension()
+	assert.False(ok)
+}
+
+func TestNewNiiWriter_Extension(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	img.AddExtension(nifti.NIFTI_ECODE_COMMENT, []byte("gonii test"))
+	assert.Equal(int32(1), img.NumExt)
+	assert.Equal(int32(32), img.Nifti1Ext[0].ESize)
+	assert.Len(img.Nifti1Ext[0].EData, 24)
+
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// extender, esize and ecode right after the header
+	offset := nifti.NII1HeaderSize
+	assert.Equal([]byte{1, 0, 0, 0}, bData[offset:offset+4])
+	assert.Equal(uint32(32), binary.LittleEndian.Uint32(bData[offset+4:offset+8]))
+	assert.Equal(uint32(nifti.NIFTI_ECODE_COMMENT), binary.LittleEndian.Uint32(bData[offset+8:offset+12]))
+	assert.Equal(float32(nifti.NII1HeaderSize+4+32), writer.GetHeader().(*nifti.Nii1Header).VoxOffset)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(img.Nifti1Ext, rd.GetNiiData().Nifti1Ext)
+	assert.Equal(img.Volume, rd.GetNiiData().Volume)
+}
+
+func TestNewNiiWriter_ASCII(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 6, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	img.NDim = 3
+	err := img.SetAt(42, 1, 2, 3, 0)
+	assert.NoError(err)
+
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img), WithWriteASCII(true))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	end := bytes.Index(bData, []byte("/>\n")) + 3
+	textHeader := string(bData[:end])
+	assert.True(strings.HasPrefix(textHeader, "<nifti_image\n"))
+	assert.Contains(textHeader, "ndim = '3'")
+	assert.Contains(textHeader, "nx = '8'")
+	assert.Contains(textHeader, "ny = '6'")
+	assert.Contains(textHeader, "nz = '4'")
+	assert.Contains(textHeader, "datatype = 'INT16'")
+	assert.Contains(textHeader, "byteorder = 'LSB_FIRST'")
+	assert.Contains(textHeader, fmt.Sprintf("image_offset = '%d'", end))
+	assert.Equal(img.Volume, bData[end:])
+
+	filePath := t.TempDir() + "/ascii"
+	writer, err = NewNiiWriter(filePath, WithWriteNIfTIData(img), WithWriteASCII(true))
+	assert.NoError(err)
+	err = writer.WriteToFile()
+	assert.NoError(err)
+	bFile, err := os.ReadFile(filePath + nifti.NIFTI_ASCII_EXT)
+	assert.NoError(err)
+	assert.Equal(bData, bFile)
+}
+
+func TestNewNiiReader_PairHeaderDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	rdPair, err := NewNiiReader(WithReadImageFile("./test_data/t1.img.gz"), WithReadHeaderFile("./test_data/t1.hdr.gz"))
+	assert.NoError(err)
+	err = rdPair.Parse()
+	assert.NoError(err)
+
+	// Only the image file is specified, the header is discovered from the sibling file
+	rd, err := NewNiiReader(WithReadImageFile("./test_data/t1.img.gz"))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+
+	assert.Equal(rdPair.GetNiiData().GetImgShape(), rd.GetNiiData().GetImgShape())
+	assert.Equal(rdPair.GetNiiData().GetAffine(), rd.GetNiiData().GetAffine())
+	assert.Equal(rdPair.GetNiiData().Volume, rd.GetNiiData().Volume)
+}
+
+func TestNewNiiWriter_PairFileNames(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+
+	cases := []struct {
+		filePath    string
+		compression bool
+		legacy      bool
+		expected    []string
+	}{
+		{"plain.img", false, false, []string{"plain.hdr", "plain.img"}},
+		{"noext", false, false, []string{"noext.hdr", "noext.img"}},
+		{"gzipped.nii.gz", false, false, []string{"gzipped.hdr.gz", "gzipped.img.gz"}},
+		{"compressed.hdr", true, false, []string{"compressed.hdr.gz", "compressed.img.gz"}},
+		{"legacy", false, true, []string{"legacy_nifti.hdr", "legacy_nifti.img"}},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		writer, err := NewNiiWriter(dir+"/"+c.filePath,
+			WithWriteNIfTIData(img),
+			WithWriteHeaderFile(true),
+			WithWriteCompression(c.compression),
+			WithWriteLegacyPairNames(c.legacy),
+		)
+		assert.NoError(err)
+		err = writer.WriteToFile()
+		assert.NoError(err)
+
+		entries, err := os.ReadDir(dir)
+		assert.NoError(err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Equal(c.expected, names, c.filePath)
+	}
+}
+
+func TestNewNiiWriter_PairMagic(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	dim := [8]int16{3, 8, 8, 4, 1, 1, 1, 1}
+
+	// Auto-converted header and a user-specified single file header
+	for _, header := range []*nifti.Nii1Header{nil, nifti.MakeNewNii1Header(&dim, nifti.DT_INT16)} {
+		dir := t.TempDir()
+		opts := []func(*nifti.NiiWriter){WithWriteNIfTIData(img), WithWriteHeaderFile(true)}
+		if header != nil {
+			opts = append(opts, WithWriteNii1Header(header))
+		}
+		writer, err := NewNiiWriter(dir+"/pair", opts...)
+		assert.NoError(err)
+		err = writer.WriteToFile()
+		assert.NoError(err)
+
+		bHeader, err := os.ReadFile(dir + "/pair.hdr")
+		assert.NoError(err)
+		assert.Equal(nifti.NIFTI_1_MAGIC_PAIR[:], bHeader[344:348])
+		assert.Equal(float32(0), writer.GetHeader().(*nifti.Nii1Header).VoxOffset)
+
+		writer, err = NewNiiWriter(dir+"/single.nii", append(opts, WithWriteHeaderFile(false))...)
+		assert.NoError(err)
+		err = writer.WriteToFile()
+		assert.NoError(err)
+
+		bSingle, err := os.ReadFile(dir + "/single.nii")
+		assert.NoError(err)
+		assert.Equal(nifti.NIFTI_1_MAGIC_SINGLE[:], bSingle[344:348])
+	}
+}
+
+func TestNiiReader_ReadVolumeAt(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 3, nifti.DT_INT16, binary.LittleEndian)
+	for i := int64(0); i < 3; i++ {
+		err := img.SetAt(float64(100*(i+1)), i, 2*i, i, i)
+		assert.NoError(err)
+	}
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	rdFull, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rdFull.Parse()
+	assert.NoError(err)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	parsed := rd.GetNiiData()
+	assert.NoError(parsed.SetDescrip("edited after parsing"))
+	for tp := int64(0); tp < 3; tp++ {
+		volume, err := rd.(nifti.PartialReader).ReadVolumeAt(tp)
+		assert.NoError(err)
+		assert.Equal(int64(3), volume.NDim)
+		assert.Equal(int64(1), volume.Nt)
+		assert.Equal(int64(8*8*4), volume.NVox)
+
+		expected, err := rdFull.GetNiiData().GetVolume(tp)
+		assert.NoError(err)
+		actual, err := volume.GetVolume(0)
+		assert.NoError(err)
+		assert.Equal(expected, actual)
+		assert.Equal(float64(100*(tp+1)), volume.GetAt(tp, 2*tp, tp, 0))
+	}
+
+	_, err = rd.(nifti.PartialReader).ReadVolumeAt(3)
+	assert.Error(err)
+
+	// The partial reads leave the parsed image untouched
+	_, err = rd.(nifti.PartialReader).ReadSliceAt(1, 2)
+	assert.NoError(err)
+	assert.Same(parsed, rd.GetNiiData())
+	assert.Equal("edited after parsing", parsed.GetDescrip())
+	assert.Equal(int64(3), parsed.Nt)
+	assert.Equal(img.VolumeHash(), parsed.VolumeHash())
+}
+
+func TestNiiReader_Clone(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 6, 3, nifti.DT_INT16, binary.LittleEndian)
+	for z := int64(0); z < 6; z++ {
+		for tp := int64(0); tp < 3; tp++ {
+			err := img.SetAt(float64(10*z+tp), 1, 2, z, tp)
+			assert.NoError(err)
+		}
+	}
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+
+	var wg sync.WaitGroup
+	results := make([]float64, 6*3)
+	errs := make([]error, 6*3)
+	for z := int64(0); z < 6; z++ {
+		for tp := int64(0); tp < 3; tp++ {
+			wg.Add(1)
+			go func(clone nifti.Reader, z, tp int64) {
+				defer wg.Done()
+				slice, err := clone.(nifti.PartialReader).ReadSliceAt(z, tp)
+				if err != nil {
+					errs[z*3+tp] = err
+					return
+				}
+				results[z*3+tp] = slice.GetAt(1, 2, 0, 0)
+			}(rd.(nifti.CloneableReader).Clone(), z, tp)
+		}
+	}
+	wg.Wait()
+
+	for z := int64(0); z < 6; z++ {
+		for tp := int64(0); tp < 3; tp++ {
+			assert.NoError(errs[z*3+tp])
+			assert.Equal(float64(10*z+tp), results[z*3+tp])
+		}
+	}
+
+	// The original reader is untouched by the clones
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(img.VolumeHash(), rd.GetNiiData().VolumeHash())
+}
+
+func TestNiiReader_ReadSliceChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 2, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(7, 1, 1, 2, 1)
+	assert.NoError(err)
+	img.SetIdentityAffine([3]float64{1, 1, 2})
+	img.StoXYZ.M[2][3], img.QtoXYZ.M[2][3] = -30, -30
+	img.QformCode = nifti.NIFTI_XFORM_SCANNER_ANAT
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+
+	slice, err := rd.(nifti.PartialReader).ReadSliceAt(2, 1)
+	assert.NoError(err)
+	expected, err := img.GetSlice(2, 1)
+	assert.NoError(err)
+	actual, err := slice.GetSlice(0, 0)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+
+	// The voxel (i, j, 0) of the slice is at the world position of the voxel (i, j, 2) of the image
+	assert.Equal(-30+2*2.0, slice.StoXYZ.M[2][3])
+	assert.Equal(-30+2*2.0, slice.QtoXYZ.M[2][3])
+	assert.Equal(-30+2*2.0, slice.QoffsetZ)
+	assert.Equal(-30+2*2.0, slice.Affine.M[2][3])
+
+	// The checksum is stable across reads and matches the raw slice bytes
+	checksum, err := rd.(nifti.PartialReader).ReadSliceChecksum(2, 1)
+	assert.NoError(err)
+	checksumAgain, err := rd.(nifti.PartialReader).ReadSliceChecksum(2, 1)
+	assert.NoError(err)
+	assert.Equal(checksum, checksumAgain)
+	assert.Equal(crc32.ChecksumIEEE(slice.Volume), checksum)
+
+	// A different slice yields a different checksum
+	other, err := rd.(nifti.PartialReader).ReadSliceChecksum(2, 0)
+	assert.NoError(err)
+	assert.NotEqual(checksum, other)
+
+	_, err = rd.(nifti.PartialReader).ReadSliceChecksum(4, 0)
+	assert.Error(err)
+}
+
+func TestNewNiiReader_ForceVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(5, 1, 2, 3, 0)
+	assert.NoError(err)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// Corrupt the sizeof_hdr field
+	binary.LittleEndian.PutUint32(bData[:4], 0)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	assert.Error(rd.Parse())
+
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadForceVersion(1))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(nifti.NIIVersion1, rd.(*nifti.NiiReader).GetVersion())
+	assert.Equal(5.0, rd.GetNiiData().GetAt(1, 2, 3, 0))
+
+	_, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadForceVersion(3))
+	assert.Error(err)
+}
+
+func TestNewNiiReader_BitpixMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(-300, 7, 7, 3, 0)
+	assert.NoError(err)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// bitpix says 8 bits per voxel while the datatype is INT16
+	binary.LittleEndian.PutUint16(bData[72:74], 8)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Len(rd.GetNiiData().Volume, len(img.Volume))
+	assert.Equal(-300.0, rd.GetNiiData().GetAt(7, 7, 3, 0))
+	assert.Equal([]string{"bitpix 8 does not match datatype INT16, using 16"}, rd.(nifti.HeaderReader).Warnings())
+
+	// The mismatch is an error in strict mode
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadNoAutoFix(true))
+	assert.NoError(err)
+	assert.Error(rd.Parse())
+}
+
+func TestNewNiiWriter_Atomic(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	dir := t.TempDir()
+
+	for _, opts := range [][]func(*nifti.NiiWriter){
+		{WithWriteNIfTIData(img)},
+		{WithWriteNIfTIData(img), WithWriteCompression(true)},
+		{WithWriteNIfTIData(img), WithWriteHeaderFile(true)},
+	} {
+		writer, err := NewNiiWriter(dir+"/atomic.nii", opts...)
+		assert.NoError(err)
+		err = writer.WriteToFile()
+		assert.NoError(err)
+	}
+
+	// Only the final files remain, no temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	assert.NoError(err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal([]string{"atomic.hdr", "atomic.img", "atomic.nii", "atomic.nii.gz"}, names)
+
+	rd, err := NewNiiReader(WithReadImageFile(dir + "/atomic.nii.gz"))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(img.Volume, rd.GetNiiData().Volume)
+}
+
+func TestNewNiiWriter_NoClobber(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	filePath := t.TempDir() + "/existing.nii"
+	err := os.WriteFile(filePath, []byte("do not overwrite"), 0644)
+	assert.NoError(err)
+
+	writer, err := NewNiiWriter(filePath, WithWriteNIfTIData(img), WithWriteNoClobber(true))
+	assert.NoError(err)
+	err = writer.WriteToFile()
+	assert.Error(err)
+
+	bData, err := os.ReadFile(filePath)
+	assert.NoError(err)
+	assert.Equal([]byte("do not overwrite"), bData)
+
+	// The atomic write does not replace the file either and removes its temporary file
+	writer, err = NewNiiWriter(filePath, WithWriteNIfTIData(img), WithWriteNoClobber(true), WithWriteAtomic(true))
+	assert.NoError(err)
+	err = writer.WriteToFile()
+	assert.Error(err)
+	bData, err = os.ReadFile(filePath)
+	assert.NoError(err)
+	assert.Equal([]byte("do not overwrite"), bData)
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	assert.NoError(err)
+	assert.Len(entries, 1)
+
+	// A new file is written with the option
+	newFilePath := filepath.Join(filepath.Dir(filePath), "new.nii")
+	writer, err = NewNiiWriter(newFilePath, WithWriteNIfTIData(img), WithWriteNoClobber(true), WithWriteAtomic(true))
+	assert.NoError(err)
+	assert.NoError(writer.WriteToFile())
+	entries, err = os.ReadDir(filepath.Dir(filePath))
+	assert.NoError(err)
+	assert.Len(entries, 2)
+
+	// Without the option the file is overwritten
+	writer, err = NewNiiWriter(filePath, WithWriteNIfTIData(img))
+	assert.NoError(err)
+	err = writer.WriteToFile()
+	assert.NoError(err)
+	bData, err = os.ReadFile(filePath)
+	assert.NoError(err)
+	assert.NotEqual([]byte("do not overwrite"), bData)
+}
+
+func TestNewNiiReader_DimInfoNames(t *testing.T) {
+	assert := assert.New(t)
+
+	// None of the fixtures have dim_info set
+	rd, err := NewNiiReader(WithReadImageFile("./test_data/int16.nii.gz"))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal("unset", rd.GetNiiData().FreqDimName())
+	assert.Equal("unset", rd.GetNiiData().PhaseDimName())
+	assert.Equal("unset", rd.GetNiiData().SliceDimName())
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	img.SetFreqDim(1)
+	img.SetPhaseDim(2)
+	img.SetSliceDim(3)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+	assert.Equal(byte(1|2<<2|3<<4), bData[39])
+
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal("i", rd.GetNiiData().FreqDimName())
+	assert.Equal("j", rd.GetNiiData().PhaseDimName())
+	assert.Equal("k", rd.GetNiiData().SliceDimName())
+}
+
+func TestReadFile(t *testing.T) {
+	assert := assert.New(t)
+
+	// .nii.gz
+	img, err := ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal(nifti.DT_INT16, img.Datatype)
+
+	// .nii
+	filePath := t.TempDir() + "/int16.nii"
+	writer, err := NewNiiWriter(filePath, WithWriteNIfTIData(img))
+	assert.NoError(err)
+	err = writer.WriteToFile()
+	assert.NoError(err)
+	imgNii, header, err := ReadFileWithHeader(filePath)
+	assert.NoError(err)
+	assert.Equal(img.Volume, imgNii.Volume)
+	assert.Equal(nifti.NIFTI_1_MAGIC_SINGLE, header.Magic)
+
+	// .hdr/.img pair, specified by either file
+	imgPair, err := ReadFile("./test_data/t1.img.gz")
+	assert.NoError(err)
+	imgPairHdr, header, err := ReadFileWithHeader("./test_data/t1.hdr.gz")
+	assert.NoError(err)
+	assert.Equal(imgPair.Volume, imgPairHdr.Volume)
+	assert.Equal(nifti.NIFTI_1_MAGIC_PAIR, header.Magic)
+
+	// NIfTI-2 has no NIfTI-1 header
+	_, err = ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	_, _, err = ReadFileWithHeader("./test_data/nii2_LR.nii.gz")
+	assert.Error(err)
+
+	_, err = ReadFile("./test_data/does_not_exist.nii")
+	assert.Error(err)
+}
+
+func TestWriteFile(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(321, 4, 3, 2, 0)
+	assert.NoError(err)
+	dir := t.TempDir()
+
+	err = WriteFile(dir+"/single.nii", img, true)
+	assert.NoError(err)
+	rewritten, err := ReadFile(dir + "/single.nii.gz")
+	assert.NoError(err)
+	assert.Equal(img.Volume, rewritten.Volume)
+	assert.Equal(321.0, rewritten.GetAt(4, 3, 2, 0))
+
+	err = WriteFile(dir+"/pair.hdr", img, false)
+	assert.NoError(err)
+	rewritten, err = ReadFile(dir + "/pair.img")
+	assert.NoError(err)
+	assert.Equal(img.Volume, rewritten.Volume)
+
+	err = WriteFile(dir+"/nil.nii", nil, false)
+	assert.Error(err)
+}
+
+func TestNewNiiReader_RobustRange(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(10, 10, 1, 1, nifti.DT_FLOAT32, binary.LittleEndian)
+	for y := int64(0); y < 10; y++ {
+		for x := int64(0); x < 10; x++ {
+			err := img.SetAt(float64(y*10+x), x, y, 0, 0)
+			assert.NoError(err)
+		}
+	}
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadRobustRange(2, 98))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+
+	// Values 0 to 99: the 2nd percentile is at rank 1.98 and the 98th percentile at rank 97.02
+	data := rd.GetNiiData()
+	assert.InDelta(1.98, data.CalMin, 1e-9)
+	assert.InDelta(97.02, data.CalMax, 1e-9)
+	assert.InDelta(1.98, data.GetAt(0, 0, 0, 0), 1e-5)
+	assert.InDelta(97.02, data.GetAt(9, 9, 0, 0), 1e-5)
+	assert.Equal(50.0, data.GetAt(0, 5, 0, 0))
+
+	_, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadRobustRange(98, 2))
+	assert.Error(err)
+}
+
+func TestNiiReader_RawHeaderBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	for filePath, size := range map[string]int{
+		"./test_data/int16.nii.gz":   nifti.NII1HeaderSize,
+		"./test_data/nii2_LR.nii.gz": nifti.NII2HeaderSize,
+	} {
+		rd, err := NewNiiReader(WithReadImageFile(filePath))
+		assert.NoError(err)
+		assert.Nil(rd.(nifti.HeaderReader).RawHeaderBytes())
+
+		err = rd.Parse()
+		assert.NoError(err)
+		raw := rd.(nifti.HeaderReader).RawHeaderBytes()
+		assert.Len(raw, size, filePath)
+		assert.Equal(int32(size), int32(binary.LittleEndian.Uint32(raw[0:4])), filePath)
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, filePath := range []string{
+		"./test_data/int16.nii.gz",
+		"./test_data/nii2_LR.nii.gz",
+		"./test_data/t1.img.gz",
+	} {
+		estimate, err := EstimateMemory(filePath)
+		assert.NoError(err)
+
+		img, err := ReadFile(filePath)
+		assert.NoError(err)
+		volumeSize := int64(len(img.Volume))
+
+		// The image data is held twice, the overhead is bounded by the header and the compressed file size
+		var compressedSize int64
+		for _, file := range []string{filePath, strings.Replace(filePath, ".img", ".hdr", 1)} {
+			info, err := os.Stat(file)
+			if err == nil {
+				compressedSize += info.Size()
+			}
+		}
+		assert.GreaterOrEqual(estimate, 2*volumeSize, filePath)
+		assert.LessOrEqual(estimate, 2*volumeSize+int64(img.VoxOffset)+nifti.NII2HeaderSize+compressedSize, filePath)
+	}
+
+	_, err := EstimateMemory("./test_data/missing.nii")
+	assert.Error(err)
+}
+
+func TestNewNiiReader_StrictMagic(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(42, 1, 2, 3, 0)
+	assert.NoError(err)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// Vendor specific magic string
+	copy(bData[344:348], "abc\x00")
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.Error(err)
+
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadStrictMagic(false))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	assert.Equal(42.0, rd.GetNiiData().GetAt(1, 2, 3, 0))
+
+	// Insane dimensions are still rejected in lenient mode
+	binary.LittleEndian.PutUint16(bData[42:44], 0)
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadStrictMagic(false))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.Error(err)
+}
+
+func TestNewNiiReader_SwappedDims(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 6, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(42, 7, 5, 3, 0)
+	assert.NoError(err)
+
+	for _, tc := range []struct {
+		version          int
+		dimOffset, width int
+	}{
+		{version: nifti.NIIVersion1, dimOffset: 40, width: 2},
+		{version: nifti.NIIVersion2, dimOffset: 16, width: 8},
+	} {
+		writer, err := NewNiiWriter("", WithWriteNIfTIData(img), WithWriteVersion(tc.version))
+		assert.NoError(err)
+		bData, err := writer.WriteToBytes()
+		assert.NoError(err)
+
+		// Only the dim fields are written byte-swapped, the header size is correct
+		for i := 0; i < 8; i++ {
+			field := bData[tc.dimOffset+i*tc.width : tc.dimOffset+(i+1)*tc.width]
+			for l, r := 0, len(field)-1; l < r; l, r = l+1, r-1 {
+				field[l], field[r] = field[r], field[l]
+			}
+		}
+
+		rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+		assert.NoError(err)
+		err = rd.Parse()
+		assert.NoError(err)
+		data := rd.GetNiiData()
+		assert.Equal([4]int64{8, 6, 4, 1}, data.GetImgShape(), "version %d", tc.version)
+		assert.Equal(binary.LittleEndian, data.ByteOrder)
+		assert.Equal(42.0, data.GetAt(7, 5, 3, 0))
+	}
+}
+
+func TestNewNiiWriter_QformWriteBack(t *testing.T) {
+	assert := assert.New(t)
+
+	rd, err := NewNiiReader(WithReadImageFile("./test_data/int16.nii.gz"))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.NoError(err)
+	img := rd.GetNiiData()
+	img.QformCode, img.SformCode = nifti.NIFTI_XFORM_SCANNER_ANAT, nifti.NIFTI_XFORM_SCANNER_ANAT
+
+	// Flip the x axis and shift the origin, the qfac does not change but the quaternion does
+	affine := matrix.DMat44{M: [4][4]float64{
+		{-2, 0, 0, 90},
+		{0, 2, 0, -126},
+		{0, 0, 2, -72},
+		{0, 0, 0, 1},
+	}}
+
+	checkQform := func(img *nifti.Nii, expected matrix.DMat44) {
+		writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+		assert.NoError(err)
+		bData, err := writer.WriteToBytes()
+		assert.NoError(err)
+
+		rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+		assert.NoError(err)
+		err = rd.Parse()
+		assert.NoError(err)
+		written := rd.GetNiiData()
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				assert.InDelta(expected.M[i][j], written.QtoXYZ.M[i][j], 1e-4)
+				assert.InDelta(expected.M[i][j], written.StoXYZ.M[i][j], 1e-4)
+			}
+		}
+	}
+
+	img.SetAffine(affine)
+	checkQform(img, affine)
+
+	// Changing the qform matrix directly, the quaternion is recomputed on write
+	affine.M[2][2] = -3
+	img.QtoXYZ = affine
+	img.StoXYZ = affine
+	checkQform(img, affine)
+}
+
+func TestNewNiiWriter_PreserveVoxOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(8, 8, 4, 1, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetAt(42, 7, 7, 3, 0)
+	assert.NoError(err)
+	img.VoxOffset = 1024
+
+	dir := t.TempDir()
+	err = WriteFile(dir+"/offset.nii", img, false)
+	assert.NoError(err)
+
+	for _, version := range []int{nifti.NIIVersion1, nifti.NIIVersion2} {
+		// Read the file with the large vox_offset then rewrite it
+		read, err := ReadFile(dir + "/offset.nii")
+		assert.NoError(err)
+		assert.Equal(1024.0, read.VoxOffset)
+		assert.Equal(42.0, read.GetAt(7, 7, 3, 0))
+
+		read.Version = version
+		err = WriteFile(dir+"/rewritten.nii", read, false)
+		assert.NoError(err)
+
+		rewritten, err := ReadFile(dir + "/rewritten.nii")
+		assert.NoError(err)
+		assert.Equal(1024.0, rewritten.VoxOffset, "version %d", version)
+		assert.Equal(42.0, rewritten.GetAt(7, 7, 3, 0))
+
+		bData, err := os.ReadFile(dir + "/rewritten.nii")
+		assert.NoError(err)
+		assert.Len(bData, 1024+len(img.Volume))
+		err = os.Remove(dir + "/rewritten.nii")
+		assert.NoError(err)
+	}
+}
+
+func TestReadFromArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	bFixture, err := os.ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	expected, err := ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+
+	dir := t.TempDir()
+
+	// Zip archive
+	zipFile, err := os.Create(dir + "/dataset.zip")
+	assert.NoError(err)
+	zw := zip.NewWriter(zipFile)
+	fw, err := zw.Create("data/int16.nii.gz")
+	assert.NoError(err)
+	_, err = fw.Write(bFixture)
+	assert.NoError(err)
+	assert.NoError(zw.Close())
+	assert.NoError(zipFile.Close())
+
+	img, err := ReadFromArchive(dir+"/dataset.zip", "data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal(expected.GetImgShape(), img.GetImgShape())
+	assert.Equal(expected.VolumeHash(), img.VolumeHash())
+
+	_, err = ReadFromArchive(dir+"/dataset.zip", "data/missing.nii.gz")
+	assert.Error(err)
+
+	// Gzipped tar archive
+	tarFile, err := os.Create(dir + "/dataset.tar.gz")
+	assert.NoError(err)
+	gw := gzip.NewWriter(tarFile)
+	tw := tar.NewWriter(gw)
+	err = tw.WriteHeader(&tar.Header{Name: "data/int16.nii.gz", Mode: 0644, Size: int64(len(bFixture)), Typeflag: tar.TypeReg})
+	assert.NoError(err)
+	_, err = tw.Write(bFixture)
+	assert.NoError(err)
+	assert.NoError(tw.Close())
+	assert.NoError(gw.Close())
+	assert.NoError(tarFile.Close())
+
+	img, err = ReadFromArchive(dir+"/dataset.tar.gz", "./data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal(expected.VolumeHash(), img.VolumeHash())
+}
+
+func TestReadDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(os.MkdirAll(dir+"/sub", 0755))
+	for src, dst := range map[string]string{
+		"./test_data/int16.nii.gz":   dir + "/a_int16.nii.gz",
+		"./test_data/nii2_LR.nii.gz": dir + "/sub/b_nii2.nii.gz",
+	} {
+		bData, err := os.ReadFile(src)
+		assert.NoError(err)
+		assert.NoError(os.WriteFile(dst, bData, 0644))
+	}
+	assert.NoError(os.WriteFile(dir+"/c_broken.nii", []byte("not a NIfTI file"), 0644))
+	assert.NoError(os.WriteFile(dir+"/notes.txt", []byte("ignored"), 0644))
+
+	images, errs := ReadDir(dir)
+	assert.Len(images, 2)
+	assert.Len(errs, 1)
+	assert.Contains(errs[0].Error(), "c_broken.nii")
+
+	expected, err := ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal(expected.VolumeHash(), images[0].VolumeHash())
+
+	images, errs = ReadDir(dir, WithReadHeaderOnly(true))
+	assert.Len(images, 2)
+	assert.Len(errs, 1)
+	assert.Equal(expected.GetImgShape(), images[0].GetImgShape())
+	assert.Empty(images[0].Volume)
+}
+
+func TestNii_PerVolumeScaling(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 4, 2, 2, nifti.DT_INT16, binary.LittleEndian)
+	err := img.SetPerVolumeScaling([]float64{2}, []float64{0})
+	assert.Error(err)
+	err = img.SetPerVolumeScaling([]float64{2, 0.5}, []float64{0})
+	assert.Error(err)
+
+	err = img.SetPerVolumeScaling([]float64{2, 0.5}, []float64{10, -1})
+	assert.NoError(err)
+	assert.NoError(img.SetAt(30, 1, 2, 1, 0))
+	assert.NoError(img.SetAt(30, 1, 2, 1, 1))
+
+	// The same stored value is scaled differently in each timepoint
+	assert.Equal(30.0, img.GetAt(1, 2, 1, 0))
+	assert.Equal(30.0, img.GetAt(1, 2, 1, 1))
+	assert.Equal(10.0, img.GetAt(0, 0, 0, 0))
+	assert.Equal(-1.0, img.GetAt(0, 0, 0, 1))
+	raw := img.Volume[2*(1+2*4+1*16):]
+	assert.Equal(uint16(10), binary.LittleEndian.Uint16(raw))
+	assert.Equal(uint16(62), binary.LittleEndian.Uint16(raw[2*32:]))
+
+	// The factors are kept on write
+	for _, version := range []int{nifti.NIIVersion1, nifti.NIIVersion2} {
+		img.Version = version
+		path := fmt.Sprintf("%s/scaled_%d.nii.gz", t.TempDir(), version)
+		err = WriteFile(path, img, true)
+		assert.NoError(err)
+
+		read, err := ReadFile(path)
+		assert.NoError(err)
+		assert.Equal([]float64{2, 0.5}, read.VolumeSlopes)
+		assert.Equal([]float64{10, -1}, read.VolumeInters)
+		assert.Equal(30.0, read.GetAt(1, 2, 1, 0))
+		assert.Equal(30.0, read.GetAt(1, 2, 1, 1))
+		assert.Equal(-1.0, read.GetAt(0, 0, 0, 1))
+
+		// The partial reads of the second volume use its scaling
+		rd, err := NewNiiReader(WithReadImageFile(path))
+		assert.NoError(err)
+		volume, err := rd.(nifti.PartialReader).ReadVolumeAt(1)
+		assert.NoError(err)
+		assert.Equal(30.0, volume.GetAt(1, 2, 1, 0))
+		assert.Equal(-1.0, volume.GetAt(0, 0, 0, 0))
+		assert.Nil(volume.VolumeSlopes)
+		assert.Empty(volume.Nifti1Ext)
+		slice, err := rd.(nifti.PartialReader).ReadSliceAt(1, 1)
+		assert.NoError(err)
+		assert.Equal(30.0, slice.GetAt(1, 2, 0, 0))
+	}
+
+	// Removing the scaling removes the extension
+	err = img.SetPerVolumeScaling(nil, nil)
+	assert.NoError(err)
+	assert.Empty(img.Nifti1Ext)
+	assert.Equal(10.0, img.GetAt(1, 2, 1, 0))
+}
+
+func TestNii_FieldOfView(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal([3]float64{240, 240, 155}, img.FieldOfView())
+
+	img, err = ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	assert.Equal([3]float64{182, 218, 182}, img.FieldOfView())
+}
+
+func TestNii_LabelTableRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 4, 2, 1, nifti.DT_UINT8, binary.LittleEndian)
+	assert.NoError(img.SetAt(17, 1, 1, 0, 0))
+	labels := map[int]string{0: "Background", 17: "Left-Hippocampus", 53: "Right-Hippocampus"}
+	img.SetLabelTable(labels)
+
+	for _, version := range []int{nifti.NIIVersion1, nifti.NIIVersion2} {
+		img.Version = version
+		path := fmt.Sprintf("%s/labels_%d.nii", t.TempDir(), version)
+		err := WriteFile(path, img, false)
+		assert.NoError(err)
+
+		read, err := ReadFile(path)
+		assert.NoError(err)
+		table, ok := read.LabelTable()
+		assert.True(ok)
+		assert.Equal(labels, table)
+		assert.Equal(17.0, read.GetAt(1, 1, 0, 0))
+	}
+}
+
+func TestMapVoxel(t *testing.T) {
+	assert := assert.New(t)
+
+	// 2 mm images with the x axis going to the left and to the right, and a 1 mm MNI image
+	lr, err := ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	rl, err := ReadFile("./test_data/nii2_RL.nii.gz")
+	assert.NoError(err)
+	mni, err := ReadFile("./test_data/nii2_mni.nii.gz")
+	assert.NoError(err)
+
+	i, j, k := nifti.MapVoxel(lr, mni, 10, 20, 30)
+	assert.InDeltaSlice([]float64{20, 40, 60}, []float64{i, j, k}, 1e-9)
+
+	i, j, k = nifti.MapVoxel(lr, rl, 10, 20, 30)
+	assert.InDeltaSlice([]float64{80, 20, 30}, []float64{i, j, k}, 1e-9)
+
+	// Fractional coordinates and the inverse mapping
+	i, j, k = nifti.MapVoxel(mni, lr, 21, 40.5, 60)
+	assert.InDeltaSlice([]float64{10.5, 20.25, 30}, []float64{i, j, k}, 1e-9)
+}
+
+func TestWithReadNoAutoFix(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 4, 1, 1, nifti.DT_INT16, binary.LittleEndian)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// dim[3] is the int16 at offset 46 of the NIfTI-1 header
+	binary.LittleEndian.PutUint16(bData[46:48], 0)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	assert.Equal(int64(1), rd.GetNiiData().Nz)
+
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadNoAutoFix(true))
+	assert.NoError(err)
+	err = rd.Parse()
+	assert.Error(err)
+	assert.Contains(err.Error(), "dim[3]=0")
+
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadNoAutoFix(false))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+}
+
+func TestNiiWriter_WritePairTo(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 3, 2, 2, nifti.DT_INT16, binary.LittleEndian)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(3*i))
+	}
+	img.AddExtension(nifti.NIFTI_ECODE_COMMENT, []byte("pair comment"))
+
+	for _, compression := range []bool{false, true} {
+		writer, err := NewNiiWriter("", WithWriteNIfTIData(img), WithWriteCompression(compression))
+		assert.NoError(err)
+		var hdrBuf, imgBuf bytes.Buffer
+		assert.NoError(writer.(nifti.PairWriter).WritePairTo(&hdrBuf, &imgBuf))
+		if !compression {
+			assert.Equal(img.Volume, imgBuf.Bytes())
+		}
+
+		rd, err := NewNiiReader(
+			WithReadHeaderReader(bytes.NewReader(hdrBuf.Bytes())),
+			WithReadImageReader(bytes.NewReader(imgBuf.Bytes())),
+		)
+		assert.NoError(err)
+		assert.NoError(rd.Parse())
+		out := rd.GetNiiData()
+		assert.Equal(img.Dim, out.Dim)
+		assert.Equal(img.Volume, out.Volume)
+		assert.Equal(img.VolumeHash(), out.VolumeHash())
+		if assert.Len(out.Nifti1Ext, 1) {
+			assert.Equal("pair comment", strings.TrimRight(string(out.Nifti1Ext[0].EData), "\x00"))
+		}
+	}
+}
+
+func TestNiiReader_NiftiType(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	assert.Equal(nifti.NIFTI_FTYPE_SINGLE, img.NiftiType)
+
+	rd, err := NewNiiReader(WithReadImageFile("./test_data/t1.img.gz"), WithReadHeaderFile("./test_data/t1.hdr.gz"))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	assert.Equal(nifti.NIFTI_FTYPE_PAIR, rd.GetNiiData().NiftiType)
+
+	img, err = ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	assert.Equal(nifti.NIFTI_FTYPE_SINGLE, img.NiftiType)
+}
+
+func TestNii_VolumeTime(t *testing.T) {
+	assert := assert.New(t)
+
+	// The 3 volumes of the fixture have a TR of 1 and no time offset
+	img, err := ReadFile("./test_data/rgb24.nii.gz")
+	assert.NoError(err)
+	assert.Equal(int64(3), img.Nt)
+	assert.Equal(0.0, img.VolumeTime(0))
+	assert.Equal(2.0, img.VolumeTime(2))
+
+	img = newTestImage(2, 2, 2, 4, nifti.DT_INT16, binary.LittleEndian)
+	img.Dt, img.PixDim[4] = 2.5, 2.5
+	img.TOffset = 0.75
+	img.TimeUnits = int32(nifti.NIFTI_UNITS_SEC)
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	assert.Equal(0.75+3*2.5, rd.GetNiiData().VolumeTime(3))
+}
+
+func TestNii_FormsConsistent(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, path := range []string{"./test_data/nii2_LR.nii.gz", "./test_data/nii2_RL.nii.gz"} {
+		img, err := ReadFile(path)
+		assert.NoError(err)
+
+		// Only the sform is set
+		consistent, maxDiff := img.FormsConsistent(1e-3)
+		assert.True(consistent)
+		assert.Equal(0.0, maxDiff)
+
+		// A qform computed from the sform agrees up to rounding
+		img.QformCode = nifti.NIFTI_XFORM_SCANNER_ANAT
+		img.Dx, img.Dy, img.Dz = 2, 2, 2
+		img.MatrixToQuatern(img.StoXYZ)
+		img.QtoXYZ = img.QuaternToMatrix()
+		consistent, maxDiff = img.FormsConsistent(1e-3)
+		assert.True(consistent, path)
+		assert.Less(maxDiff, 1e-3)
+
+		// A shifted qform origin is a discrepancy
+		img.QtoXYZ.M[1][3] += 5
+		consistent, maxDiff = img.FormsConsistent(1e-3)
+		assert.False(consistent)
+		assert.InDelta(5, maxDiff, 1e-3)
+	}
+}
+
+func TestParseHeaderBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	// The first bytes of the gzipped fixture are enough to parse the header
+	bData, err := os.ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	version, header, byteOrder, err := nifti.ParseHeaderBytes(bData[:2048])
+	assert.NoError(err)
+	assert.Equal(nifti.NIIVersion1, version)
+	assert.Equal(binary.LittleEndian, byteOrder)
+	n1Header, ok := header.(*nifti.Nii1Header)
+	if assert.True(ok) {
+		assert.Equal([8]int16{3, 240, 240, 155, 1, 1, 1, 1}, n1Header.Dim)
+		assert.Equal(int16(nifti.DT_INT16), n1Header.Datatype)
+	}
+
+	// Uncompressed NIfTI-2
+	bData, err = os.ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	bData, err = deflateFileContent(bData)
+	assert.NoError(err)
+	version, header, _, err = nifti.ParseHeaderBytes(bData[:nifti.NII2HeaderSize])
+	assert.NoError(err)
+	assert.Equal(nifti.NIIVersion2, version)
+	n2Header, ok := header.(*nifti.Nii2Header)
+	if assert.True(ok) {
+		assert.Equal([8]int64{3, 91, 109, 91, 1, 1, 1, 1}, n2Header.Dim)
+	}
+
+	_, _, _, err = nifti.ParseHeaderBytes(bData[:100])
+	assert.Error(err)
+	_, _, _, err = nifti.ParseHeaderBytes([]byte("not a NIfTI file"))
+	assert.Error(err)
+}
+
+func TestNiiWriter_PreserveUnusedFields(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		path    string
+		offset  int
+		hdrSize int
+		value   string
+	}{
+		{"./test_data/int16.nii.gz", 14, nifti.NII1HeaderSize, "subject-db"}, // db_name
+		{"./test_data/int16.nii.gz", 4, nifti.NII1HeaderSize, "dsr"},         // data_type
+		{"./test_data/nii2_LR.nii.gz", 525, nifti.NII2HeaderSize, "unused"},  // unused_str
+	} {
+		bData, err := os.ReadFile(tc.path)
+		assert.NoError(err)
+		bData, err = deflateFileContent(bData)
+		assert.NoError(err)
+		copy(bData[tc.offset:], tc.value)
+
+		rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+		assert.NoError(err)
+		assert.NoError(rd.Parse())
+		img := rd.GetNiiData()
+
+		options := []func(*nifti.NiiWriter){WithWriteNIfTIData(img)}
+		if img.Version == nifti.NIIVersion2 {
+			options = append(options, WithWriteVersion(nifti.NIIVersion2))
+		}
+		writer, err := NewNiiWriter("", options...)
+		assert.NoError(err)
+		out, err := writer.WriteToBytes()
+		assert.NoError(err)
+		assert.Equal(bData[:tc.hdrSize], out[:tc.hdrSize], tc.path)
+	}
+}
+
+func TestNiiWriter_WithWritePreserveHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	bData, err := os.ReadFile("./test_data/int16.nii.gz")
+	assert.NoError(err)
+	bData, err = deflateFileContent(bData)
+	assert.NoError(err)
+	// Only the first byte of the extender is meaningful, some tools leave garbage in the other three
+	copy(bData[nifti.NII1HeaderSize+1:], "abc")
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)), WithReadRetainHeader(true))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	header, ok := rd.GetHeader(false).(*nifti.Nii1Header)
+	assert.True(ok)
+	img := rd.GetNiiData()
+
+	// The file is rewritten byte for byte
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img), WithWriteNii1Header(header), WithWritePreserveHeader(true))
+	assert.NoError(err)
+	out, err := writer.WriteToBytes()
+	assert.NoError(err)
+	assert.Equal(bData, out)
+
+	// Without the option, the bytes after the header only hold the extender
+	writer, err = NewNiiWriter("", WithWriteNIfTIData(img), WithWriteNii1Header(header))
+	assert.NoError(err)
+	out, err = writer.WriteToBytes()
+	assert.NoError(err)
+	assert.Equal(bData[:nifti.NII1HeaderSize], out[:nifti.NII1HeaderSize])
+	assert.Equal([]byte{0, 0, 0, 0}, out[nifti.NII1HeaderSize:nifti.NII1HeaderSize+4])
+
+	// A pair header keeps its vox_offset with the option, it is reset to 0 without
+	pairHeader := *header
+	pairHeader.Magic = nifti.NIFTI_1_MAGIC_PAIR
+	for _, preserve := range []bool{true, false} {
+		writer, err = NewNiiWriter("", WithWriteNIfTIData(img), WithWriteNii1Header(&pairHeader),
+			WithWritePreserveHeader(preserve))
+		assert.NoError(err)
+		hdrBuf := &bytes.Buffer{}
+		assert.NoError(writer.(nifti.PairWriter).WritePairTo(hdrBuf, &bytes.Buffer{}))
+		voxOffset := math.Float32frombits(binary.LittleEndian.Uint32(hdrBuf.Bytes()[108:112]))
+		if preserve {
+			assert.Equal(header.VoxOffset, voxOffset)
+		} else {
+			assert.Equal(float32(0), voxOffset)
+		}
+	}
+
+	// The single file header cannot be written verbatim as a pair
+	writer, err = NewNiiWriter("", WithWriteNIfTIData(img), WithWriteNii1Header(header), WithWritePreserveHeader(true))
+	assert.NoError(err)
+	err = writer.(nifti.PairWriter).WritePairTo(&bytes.Buffer{}, &bytes.Buffer{})
+	assert.Error(err)
+}
+
+func TestNiiReader_Nii2Extension(t *testing.T) {
+	assert := assert.New(t)
+
+	img, err := ReadFile("./test_data/nii2_LR.nii.gz")
+	assert.NoError(err)
+	assert.Equal(nifti.NIIVersion2, img.Version)
+	img.AddExtension(nifti.NIFTI_ECODE_COMMENT, []byte("nifti-2 comment"))
+
+	writer, err := NewNiiWriter("", WithWriteNIfTIData(img), WithWriteVersion(nifti.NIIVersion2))
+	assert.NoError(err)
+	bData, err := writer.WriteToBytes()
+	assert.NoError(err)
+
+	// The extender and the extension follow the 540-byte header
+	assert.Equal([]byte{1, 0, 0, 0}, bData[nifti.NII2HeaderSize:nifti.NII2HeaderSize+4])
+	eCode := int32(img.ByteOrder.Uint32(bData[nifti.NII2HeaderSize+8:]))
+	assert.Equal(nifti.NIFTI_ECODE_COMMENT, eCode)
+
+	rd, err := NewNiiReader(WithReadImageReader(bytes.NewReader(bData)))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	read := rd.GetNiiData()
+	assert.Len(read.Nifti1Ext, 1)
+	assert.Equal(nifti.NIFTI_ECODE_COMMENT, read.Nifti1Ext[0].ECode)
+	assert.Equal("nifti-2 comment", strings.TrimRight(string(read.Nifti1Ext[0].EData), "\x00"))
+
+	// The extensions of a pair are read from the header file
+	hdrBuf, imgBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.NoError(writer.(nifti.PairWriter).WritePairTo(hdrBuf, imgBuf))
+	rd, err = NewNiiReader(WithReadImageReader(bytes.NewReader(imgBuf.Bytes())),
+		WithReadHeaderReader(bytes.NewReader(hdrBuf.Bytes())))
+	assert.NoError(err)
+	assert.NoError(rd.Parse())
+	assert.Equal(read.Nifti1Ext, rd.GetNiiData().Nifti1Ext)
+}
+
+func TestNewNiiReader_QformMatrix(t *testing.T) {
+	assert := assert.New(t)
+
+	// The quaternions of the fixtures are rotations by 180 degrees, where a = 0
+	for filePath, expected := range map[string]matrix.DMat44{
+		"./test_data/int16.nii.gz": {M: [4][4]float64{
+			{-1, 0, 0, 0},
+			{0, -1, 0, 239},
+			{0, 0, 1, 0},
+			{0, 0, 0, 1},
+		}},
+		"./test_data/rgb24.nii.gz": {M: [4][4]float64{
+			{0.388672, 0, 0, -99.5},
+			{0, -0.388672, 0, 301.5},
+			{0, 0, -10, -149},
+			{0, 0, 0, 1},
+		}},
+		"./test_data/tensor_tr.nii.gz": {M: [4][4]float64{
+			{-2, 0, 0, 111.32},
+			{0, 2, 0, -64.8},
+			{0, 0, 2, -49.56},
+			{0, 0, 0, 1},
+		}},
+	} {
+		rd, err := NewNiiReader(WithReadImageFile(filePath))
+		assert.NoError(err)
+		assert.NoError(rd.Parse())
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				assert.InDelta(expected.M[i][j], rd.GetNiiData().QtoXYZ.M[i][j], 1e-5, "%s [%d][%d]", filePath, i, j)
+			}
+		}
+	}
+}
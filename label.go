@@ -0,0 +1,116 @@
+package gonii
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"math"
+)
+
+// ArgmaxOverTime converts a 4-D probability map, e.g. the softmax output of a segmentation model with one timepoint per
+// class, to a 3-D label map where each voxel holds the index of the timepoint with the maximal value. Ties are resolved
+// by the lowest index. The label map is UINT8, or INT16 for more than 256 timepoints, with the geometry of img
+func ArgmaxOverTime(img *nifti.Nii) (*nifti.Nii, error) {
+	if img == nil {
+		return nil, errors.New("image is nil")
+	}
+	nt := img.Nt
+	if nt <= 0 {
+		nt = 1
+	}
+
+	datatype := nifti.DT_UINT8
+	switch {
+	case nt > math.MaxInt16+1:
+		return nil, fmt.Errorf("too many timepoints %d, at most %d are supported", nt, math.MaxInt16+1)
+	case nt > math.MaxUint8+1:
+		datatype = nifti.DT_INT16
+	}
+
+	out := deriveImage(img, 1, datatype)
+	vox := nifti.NewVoxels(out.Nx, out.Ny, out.Nz, 1, datatype)
+	for z := int64(0); z < out.Nz; z++ {
+		for y := int64(0); y < out.Ny; y++ {
+			for x := int64(0); x < out.Nx; x++ {
+				label, maxVal := int64(0), math.Inf(-1)
+				for t := int64(0); t < nt; t++ {
+					val, err := img.GetAtChecked(x, y, z, t)
+					if err != nil {
+						return nil, err
+					}
+					if val > maxVal {
+						label, maxVal = t, val
+					}
+				}
+				vox.Set(x, y, z, 0, float64(label))
+			}
+		}
+	}
+
+	err := out.SetVoxelToRawVolume(vox)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OneHotOverTime expands a 3-D label map to a 4-D UINT8 one-hot volume with numClasses timepoints, where the timepoint
+// t of a voxel is 1 if its label is t and 0 otherwise. This is the inverse of ArgmaxOverTime. The labels must be
+// integers in the [0, numClasses) range
+func OneHotOverTime(labels *nifti.Nii, numClasses int) (*nifti.Nii, error) {
+	if labels == nil {
+		return nil, errors.New("label map is nil")
+	}
+	if numClasses <= 0 {
+		return nil, fmt.Errorf("invalid number of classes %d", numClasses)
+	}
+	if labels.Nt > 1 {
+		return nil, fmt.Errorf("expected a 3-D label map, got %d timepoints", labels.Nt)
+	}
+
+	out := deriveImage(labels, int64(numClasses), nifti.DT_UINT8)
+	volumeSize := out.Nx * out.Ny * out.Nz
+	for z := int64(0); z < out.Nz; z++ {
+		for y := int64(0); y < out.Ny; y++ {
+			for x := int64(0); x < out.Nx; x++ {
+				label, err := labels.GetAtChecked(x, y, z, 0)
+				if err != nil {
+					return nil, err
+				}
+				if label != math.Trunc(label) || label < 0 || label >= float64(numClasses) {
+					return nil, fmt.Errorf("label %v at (%d, %d, %d) is not an integer in [0, %d)", label, x, y, z, numClasses)
+				}
+				// UINT8 voxels hold a single byte, the value 1 is stored as is
+				out.Volume[int64(label)*volumeSize+z*out.Nx*out.Ny+y*out.Nx+x] = 1
+			}
+		}
+	}
+	return out, nil
+}
+
+// deriveImage returns a new image with the geometry of ref, nt timepoints and the datatype, holding a zeroed volume.
+// The scaling, the intensity range and the extensions of ref are not kept and the intent is set to label
+func deriveImage(ref *nifti.Nii, nt int64, datatype int32) *nifti.Nii {
+	out := *ref
+	nByPer, swapSize := nifti.AssignDatatypeSize(datatype)
+
+	out.Datatype = datatype
+	out.NByPer, out.SwapSize = int32(nByPer), int32(swapSize)
+	out.SclSlope, out.SclInter = 0, 0
+	out.VolumeSlopes, out.VolumeInters = nil, nil
+	out.CalMin, out.CalMax = 0, 0
+	out.IntentCode = int32(nifti.NIFTI_INTENT_LABEL)
+	out.IntentP1, out.IntentP2, out.IntentP3 = 0, 0, 0
+	out.Nifti1Ext, out.NumExt = nil, 0
+
+	out.Nt, out.Nu, out.Nv, out.Nw = nt, 1, 1, 1
+	out.Dim[4], out.Dim[5], out.Dim[6], out.Dim[7] = nt, 1, 1, 1
+	out.NDim = 3
+	if nt > 1 {
+		out.NDim = 4
+	}
+	out.Dim[0] = out.NDim
+	out.NVox = out.Nx * out.Ny * out.Nz * nt
+	out.Volume = make([]byte, out.NVox*int64(nByPer))
+	return &out
+}
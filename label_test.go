@@ -0,0 +1,103 @@
+package gonii
+
+import (
+	"encoding/binary"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newProbabilityImage returns a 4x3x2 FLOAT32 image with 3 timepoints where the maximal value of the voxel (x, y, z) is
+// at timepoint (x+y+z)%3, except for the voxel (0, 0, 1) where timepoints 1 and 2 are tied
+func newProbabilityImage() *nifti.Nii {
+	img := newTestImage(4, 3, 2, 3, nifti.DT_FLOAT32, binary.LittleEndian)
+	for z := int64(0); z < 2; z++ {
+		for y := int64(0); y < 3; y++ {
+			for x := int64(0); x < 4; x++ {
+				for t := int64(0); t < 3; t++ {
+					val := 0.1
+					if t == (x+y+z)%3 {
+						val = 0.8
+					}
+					_ = img.SetAt(val, x, y, z, t)
+				}
+			}
+		}
+	}
+	_ = img.SetAt(0.45, 0, 0, 1, 1)
+	_ = img.SetAt(0.45, 0, 0, 1, 2)
+	return img
+}
+
+func TestArgmaxOverTime(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newProbabilityImage()
+	labels, err := ArgmaxOverTime(img)
+	assert.NoError(err)
+	assert.Equal(nifti.DT_UINT8, labels.Datatype)
+	assert.Equal(int64(3), labels.NDim)
+	assert.Equal([8]int64{3, 4, 3, 2, 1, 1, 1, 1}, labels.Dim)
+	assert.Equal(int64(4*3*2), labels.NVox)
+	assert.Len(labels.Volume, 4*3*2)
+	assert.Equal(int32(nifti.NIFTI_INTENT_LABEL), labels.IntentCode)
+
+	for z := int64(0); z < 2; z++ {
+		for y := int64(0); y < 3; y++ {
+			for x := int64(0); x < 4; x++ {
+				expected := float64((x + y + z) % 3)
+				if x == 0 && y == 0 && z == 1 {
+					// Tie resolved by the lowest index
+					expected = 1
+				}
+				assert.Equal(expected, labels.GetAt(x, y, z, 0), "(%d, %d, %d)", x, y, z)
+			}
+		}
+	}
+
+	// The label map can be written
+	err = WriteFile(t.TempDir()+"/labels.nii.gz", labels, true)
+	assert.NoError(err)
+
+	_, err = ArgmaxOverTime(nil)
+	assert.Error(err)
+}
+
+func TestOneHotOverTime(t *testing.T) {
+	assert := assert.New(t)
+
+	labels, err := ArgmaxOverTime(newProbabilityImage())
+	assert.NoError(err)
+
+	oneHot, err := OneHotOverTime(labels, 3)
+	assert.NoError(err)
+	assert.Equal(nifti.DT_UINT8, oneHot.Datatype)
+	assert.Equal([8]int64{4, 4, 3, 2, 3, 1, 1, 1}, oneHot.Dim)
+	assert.Len(oneHot.Volume, 4*3*2*3)
+	for z := int64(0); z < 2; z++ {
+		for y := int64(0); y < 3; y++ {
+			for x := int64(0); x < 4; x++ {
+				var sum float64
+				for tp := int64(0); tp < 3; tp++ {
+					sum += oneHot.GetAt(x, y, z, tp)
+				}
+				assert.Equal(1.0, sum)
+				assert.Equal(1.0, oneHot.GetAt(x, y, z, int64(labels.GetAt(x, y, z, 0))))
+			}
+		}
+	}
+
+	// argmax ∘ onehot is the identity on the label map
+	roundTrip, err := ArgmaxOverTime(oneHot)
+	assert.NoError(err)
+	assert.Equal(labels.Volume, roundTrip.Volume)
+	assert.Equal(labels.Dim, roundTrip.Dim)
+
+	// Labels out of range
+	_, err = OneHotOverTime(labels, 2)
+	assert.Error(err)
+	_, err = OneHotOverTime(labels, 0)
+	assert.Error(err)
+	_, err = OneHotOverTime(oneHot, 3)
+	assert.Error(err)
+}
@@ -0,0 +1,331 @@
+package gonii
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// npyMagic is the magic string starting a NumPy .npy file
+const npyMagic = "\x93NUMPY"
+
+// npyTypes maps the NIfTI datatypes to the NumPy type codes, without the byte order character
+var npyTypes = map[int32]string{
+	nifti.DT_UINT8:      "u1",
+	nifti.DT_INT8:       "i1",
+	nifti.DT_INT16:      "i2",
+	nifti.DT_UINT16:     "u2",
+	nifti.DT_INT32:      "i4",
+	nifti.DT_UINT32:     "u4",
+	nifti.DT_INT64:      "i8",
+	nifti.DT_UINT64:     "u8",
+	nifti.DT_FLOAT32:    "f4",
+	nifti.DT_FLOAT64:    "f8",
+	nifti.DT_COMPLEX64:  "c8",
+	nifti.DT_COMPLEX128: "c16",
+}
+
+// WriteNPY writes the 3-D volume at time t of the image as a NumPy .npy file (format version 1.0). The array has the
+// shape (Nx, Ny, Nz) in Fortran order, which matches the NIfTI storage order, so that the voxel (x, y, z) is at
+// array[x, y, z]. The raw voxel values are written with the image datatype and byte order, except for a scaled image
+// (scl_slope or per-volume scaling) which is written as float64 with the scaling applied
+func WriteNPY(img *nifti.Nii, t int64, path string) error {
+	if img == nil {
+		return errors.New("image data structure is nil")
+	}
+	typeCode, ok := npyTypes[img.Datatype]
+	if !ok {
+		return fmt.Errorf("unsupported datatype %s for .npy", nifti.Datatype(img.Datatype))
+	}
+
+	nx, ny, nz := dimOrOne(img.Nx), dimOrOne(img.Ny), dimOrOne(img.Nz)
+	volumeSize := nx * ny * nz
+
+	var bData []byte
+	var descr string
+	if isScaled(img) && img.Datatype != nifti.DT_COMPLEX64 && img.Datatype != nifti.DT_COMPLEX128 {
+		values, err := img.GetVolumeFlat(t)
+		if err != nil {
+			return err
+		}
+		bData = make([]byte, 8*len(values))
+		for i, val := range values {
+			binary.LittleEndian.PutUint64(bData[8*i:], math.Float64bits(val))
+		}
+		descr = "<f8"
+	} else {
+		if t < 0 || (t+1)*volumeSize*int64(img.NByPer) > int64(len(img.Volume)) {
+			return fmt.Errorf("invalid time value %d", t)
+		}
+		nByPer := int64(img.NByPer)
+		bData = img.Volume[t*volumeSize*nByPer : (t+1)*volumeSize*nByPer]
+		descr = npyByteOrder(img) + typeCode
+	}
+
+	var buf bytes.Buffer
+	buf.Write(npyHeader(descr, true, []int64{nx, ny, nz}))
+	buf.Write(bData)
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// ReadNPY reads a NumPy .npy file (format version 1.0 to 3.0) holding an array of 1 to 7 dimensions into an image with
+// the voxel to world affine, set as both the sform and the qform with the scanner anatomical code. The array axes map to
+// the NIfTI dimensions in order, so that array[x, y, z] is the voxel (x, y, z) whether the array is in C or Fortran
+// order. Booleans are read as UINT8
+func ReadNPY(path string, affine matrix.DMat44) (*nifti.Nii, error) {
+	bData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	descr, fortranOrder, shape, data, err := parseNPY(bData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	datatype, byteOrder, err := npyDatatype(descr)
+	if err != nil {
+		return nil, err
+	}
+	nByPer, swapSize := nifti.AssignDatatypeSize(datatype)
+
+	// The voxel count is checked against the data before each product so that a malformed shape cannot overflow it
+	maxVox := int64(len(data)) / int64(nByPer)
+	nVox := int64(1)
+	for _, dim := range shape {
+		if dim < 0 {
+			return nil, fmt.Errorf("%s: invalid negative dimension in shape %v", path, shape)
+		}
+		if dim != 0 && nVox > maxVox/dim {
+			return nil, fmt.Errorf("%s: shape %v exceeds the %d bytes of data", path, shape, len(data))
+		}
+		nVox *= dim
+	}
+	data = data[:nVox*int64(nByPer)]
+	if !fortranOrder {
+		data = npyToFortranOrder(data, shape, int64(nByPer))
+	} else {
+		data = append([]byte(nil), data...)
+	}
+
+	img := &nifti.Nii{
+		NDim:      int64(len(shape)),
+		NVox:      nVox,
+		NByPer:    int32(nByPer),
+		SwapSize:  int32(swapSize),
+		Datatype:  datatype,
+		ByteOrder: byteOrder,
+		XYZUnits:  int32(nifti.NIFTI_UNITS_MM),
+		Version:   nifti.NIIVersion1,
+		Volume:    data,
+	}
+	img.Dim[0] = img.NDim
+	for i := 1; i < 8; i++ {
+		img.Dim[i] = 1
+		if i <= len(shape) {
+			img.Dim[i] = shape[i-1]
+		}
+	}
+	img.Nx, img.Ny, img.Nz, img.Nt = img.Dim[1], img.Dim[2], img.Dim[3], img.Dim[4]
+	img.Nu, img.Nv, img.Nw = img.Dim[5], img.Dim[6], img.Dim[7]
+
+	img.Dx = math.Sqrt(affine.M[0][0]*affine.M[0][0] + affine.M[1][0]*affine.M[1][0] + affine.M[2][0]*affine.M[2][0])
+	img.Dy = math.Sqrt(affine.M[0][1]*affine.M[0][1] + affine.M[1][1]*affine.M[1][1] + affine.M[2][1]*affine.M[2][1])
+	img.Dz = math.Sqrt(affine.M[0][2]*affine.M[0][2] + affine.M[1][2]*affine.M[1][2] + affine.M[2][2]*affine.M[2][2])
+	img.Dt, img.Du, img.Dv, img.Dw = 1, 1, 1, 1
+	img.SformCode, img.QformCode = nifti.NIFTI_XFORM_SCANNER_ANAT, nifti.NIFTI_XFORM_SCANNER_ANAT
+	img.StoXYZ = affine
+	img.StoIJK = matrix.Mat44Inverse(affine)
+	img.MatrixToQuatern(affine)
+	img.QtoXYZ = img.QuaternToMatrix()
+	img.QtoIJK = matrix.Mat44Inverse(img.QtoXYZ)
+	img.PixDim = [8]float64{img.QFac, img.Dx, img.Dy, img.Dz, 1, 1, 1, 1}
+	img.Affine = affine
+	img.MatrixToOrientation(affine)
+	return img, nil
+}
+
+// npyHeaderFields matches the fields of the .npy header dictionary
+var npyHeaderFields = map[string]*regexp.Regexp{
+	"descr":         regexp.MustCompile(`'descr'\s*:\s*'([^']*)'`),
+	"fortran_order": regexp.MustCompile(`'fortran_order'\s*:\s*(True|False)`),
+	"shape":         regexp.MustCompile(`'shape'\s*:\s*\(([^)]*)\)`),
+}
+
+// parseNPY parses the .npy preamble and header and returns the array type description, whether the array is in Fortran
+// order, the shape and the array data
+func parseNPY(bData []byte) (string, bool, []int64, []byte, error) {
+	if len(bData) < len(npyMagic)+4 || string(bData[:len(npyMagic)]) != npyMagic {
+		return "", false, nil, nil, errors.New("invalid .npy magic string")
+	}
+
+	offset := len(npyMagic) + 2
+	var headerLen int
+	switch major := bData[len(npyMagic)]; major {
+	case 1:
+		headerLen = int(binary.LittleEndian.Uint16(bData[offset:]))
+		offset += 2
+	case 2, 3:
+		if len(bData) < offset+4 {
+			return "", false, nil, nil, errors.New("truncated .npy header")
+		}
+		headerLen = int(binary.LittleEndian.Uint32(bData[offset:]))
+		offset += 4
+	default:
+		return "", false, nil, nil, fmt.Errorf("unsupported .npy format version %d", major)
+	}
+	if len(bData) < offset+headerLen {
+		return "", false, nil, nil, errors.New("truncated .npy header")
+	}
+	header := string(bData[offset : offset+headerLen])
+
+	fields := make(map[string]string, len(npyHeaderFields))
+	for name, re := range npyHeaderFields {
+		match := re.FindStringSubmatch(header)
+		if match == nil {
+			return "", false, nil, nil, fmt.Errorf("missing '%s' in .npy header", name)
+		}
+		fields[name] = match[1]
+	}
+
+	var shape []int64
+	for _, field := range strings.Split(fields["shape"], ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		dim, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || dim <= 0 {
+			return "", false, nil, nil, fmt.Errorf("invalid .npy shape (%s)", fields["shape"])
+		}
+		shape = append(shape, dim)
+	}
+	if len(shape) == 0 || len(shape) > 7 {
+		return "", false, nil, nil, fmt.Errorf("unsupported .npy array of %d dimensions, expected 1 to 7", len(shape))
+	}
+	return fields["descr"], fields["fortran_order"] == "True", shape, bData[offset+headerLen:], nil
+}
+
+// npyDatatype returns the NIfTI datatype and the byte order of the NumPy type description, e.g. '<i2'
+func npyDatatype(descr string) (int32, binary.ByteOrder, error) {
+	if len(descr) < 2 {
+		return 0, nil, fmt.Errorf("invalid .npy dtype '%s'", descr)
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	typeCode := descr
+	switch descr[0] {
+	case '<', '|', '=':
+		typeCode = descr[1:]
+	case '>':
+		byteOrder = binary.BigEndian
+		typeCode = descr[1:]
+	}
+	if typeCode == "b1" {
+		return nifti.DT_UINT8, byteOrder, nil
+	}
+	for datatype, code := range npyTypes {
+		if code == typeCode {
+			return datatype, byteOrder, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("unsupported .npy dtype '%s'", descr)
+}
+
+// npyToFortranOrder reorders the elements of an array in C order (last axis varying fastest) to the Fortran order
+// (first axis varying fastest) used by NIfTI
+func npyToFortranOrder(data []byte, shape []int64, nByPer int64) []byte {
+	cStrides := make([]int64, len(shape))
+	stride := int64(1)
+	for d := len(shape) - 1; d >= 0; d-- {
+		cStrides[d] = stride
+		stride *= shape[d]
+	}
+
+	out := make([]byte, len(data))
+	index := make([]int64, len(shape))
+	for f := int64(0); f < int64(len(data))/nByPer; f++ {
+		c := int64(0)
+		for d := range shape {
+			c += index[d] * cStrides[d]
+		}
+		copy(out[f*nByPer:(f+1)*nByPer], data[c*nByPer:(c+1)*nByPer])
+
+		// Increment the multi-index with the first axis varying fastest
+		for d := range index {
+			index[d]++
+			if index[d] < shape[d] {
+				break
+			}
+			index[d] = 0
+		}
+	}
+	return out
+}
+
+// npyHeader returns the .npy version 1.0 preamble and header for the array description. The header is padded with
+// spaces and terminated by a newline so that the data starts at a multiple of 64 bytes
+func npyHeader(descr string, fortranOrder bool, shape []int64) []byte {
+	dims := make([]string, len(shape))
+	for i, dim := range shape {
+		dims[i] = fmt.Sprintf("%d", dim)
+	}
+	shapeStr := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeStr += ","
+	}
+	order := "False"
+	if fortranOrder {
+		order = "True"
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': %s, 'shape': (%s), }", descr, order, shapeStr)
+
+	// magic (6) + version (2) + header length (2) + dict + padding + newline
+	preambleLen := len(npyMagic) + 4
+	padding := 64 - (preambleLen+len(dict)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header := dict + strings.Repeat(" ", padding) + "\n"
+
+	out := make([]byte, 0, preambleLen+len(header))
+	out = append(out, npyMagic...)
+	out = append(out, 1, 0)
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(header)))
+	return append(out, header...)
+}
+
+// npyByteOrder returns the NumPy byte order character of the image voxels: '|' for single byte types, otherwise '<' or
+// '>' from the image byte order
+func npyByteOrder(img *nifti.Nii) string {
+	if img.NByPer == 1 {
+		return "|"
+	}
+	if img.ByteOrder == binary.BigEndian {
+		return ">"
+	}
+	return "<"
+}
+
+// dimOrOne returns the dimension or 1 for an unset one
+func dimOrOne(dim int64) int64 {
+	if dim <= 0 {
+		return 1
+	}
+	return dim
+}
+
+// isScaled returns whether the voxel values of the image are scaled by scl_slope/scl_inter or per-volume factors
+func isScaled(img *nifti.Nii) bool {
+	if img.VolumeSlopes != nil {
+		return true
+	}
+	return img.SclSlope != 0 && (img.SclSlope != 1 || img.SclInter != 0)
+}
@@ -0,0 +1,111 @@
+package gonii
+
+import (
+	"encoding/binary"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteNPY(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 3, 2, 2, nifti.DT_INT16, binary.BigEndian)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.BigEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+	path := filepath.Join(t.TempDir(), "volume.npy")
+	assert.NoError(WriteNPY(img, 1, path))
+
+	bData, err := os.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal(npyMagic, string(bData[:6]))
+	assert.Equal([]byte{1, 0}, bData[6:8])
+	headerLen := int(binary.LittleEndian.Uint16(bData[8:10]))
+	assert.Zero((10 + headerLen) % 64)
+	header := string(bData[10 : 10+headerLen])
+	assert.Contains(header, "'descr': '>i2'")
+	assert.Contains(header, "'fortran_order': True")
+	assert.Contains(header, "'shape': (4, 3, 2)")
+
+	data := bData[10+headerLen:]
+	assert.Len(data, 4*3*2*2)
+	// The voxel (1, 2, 1) of the second volume is the element [1, 2, 1] in Fortran order
+	assert.Equal(uint16(24+1*12+2*4+1), binary.BigEndian.Uint16(data[2*(1*12+2*4+1):]))
+
+	// A scaled image is written as float64 with the scaling applied
+	img.SclSlope, img.SclInter = 0.5, 10
+	assert.NoError(WriteNPY(img, 0, path))
+	bData, err = os.ReadFile(path)
+	assert.NoError(err)
+	headerLen = int(binary.LittleEndian.Uint16(bData[8:10]))
+	assert.Contains(string(bData[10:10+headerLen]), "'descr': '<f8'")
+	data = bData[10+headerLen:]
+	assert.Len(data, 4*3*2*8)
+	assert.Equal(0.5*5+10, math.Float64frombits(binary.LittleEndian.Uint64(data[8*5:])))
+
+	assert.Error(WriteNPY(img, 2, path))
+	assert.Error(WriteNPY(newTestImage(2, 2, 2, 1, nifti.DT_RGB24, binary.LittleEndian), 0, path))
+	assert.Error(WriteNPY(nil, 0, path))
+}
+
+func TestReadNPY(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(4, 3, 2, 2, nifti.DT_FLOAT32, binary.LittleEndian)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint32(img.Volume[4*i:], math.Float32bits(float32(i)/2))
+	}
+	path := filepath.Join(t.TempDir(), "volume.npy")
+	assert.NoError(WriteNPY(img, 1, path))
+
+	affine := matrix.DMat44{M: [4][4]float64{
+		{-2, 0, 0, 90},
+		{0, 2, 0, -126},
+		{0, 0, 3, -72},
+		{0, 0, 0, 1},
+	}}
+	out, err := ReadNPY(path, affine)
+	assert.NoError(err)
+	assert.Equal(nifti.DT_FLOAT32, out.Datatype)
+	assert.Equal([8]int64{3, 4, 3, 2, 1, 1, 1, 1}, out.Dim)
+	assert.Equal(int64(24), out.NVox)
+	assert.Equal(img.Volume[24*4:], out.Volume)
+	assert.Equal(12+float64(1*12+2*4+3)/2, out.GetAt(3, 2, 1, 0))
+	assert.Equal(affine, out.StoXYZ)
+	assert.Equal([3]float64{2, 2, 3}, [3]float64{out.Dx, out.Dy, out.Dz})
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			assert.InDelta(affine.M[i][j], out.QtoXYZ.M[i][j], 1e-6)
+		}
+	}
+
+	// A C order array is reordered so that array[x, y, z] is still the voxel (x, y, z)
+	cData := npyHeader("<i2", false, []int64{2, 3})
+	for _, val := range []uint16{0, 1, 2, 10, 11, 12} {
+		cData = binary.LittleEndian.AppendUint16(cData, val)
+	}
+	assert.NoError(os.WriteFile(path, cData, 0644))
+	out, err = ReadNPY(path, affine)
+	assert.NoError(err)
+	assert.Equal(nifti.DT_INT16, out.Datatype)
+	assert.Equal([3]int64{2, 3, 1}, [3]int64{out.Nx, out.Ny, out.Nz})
+	assert.Equal(12.0, out.GetAt(1, 2, 0, 0))
+	assert.Equal(2.0, out.GetAt(0, 2, 0, 0))
+
+	assert.NoError(os.WriteFile(path, cData[:len(cData)-2], 0644))
+	_, err = ReadNPY(path, affine)
+	assert.Error(err)
+	for _, shape := range [][]int64{{2, -3}, {-2, -3}, {1 << 32, 1 << 32}, {1 << 62, 4}} {
+		assert.NoError(os.WriteFile(path, append(npyHeader("<i2", false, shape), cData[len(cData)-12:]...), 0644))
+		_, err = ReadNPY(path, affine)
+		assert.Error(err, "shape %v", shape)
+	}
+	assert.NoError(os.WriteFile(path, []byte("not a .npy file"), 0644))
+	_, err = ReadNPY(path, affine)
+	assert.Error(err)
+}
@@ -33,9 +33,10 @@ func Mat33Polar(A DMat33) DMat33 {
 
 	X = A
 
+	// Force the matrix to be nonsingular, an improper matrix (negative determinant) is valid
 	gam = Mat33Determinant(X)
 	for {
-		if gam > 0.0 {
+		if gam != 0.0 {
 			break
 		}
 		gam = 0.00001 * (0.001 + Mat33RowNorm(X))
@@ -146,6 +147,19 @@ func MatMultiply(A, B DMat33) DMat33 {
 	return Cm
 }
 
+// Mat44Multiply multiples 2 4x4 matrices
+func Mat44Multiply(A, B DMat44) DMat44 {
+	var Cm DMat44
+	var i, j int64
+
+	for i = 0; i < 4; i++ {
+		for j = 0; j < 4; j++ {
+			Cm.M[i][j] = A.M[i][0]*B.M[0][j] + A.M[i][1]*B.M[1][j] + A.M[i][2]*B.M[2][j] + A.M[i][3]*B.M[3][j]
+		}
+	}
+	return Cm
+}
+
 // Mat33Inverse computes the inverse of a bordered 3x3 matrix
 func Mat33Inverse(R DMat33) DMat33 {
 	var r11, r12, r13, r21, r22, r23, r31, r32, r33, deti float64
@@ -55,6 +55,8 @@ type SegmentRLE struct {
 //	return res
 //}
 
+// Decode decodes the alternating background and foreground run lengths of EncodedSeg to DecodedSeg. The runs at even
+// indexes are background (zero) and the runs at odd indexes are filled with PixVal
 func (a *SegmentRLE) Decode() {
 	var deflatedSegment []float64
 
@@ -75,6 +77,7 @@ func (a *SegmentRLE) Decode() {
 	a.DecodedSeg = deflatedSegment
 }
 
+// Encode encodes DecodedSeg to EncodedSeg as alternating background and foreground run lengths, see RLEEncode
 func (a *SegmentRLE) Encode() error {
 	encodedSegment, err := RLEEncode(a.DecodedSeg)
 	if err != nil {
@@ -0,0 +1,145 @@
+package nifti
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// encodeASCIIHeader returns the AFNI-style <nifti_image .../> text header of a NIfTI-ASCII file. imageOffset is the
+// byte offset of the binary image data, which is the length of the text header itself
+func (n *Nii) encodeASCIIHeader(byteOrder binary.ByteOrder, imageOffset int) string {
+	var sb strings.Builder
+
+	writeAttr := func(name string, value interface{}) {
+		switch v := value.(type) {
+		case float64:
+			sb.WriteString(fmt.Sprintf("  %s = '%s'\n", name, strconv.FormatFloat(v, 'g', -1, 64)))
+		default:
+			sb.WriteString(fmt.Sprintf("  %s = '%v'\n", name, v))
+		}
+	}
+
+	sb.WriteString("<nifti_image\n")
+	writeAttr("nifti_type", "NIFTI-1A")
+	writeAttr("image_offset", imageOffset)
+
+	dims := []int64{n.Nx, n.Ny, n.Nz, n.Nt, n.Nu, n.Nv, n.Nw}
+	spacings := []float64{n.Dx, n.Dy, n.Dz, n.Dt, n.Du, n.Dv, n.Dw}
+	dimNames := []string{"x", "y", "z", "t", "u", "v", "w"}
+
+	writeAttr("ndim", n.NDim)
+	for i := 0; i < int(n.NDim) && i < len(dims); i++ {
+		writeAttr("n"+dimNames[i], dims[i])
+	}
+	for i := 0; i < int(n.NDim) && i < len(spacings); i++ {
+		writeAttr("d"+dimNames[i], spacings[i])
+	}
+
+	writeAttr("datatype", getDatatype(n.Datatype))
+	writeAttr("nvox", n.NVox)
+	writeAttr("nbyper", n.NByPer)
+	if byteOrder == binary.BigEndian {
+		writeAttr("byteorder", "MSB_FIRST")
+	} else {
+		writeAttr("byteorder", "LSB_FIRST")
+	}
+
+	if n.SclSlope != 0 {
+		writeAttr("scl_slope", n.SclSlope)
+		writeAttr("scl_inter", n.SclInter)
+	}
+	if n.CalMax > n.CalMin {
+		writeAttr("cal_min", n.CalMin)
+		writeAttr("cal_max", n.CalMax)
+	}
+	if n.IntentCode != 0 {
+		writeAttr("intent_code", n.IntentCode)
+		writeAttr("intent_p1", n.IntentP1)
+		writeAttr("intent_p2", n.IntentP2)
+		writeAttr("intent_p3", n.IntentP3)
+		writeAttr("intent_name", n.GetIntentName())
+	}
+	if n.TOffset != 0 {
+		writeAttr("toffset", n.TOffset)
+	}
+	if n.XYZUnits != 0 {
+		writeAttr("xyz_units", n.XYZUnits)
+	}
+	if n.TimeUnits != 0 {
+		writeAttr("time_units", n.TimeUnits)
+	}
+	if n.FreqDim > 0 {
+		writeAttr("freq_dim", n.FreqDim)
+	}
+	if n.PhaseDim > 0 {
+		writeAttr("phase_dim", n.PhaseDim)
+	}
+	if n.SliceDim > 0 {
+		writeAttr("slice_dim", n.SliceDim)
+	}
+	if n.SliceCode != 0 {
+		writeAttr("slice_code", n.SliceCode)
+		writeAttr("slice_start", n.SliceStart)
+		writeAttr("slice_end", n.SliceEnd)
+		writeAttr("slice_duration", n.SliceDuration)
+	}
+
+	writeAttr("qform_code", n.QformCode)
+	if n.QformCode > 0 {
+		writeAttr("quatern_b", n.QuaternB)
+		writeAttr("quatern_c", n.QuaternC)
+		writeAttr("quatern_d", n.QuaternD)
+		writeAttr("qoffset_x", n.QoffsetX)
+		writeAttr("qoffset_y", n.QoffsetY)
+		writeAttr("qoffset_z", n.QoffsetZ)
+		writeAttr("qfac", n.QFac)
+	}
+	writeAttr("sform_code", n.SformCode)
+	if n.SformCode > 0 {
+		var values []string
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 4; j++ {
+				values = append(values, strconv.FormatFloat(n.StoXYZ.M[i][j], 'g', -1, 64))
+			}
+		}
+		writeAttr("sto_xyz_matrix", strings.Join(values, " "))
+	}
+
+	if descrip := n.GetDescrip(); descrip != "" {
+		writeAttr("descrip", strings.ReplaceAll(descrip, "'", "\""))
+	}
+	if auxFile := n.GetAuxFile(); auxFile != "" {
+		writeAttr("aux_file", strings.ReplaceAll(auxFile, "'", "\""))
+	}
+	writeAttr("num_ext", n.NumExt)
+	sb.WriteString("/>\n")
+
+	return sb.String()
+}
+
+// reconstructASCIIDataset returns the NIfTI-ASCII text header followed by the binary image data
+func (w *NiiWriter) reconstructASCIIDataset() ([]byte, error) {
+	if w.niiData == nil {
+		return nil, errors.New("image data structure is nil")
+	}
+
+	// The image offset is part of the text header, so repeat until its length no longer changes the offset
+	imageOffset := 0
+	var bHeader string
+	for {
+		bHeader = w.niiData.encodeASCIIHeader(w.byteOrder(), imageOffset)
+		if len(bHeader) == imageOffset {
+			break
+		}
+		imageOffset = len(bHeader)
+	}
+
+	dataset := make([]byte, 0, len(bHeader)+len(w.niiData.Volume))
+	dataset = append(dataset, bHeader...)
+	dataset = append(dataset, w.niiData.Volume...)
+
+	return dataset, nil
+}
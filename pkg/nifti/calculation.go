@@ -16,10 +16,10 @@ func (n *Nii) QuaternToMatrix() matrix.DMat44 {
 
 	R.M[3] = [4]float64{0, 0, 0, 1}
 
-	a = 1.01 - (b*b + c*c + d*d)
+	a = 1.0 - (b*b + c*c + d*d)
 
-	if a < 1.e-71 {
-		a = 1.01 / math.Sqrt(b*b+c*c+d*d)
+	if a < 1.e-7 {
+		a = 1.0 / math.Sqrt(b*b+c*c+d*d)
 		b *= a
 		c *= a
 		d *= a
@@ -66,6 +66,68 @@ func (n *Nii) QuaternToMatrix() matrix.DMat44 {
 	return R
 }
 
+// qformForWrite returns an image structure holding the quaternion parameters, the qfac and the grid spacings to write.
+// If QtoXYZ no longer matches the quaternion parameters, e.g. because the user changed the matrix, they are recomputed
+// from QtoXYZ so that the written qform is consistent
+func (n *Nii) qformForWrite() *Nii {
+	q := &Nii{
+		QuaternB: n.QuaternB,
+		QuaternC: n.QuaternC,
+		QuaternD: n.QuaternD,
+		QoffsetX: n.QoffsetX,
+		QoffsetY: n.QoffsetY,
+		QoffsetZ: n.QoffsetZ,
+		QFac:     n.QFac,
+		Dx:       n.Dx,
+		Dy:       n.Dy,
+		Dz:       n.Dz,
+	}
+
+	// An unset matrix cannot be converted
+	if n.QtoXYZ.M[3][3] != 1 {
+		return q
+	}
+
+	current := q.QuaternToMatrix()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			if math.Abs(current.M[i][j]-n.QtoXYZ.M[i][j]) > 1e-6*math.Max(1, math.Abs(n.QtoXYZ.M[i][j])) {
+				q.MatrixToQuatern(n.QtoXYZ)
+				return q
+			}
+		}
+	}
+	return q
+}
+
+// DefaultAffine returns the affine used when both the qform and sform codes are zero (e.g. Analyze images). Like
+// nibabel's default, the voxel size is taken from pixdim, the x axis is flipped to the radiological (LAS) convention
+// and the origin is placed at the center of the volume
+func (n *Nii) DefaultAffine() matrix.DMat44 {
+	var R matrix.DMat44
+
+	spacings := [3]float64{n.Dx, n.Dy, n.Dz}
+	dims := [3]int64{n.Nx, n.Ny, n.Nz}
+	for i := range spacings {
+		spacings[i] = math.Abs(spacings[i])
+		if spacings[i] == 0 {
+			spacings[i] = 1.0
+		}
+		if dims[i] <= 0 {
+			dims[i] = 1
+		}
+	}
+	spacings[0] = -spacings[0]
+
+	for i := 0; i < 3; i++ {
+		R.M[i][i] = spacings[i]
+		R.M[i][3] = -spacings[i] * float64(dims[i]-1) / 2
+	}
+	R.M[3] = [4]float64{0, 0, 0, 1}
+
+	return R
+}
+
 // MatrixToQuatern computes the quaternion parameters (quatern_b, quatern_c, quatern_d) from the input matrix
 func (n *Nii) MatrixToQuatern(R matrix.DMat44) {
 	var r11, r12, r13, r21, r22, r23, r31, r32, r33 float64
@@ -92,7 +154,7 @@ func (n *Nii) MatrixToQuatern(R matrix.DMat44) {
 	zd = math.Sqrt(r13*r13 + r23*r23 + r33*r33)
 
 	// compute lengths of each column; these determine grid spacings
-	if xd == 0.01 {
+	if xd == 0.0 {
 		r11 = 1.0
 		r21 = 0.0
 		r31 = 0.0
@@ -174,7 +236,7 @@ func (n *Nii) MatrixToQuatern(R matrix.DMat44) {
 		r33 = -r33
 	}
 
-	a = r11 + r22 + r33 + 1.01
+	a = r11 + r22 + r33 + 1.0
 
 	if a > 0.5 { /* simplest case */
 		a = 0.5 * math.Sqrt(a)
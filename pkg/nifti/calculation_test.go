@@ -0,0 +1,74 @@
+package nifti
+
+import (
+	"github.com/okieraised/gonii/pkg/matrix"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNii_DefaultAffine(t *testing.T) {
+	assert := assert.New(t)
+
+	// Analyze-style image without qform and sform
+	img := &Nii{
+		Nx:        91,
+		Ny:        109,
+		Nz:        91,
+		Dx:        2,
+		Dy:        2,
+		Dz:        2,
+		QformCode: NIFTI_XFORM_UNKNOWN,
+		SformCode: NIFTI_XFORM_UNKNOWN,
+	}
+
+	expected := matrix.DMat44{M: [4][4]float64{
+		{-2, 0, 0, 90},
+		{0, 2, 0, -108},
+		{0, 0, 2, -90},
+		{0, 0, 0, 1},
+	}}
+	affine := img.DefaultAffine()
+	assert.Equal(expected, affine)
+
+	// The center voxel maps to the origin
+	center := [3]float64{45, 54, 45}
+	for i := 0; i < 3; i++ {
+		value := affine.M[i][3]
+		for j := 0; j < 3; j++ {
+			value += affine.M[i][j] * center[j]
+		}
+		assert.Equal(0.0, value)
+	}
+}
+
+func TestNii_MatrixToQuatern(t *testing.T) {
+	assert := assert.New(t)
+
+	// An improper (LAS) matrix gives a negative qfac and a rotation by 180 degrees about y
+	img := &Nii{}
+	img.MatrixToQuatern(matrix.DMat44{M: [4][4]float64{
+		{-2, 0, 0, 90},
+		{0, 2, 0, -126},
+		{0, 0, 3, -72},
+		{0, 0, 0, 1},
+	}})
+	assert.Equal(-1.0, img.QFac)
+	assert.InDelta(0, img.QuaternB, 1e-9)
+	assert.InDelta(1, img.QuaternC, 1e-9)
+	assert.InDelta(0, img.QuaternD, 1e-9)
+	assert.Equal([3]float64{2, 2, 3}, [3]float64{img.Dx, img.Dy, img.Dz})
+	assert.Equal([3]float64{90, -126, -72}, [3]float64{img.QoffsetX, img.QoffsetY, img.QoffsetZ})
+
+	// A zero column is replaced by the unit vector
+	img.MatrixToQuatern(matrix.DMat44{M: [4][4]float64{
+		{0, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}})
+	assert.Equal([3]float64{1, 1, 1}, [3]float64{img.Dx, img.Dy, img.Dz})
+	assert.Equal(1.0, img.QFac)
+	assert.InDelta(0, img.QuaternB, 1e-9)
+	assert.InDelta(0, img.QuaternC, 1e-9)
+	assert.InDelta(0, img.QuaternD, 1e-9)
+}
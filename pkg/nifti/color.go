@@ -0,0 +1,36 @@
+package nifti
+
+import "fmt"
+
+// SplitChannels splits an RGB24 or RGBA32 image into 3 or 4 UINT8 images holding the red, green, blue and, for RGBA32,
+// alpha components of each voxel. The channel images keep the dimensions, the geometry and the extensions of the image
+func (n *Nii) SplitChannels() ([]*Nii, error) {
+	var nChannels int64
+	switch n.Datatype {
+	case DT_RGB24:
+		nChannels = 3
+	case DT_RGBA32:
+		nChannels = 4
+	default:
+		return nil, fmt.Errorf("expected an RGB24 or RGBA32 image, got %s", n.GetDatatype())
+	}
+	if int64(n.NByPer) != nChannels || int64(len(n.Volume))%nChannels != 0 {
+		return nil, fmt.Errorf("invalid %d bytes per voxel for %s", n.NByPer, n.GetDatatype())
+	}
+	nVoxels := int64(len(n.Volume)) / nChannels
+
+	channels := make([]*Nii, nChannels)
+	for c := int64(0); c < nChannels; c++ {
+		out := *n
+		out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+		out.Datatype, out.NByPer, out.SwapSize = DT_UINT8, 1, 0
+		out.SclSlope, out.SclInter = 0, 0
+		out.VolumeSlopes, out.VolumeInters = nil, nil
+		out.Volume = make([]byte, nVoxels)
+		for i := int64(0); i < nVoxels; i++ {
+			out.Volume[i] = n.Volume[i*nChannels+c]
+		}
+		channels[c] = &out
+	}
+	return channels, nil
+}
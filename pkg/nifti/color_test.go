@@ -0,0 +1,40 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNii_SplitChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(2, 2, 1, 1, DT_RGB24)
+	for i := range img.Volume {
+		img.Volume[i] = byte(i)
+	}
+
+	channels, err := img.SplitChannels()
+	assert.NoError(err)
+	assert.Len(channels, 3)
+	for c, channel := range channels {
+		assert.Equal(DT_UINT8, channel.Datatype)
+		assert.Equal(int32(1), channel.NByPer)
+		assert.Equal(img.Dim, channel.Dim)
+		for y := int64(0); y < 2; y++ {
+			for x := int64(0); x < 2; x++ {
+				r, g, b, _ := img.GetRGBAAt(x, y, 0, 0)
+				assert.Equal(float64([3]uint8{r, g, b}[c]), channel.GetAt(x, y, 0, 0))
+			}
+		}
+	}
+
+	// RGBA32 images have an alpha channel
+	img.Datatype, img.NByPer, img.Volume = DT_RGBA32, 4, make([]byte, img.NVox*4)
+	channels, err = img.SplitChannels()
+	assert.NoError(err)
+	assert.Len(channels, 4)
+
+	img.Datatype, img.NByPer = DT_INT16, 2
+	_, err = img.SplitChannels()
+	assert.Error(err)
+}
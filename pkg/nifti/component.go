@@ -0,0 +1,137 @@
+package nifti
+
+import "fmt"
+
+// componentOffsets returns the (x, y, z) offsets of the neighbors of a voxel for the 6 (faces), 18 (faces and edges) or
+// 26 (faces, edges and corners) connectivity
+func componentOffsets(connectivity int) ([][3]int64, error) {
+	if connectivity != 6 && connectivity != 18 && connectivity != 26 {
+		return nil, fmt.Errorf("invalid connectivity %d, must be 6, 18 or 26", connectivity)
+	}
+
+	var offsets [][3]int64
+	for dz := int64(-1); dz <= 1; dz++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dx := int64(-1); dx <= 1; dx++ {
+				nonZero := 0
+				for _, d := range []int64{dx, dy, dz} {
+					if d != 0 {
+						nonZero++
+					}
+				}
+				if nonZero == 0 || (connectivity == 6 && nonZero > 1) || (connectivity == 18 && nonZero > 2) {
+					continue
+				}
+				offsets = append(offsets, [3]int64{dx, dy, dz})
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// labelComponents labels the connected components of the voxels of the 3-D volume t whose value matches the predicate.
+// The labels are indexed by the voxel index within the volume, they start at 1 and are 0 for the voxels that do not
+// match. sizes[l-1] is the number of voxels of the component l
+func (v *Voxels) labelComponents(t int64, offsets [][3]int64, match func(val float64) bool) ([]int32, []int) {
+	volumeSize := v.dimX * v.dimY * v.dimZ
+	volume := v.voxel[t*volumeSize : (t+1)*volumeSize]
+	labels := make([]int32, volumeSize)
+	var sizes []int
+
+	var queue []int64
+	for seed := int64(0); seed < volumeSize; seed++ {
+		if labels[seed] != 0 || !match(volume[seed]) {
+			continue
+		}
+
+		label := int32(len(sizes) + 1)
+		size := 0
+		labels[seed] = label
+		queue = append(queue[:0], seed)
+		for len(queue) > 0 {
+			idx := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			size++
+
+			x, y, z := idx%v.dimX, (idx/v.dimX)%v.dimY, idx/(v.dimX*v.dimY)
+			for _, offset := range offsets {
+				nx, ny, nz := x+offset[0], y+offset[1], z+offset[2]
+				if nx < 0 || nx >= v.dimX || ny < 0 || ny >= v.dimY || nz < 0 || nz >= v.dimZ {
+					continue
+				}
+				neighbor := nz*v.dimX*v.dimY + ny*v.dimX + nx
+				if labels[neighbor] != 0 || !match(volume[neighbor]) {
+					continue
+				}
+				labels[neighbor] = label
+				queue = append(queue, neighbor)
+			}
+		}
+		sizes = append(sizes, size)
+	}
+	return labels, sizes
+}
+
+// FillHoles sets to 1 the background (zero) voxels of each 3-D volume that are not connected to the volume border,
+// i.e. the interior holes of a mask. The background is connected through the voxel faces (6-connectivity). An axis of
+// size 1 has no border, so that the holes of a 2-D image are filled in-plane
+func (v *Voxels) FillHoles() {
+	offsets, _ := componentOffsets(6)
+	volumeSize := v.dimX * v.dimY * v.dimZ
+
+	for t := int64(0); t < v.dimT; t++ {
+		labels, sizes := v.labelComponents(t, offsets, func(val float64) bool { return val == 0 })
+
+		// The background components touching the border are not holes
+		touchesBorder := make([]bool, len(sizes)+1)
+		for z := int64(0); z < v.dimZ; z++ {
+			for y := int64(0); y < v.dimY; y++ {
+				for x := int64(0); x < v.dimX; x++ {
+					if isBorder(x, v.dimX) || isBorder(y, v.dimY) || isBorder(z, v.dimZ) {
+						touchesBorder[labels[z*v.dimX*v.dimY+y*v.dimX+x]] = true
+					}
+				}
+			}
+		}
+
+		for idx, label := range labels {
+			if label != 0 && !touchesBorder[label] {
+				v.voxel[t*volumeSize+int64(idx)] = 1
+			}
+		}
+	}
+}
+
+// isBorder returns whether the coordinate is on the border of an axis of size dim. An axis of size 1 has no border
+func isBorder(coord, dim int64) bool {
+	return dim > 1 && (coord == 0 || coord == dim-1)
+}
+
+// KeepLargestComponent zeros all the nonzero voxels of each 3-D volume except those of the largest connected component,
+// with the 6, 18 or 26 connectivity. If several components have the largest size, the first one in storage order is
+// kept
+func (v *Voxels) KeepLargestComponent(connectivity int) error {
+	offsets, err := componentOffsets(connectivity)
+	if err != nil {
+		return err
+	}
+	volumeSize := v.dimX * v.dimY * v.dimZ
+
+	for t := int64(0); t < v.dimT; t++ {
+		labels, sizes := v.labelComponents(t, offsets, func(val float64) bool { return val != 0 })
+
+		largest := int32(0)
+		for i, size := range sizes {
+			if largest == 0 || size > sizes[largest-1] {
+				largest = int32(i + 1)
+			}
+		}
+
+		for idx, label := range labels {
+			if label != largest {
+				v.voxel[t*volumeSize+int64(idx)] = 0
+			}
+		}
+	}
+	return nil
+}
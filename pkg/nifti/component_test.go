@@ -0,0 +1,113 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newSphereVoxels returns a n³ mask holding a sphere shell of radius r centered in the volume, the shell being thick
+// enough to have no gap with the 6-connectivity
+func newSphereVoxels(n int64, r float64) *Voxels {
+	vox := NewVoxels(n, n, n, 1, DT_UINT8)
+	center := float64(n-1) / 2
+	for z := int64(0); z < n; z++ {
+		for y := int64(0); y < n; y++ {
+			for x := int64(0); x < n; x++ {
+				dx, dy, dz := float64(x)-center, float64(y)-center, float64(z)-center
+				dist2 := dx*dx + dy*dy + dz*dz
+				if dist2 <= r*r && dist2 >= (r-1.5)*(r-1.5) {
+					vox.Set(x, y, z, 0, 1)
+				}
+			}
+		}
+	}
+	return vox
+}
+
+func TestVoxels_FillHoles(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := newSphereVoxels(15, 6)
+	center := int64(7)
+	assert.Equal(0.0, vox.Get(center, center, center, 0))
+
+	vox.FillHoles()
+
+	// The interior is filled, the outside of the sphere is untouched
+	for z := int64(0); z < 15; z++ {
+		for y := int64(0); y < 15; y++ {
+			for x := int64(0); x < 15; x++ {
+				dx, dy, dz := float64(x-center), float64(y-center), float64(z-center)
+				dist2 := dx*dx + dy*dy + dz*dz
+				if dist2 <= 36 {
+					assert.Equal(1.0, vox.Get(x, y, z, 0), "(%d, %d, %d)", x, y, z)
+				} else {
+					assert.Equal(0.0, vox.Get(x, y, z, 0), "(%d, %d, %d)", x, y, z)
+				}
+			}
+		}
+	}
+
+	// A background region open to the border is not a hole
+	cup := NewVoxels(5, 5, 1, 1, DT_UINT8)
+	for i := int64(0); i < 5; i++ {
+		cup.Set(i, 4, 0, 0, 1)
+		cup.Set(0, i, 0, 0, 1)
+		cup.Set(4, i, 0, 0, 1)
+	}
+	before := append([]float64(nil), cup.voxel...)
+	cup.FillHoles()
+	assert.Equal(before, cup.voxel)
+
+	// Closing the cup makes a hole filled in-plane
+	for i := int64(0); i < 5; i++ {
+		cup.Set(i, 0, 0, 0, 1)
+	}
+	cup.FillHoles()
+	for _, val := range cup.voxel {
+		assert.Equal(1.0, val)
+	}
+}
+
+func TestVoxels_KeepLargestComponent(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := NewVoxels(10, 10, 4, 1, DT_UINT8)
+	// Large blob of 3x3x2 voxels
+	for z := int64(0); z < 2; z++ {
+		for y := int64(1); y < 4; y++ {
+			for x := int64(1); x < 4; x++ {
+				vox.Set(x, y, z, 0, 1)
+			}
+		}
+	}
+	// Small blob of 2x2x1 voxels with another label
+	for y := int64(6); y < 8; y++ {
+		for x := int64(6); x < 8; x++ {
+			vox.Set(x, y, 3, 0, 2)
+		}
+	}
+	// Voxel touching the large blob by a corner only
+	vox.Set(4, 4, 2, 0, 1)
+
+	corner := NewVoxels(10, 10, 4, 1, DT_UINT8)
+	copy(corner.voxel, vox.voxel)
+
+	err := vox.KeepLargestComponent(6)
+	assert.NoError(err)
+	pos, _, _ := vox.CountNoneZero()
+	assert.Equal(18, pos)
+	assert.Equal(1.0, vox.Get(2, 2, 1, 0))
+	assert.Equal(0.0, vox.Get(6, 6, 3, 0))
+	assert.Equal(0.0, vox.Get(4, 4, 2, 0))
+
+	// The corner voxel joins the large blob with the 26 connectivity
+	err = corner.KeepLargestComponent(26)
+	assert.NoError(err)
+	pos, _, _ = corner.CountNoneZero()
+	assert.Equal(19, pos)
+	assert.Equal(1.0, corner.Get(4, 4, 2, 0))
+
+	err = vox.KeepLargestComponent(8)
+	assert.Error(err)
+}
@@ -8,6 +8,9 @@ const (
 
 const (
 	NIFTI_EXT            = ".nii"
+	NIFTI_ASCII_EXT      = ".nia"
+	NIFTI_PAIR_HDR_EXT   = ".hdr"
+	NIFTI_PAIR_IMG_EXT   = ".img"
 	NIFTI_COMPRESSED_EXT = ".gz"
 )
 
@@ -29,6 +32,14 @@ var (
 	NIFTI_2_MAGIC_PAIR   = [8]byte{110, 105, 50, 0, 13, 10, 26, 10} // ni2 or .hdr/.img pair
 )
 
+// NIfTI file types stored in NiftiType
+const (
+	NIFTI_FTYPE_ANALYZE int32 = 0 // Analyze 7.5, no valid magic string
+	NIFTI_FTYPE_SINGLE  int32 = 1 // single file (n+1 or n+2)
+	NIFTI_FTYPE_PAIR    int32 = 2 // .hdr/.img pair (ni1 or ni2)
+	NIFTI_FTYPE_ASCII   int32 = 3 // NIfTI-ASCII
+)
+
 //// Possible NIFTI image extension
 //const (
 //	NIFTI_FTYPE_NIFTI1_1     = ".nii"
@@ -93,6 +104,46 @@ const (
 	FSL_TOPUP_FIELD                         int16 = 2018
 )
 
+// NiiStatIntentParams maps the statistical intent codes to the number of distribution parameters stored in
+// intent_p1, intent_p2 and intent_p3
+var NiiStatIntentParams = map[int16]int{
+	NIFTI_INTENT_CORREL:     1, // degrees of freedom
+	NIFTI_INTENT_TTEST:      1, // degrees of freedom
+	NIFTI_INTENT_FTEST:      2, // numerator and denominator degrees of freedom
+	NIFTI_INTENT_ZSCORE:     0,
+	NIFTI_INTENT_CHISQ:      1, // degrees of freedom
+	NIFTI_INTENT_BETA:       2, // a and b
+	NIFTI_INTENT_BINOM:      2, // number of trials and probability per trial
+	NIFTI_INTENT_GAMMA:      2, // shape and scale
+	NIFTI_INTENT_POISSON:    1, // mean
+	NIFTI_INTENT_NORMAL:     2, // mean and standard deviation
+	NIFTI_INTENT_FTEST_NONC: 3, // numerator and denominator degrees of freedom, noncentrality
+	NIFTI_INTENT_CHISQ_NONC: 2, // degrees of freedom and noncentrality
+	NIFTI_INTENT_LOGISTIC:   2, // location and scale
+	NIFTI_INTENT_LAPLACE:    2, // location and scale
+	NIFTI_INTENT_UNIFORM:    2, // start and end
+	NIFTI_INTENT_TTEST_NONC: 2, // degrees of freedom and noncentrality
+	NIFTI_INTENT_WEIBULL:    3, // location, scale and power
+	NIFTI_INTENT_CHI:        1, // degrees of freedom
+	NIFTI_INTENT_INVGAUSS:   2, // mu and lambda
+	NIFTI_INTENT_EXTVAL:     2, // location and scale
+	NIFTI_INTENT_PVAL:       0,
+	NIFTI_INTENT_LOGPVAL:    0,
+	NIFTI_INTENT_LOG10PVAL:  0,
+}
+
+const (
+	NIFTI_ECODE_IGNORE        int32 = 0  // ignored extension
+	NIFTI_ECODE_DICOM         int32 = 2  // raw DICOM attributes
+	NIFTI_ECODE_AFNI          int32 = 4  // AFNI XML header
+	NIFTI_ECODE_COMMENT       int32 = 6  // plain ASCII text only
+	NIFTI_ECODE_XCEDE         int32 = 8  // XCEDE XML header
+	NIFTI_ECODE_JIMDIMINFO    int32 = 10 // JIM image dimension info
+	NIFTI_ECODE_WORKFLOW_FWDS int32 = 12 // workflow forwarding
+	NIFTI_ECODE_FREESURFER    int32 = 14 // FreeSurfer data
+	NIFTI_ECODE_PYPICKLE      int32 = 16 // embedded Python objects
+)
+
 const (
 	NIFTI_UNKNOWN_ORIENT = 0
 	NIFTI_L2R            = 1
@@ -113,6 +164,10 @@ var OrietationToString = map[int]string{
 	NIFTI_S2I:            "Superior-to-Inferior (I)",
 }
 
+// Datatype is the typed form of the NIfTI datatype codes. The DT_* constants remain int32 for compatibility and can be
+// converted with Datatype(DT_INT16)
+type Datatype int32
+
 const (
 	DT_UNKNOWN    int32 = 0    // what it says, dude
 	DT_BINARY     int32 = 1    // binary (1 bit/voxel)
@@ -239,3 +294,30 @@ var NiiMeasurementUnits = map[uint8]string{
 	NIFTI_UNITS_PPM:     "ppm",
 	NIFTI_UNITS_RADS:    "rad/s",
 }
+
+// OverlayMode defines how the nonzero voxels of another label map are merged by Voxels.Overlay
+type OverlayMode int
+
+const (
+	OVERLAY_OVERWRITE OverlayMode = iota // nonzero voxels of the other label map overwrite the existing values
+	OVERLAY_FILL_GAPS                    // nonzero voxels of the other label map only fill the zero voxels
+)
+
+// PadMode defines where the original voxels are placed by Nii.PadTo
+type PadMode int
+
+const (
+	PAD_CENTER PadMode = iota // the original voxels are centered in the padded grid
+	PAD_CORNER                // the original voxels start at the (0, 0, 0) corner of the padded grid
+)
+
+// RoundingMode defines how the values are rounded when encoded to an integer datatype
+type RoundingMode int
+
+const (
+	ROUND_TRUNCATE  RoundingMode = iota // round toward zero, the default
+	ROUND_NEAREST                       // round to the nearest integer, halves away from zero
+	ROUND_HALF_EVEN                     // round to the nearest integer, halves to even (banker's rounding)
+	ROUND_FLOOR                         // round toward negative infinity
+	ROUND_CEIL                          // round toward positive infinity
+)
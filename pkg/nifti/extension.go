@@ -0,0 +1,240 @@
+package nifti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// extensionHeaderSize is the number of bytes of the esize and ecode fields preceding the extension data
+const extensionHeaderSize = 8
+
+// parseExtensions reads the extensions stored between the end of the header and the start of the image data.
+// Extensions that do not fit in the available space are ignored
+func (r *NiiReader) parseExtensions(hReader *bytes.Reader) error {
+	var offset, limit int64
+
+	switch r.version {
+	case NIIVersion1:
+		offset = NII1HeaderSize
+	case NIIVersion2:
+		offset = NII2HeaderSize
+	default:
+		return nil
+	}
+
+	// For a .hdr/.img pair, the extensions run until the end of the header file
+	if r.hReader != nil {
+		limit = hReader.Size()
+	} else {
+		limit = int64(r.data.VoxOffset)
+	}
+	// The reader may hold the header bytes only, e.g. when estimating the memory usage
+	if limit > hReader.Size() {
+		limit = hReader.Size()
+	}
+
+	// The 4-byte extender signifies whether there are extensions after the header
+	if offset+4 > limit {
+		return nil
+	}
+	_, err := hReader.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	extender := [4]byte{}
+	_, err = io.ReadFull(hReader, extender[:])
+	if err != nil {
+		return err
+	}
+	if extender[0] == 0 {
+		return nil
+	}
+	offset += 4
+
+	var extensions []Nifti1Ext
+	for offset+extensionHeaderSize <= limit {
+		var eSize, eCode int32
+		err = binary.Read(hReader, r.binaryOrder, &eSize)
+		if err != nil {
+			return err
+		}
+		err = binary.Read(hReader, r.binaryOrder, &eCode)
+		if err != nil {
+			return err
+		}
+		if eSize < extensionHeaderSize || offset+int64(eSize) > limit {
+			break
+		}
+
+		eData := make([]byte, eSize-extensionHeaderSize)
+		_, err = io.ReadFull(hReader, eData)
+		if err != nil {
+			return err
+		}
+		extensions = append(extensions, Nifti1Ext{
+			ECode: eCode,
+			EData: eData,
+			ESize: eSize,
+		})
+		offset += int64(eSize)
+	}
+
+	r.data.Nifti1Ext = extensions
+	r.data.NumExt = int32(len(extensions))
+	return nil
+}
+
+// getExtension returns the first extension with the matching ecode
+func (n *Nii) getExtension(eCode int32) (Nifti1Ext, bool) {
+	for _, ext := range n.Nifti1Ext {
+		if ext.ECode == eCode {
+			return ext, true
+		}
+	}
+	return Nifti1Ext{}, false
+}
+
+// DICOMExtension returns the raw DICOM data embedded in the first extension with ecode 2
+func (n *Nii) DICOMExtension() ([]byte, bool) {
+	ext, ok := n.getExtension(NIFTI_ECODE_DICOM)
+	if !ok {
+		return nil, false
+	}
+	return ext.EData, true
+}
+
+// AddExtension appends a new extension with the given ecode. The esize, which includes the 8-byte esize and ecode
+// fields, is padded up to the next multiple of 16 and the data is zero-padded accordingly
+func (n *Nii) AddExtension(eCode int32, data []byte) {
+	eSize := (len(data) + extensionHeaderSize + 15) / 16 * 16
+	eData := make([]byte, eSize-extensionHeaderSize)
+	copy(eData, data)
+
+	n.Nifti1Ext = append(n.Nifti1Ext, Nifti1Ext{
+		ECode: eCode,
+		EData: eData,
+		ESize: int32(eSize),
+	})
+	n.NumExt = int32(len(n.Nifti1Ext))
+}
+
+// AFNIExtensionXML returns the AFNI XML header stored in the first extension with ecode 4
+func (n *Nii) AFNIExtensionXML() (string, bool) {
+	ext, ok := n.getExtension(NIFTI_ECODE_AFNI)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimRight(string(ext.EData), "\x00"), true
+}
+
+// AddAFNIExtension appends the AFNI XML header as a new extension with ecode 4
+func (n *Nii) AddAFNIExtension(xml string) {
+	// AFNI expects the XML string to be NUL-terminated
+	n.AddExtension(NIFTI_ECODE_AFNI, append([]byte(xml), 0x0))
+}
+
+// labelTablePrefix identifies the comment extension holding the label table
+const labelTablePrefix = "gonii:label_table\n"
+
+// LabelTable returns the label names by label value stored in the label table extension, as written by SetLabelTable.
+// The table is stored in a comment extension (ecode 6) as one "<value>\t<name>" line per label, similar to a
+// FreeSurfer color lookup table
+func (n *Nii) LabelTable() (map[int]string, bool) {
+	for _, ext := range n.Nifti1Ext {
+		if !isLabelTableExtension(ext) {
+			continue
+		}
+		table := make(map[int]string)
+		lines := strings.Split(strings.TrimRight(string(ext.EData[len(labelTablePrefix):]), "\x00"), "\n")
+		for _, line := range lines {
+			fields := strings.SplitN(line, "\t", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			label, err := strconv.Atoi(fields[0])
+			if err != nil {
+				continue
+			}
+			table[label] = fields[1]
+		}
+		return table, true
+	}
+	return nil, false
+}
+
+// SetLabelTable stores the label names by label value in a comment extension, replacing the existing label table.
+// Line breaks and tabs in the names are replaced by spaces
+func (n *Nii) SetLabelTable(table map[int]string) {
+	n.removeExtensions(isLabelTableExtension)
+
+	labels := make([]int, 0, len(table))
+	for label := range table {
+		labels = append(labels, label)
+	}
+	sort.Ints(labels)
+
+	replacer := strings.NewReplacer("\r", " ", "\n", " ", "\t", " ")
+	var sb strings.Builder
+	sb.WriteString(labelTablePrefix)
+	for _, label := range labels {
+		sb.WriteString(fmt.Sprintf("%d\t%s\n", label, replacer.Replace(table[label])))
+	}
+	n.AddExtension(NIFTI_ECODE_COMMENT, []byte(sb.String()))
+}
+
+// isLabelTableExtension returns whether the extension holds the label table
+func isLabelTableExtension(ext Nifti1Ext) bool {
+	return ext.ECode == NIFTI_ECODE_COMMENT && bytes.HasPrefix(ext.EData, []byte(labelTablePrefix))
+}
+
+// removeExtensions removes the extensions matching the predicate
+func (n *Nii) removeExtensions(match func(ext Nifti1Ext) bool) {
+	extensions := n.Nifti1Ext[:0:0]
+	for _, ext := range n.Nifti1Ext {
+		if !match(ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	n.Nifti1Ext = extensions
+	n.NumExt = int32(len(extensions))
+}
+
+// extensionSize returns the number of bytes needed to store the extender and the extensions after the header
+func (n *Nii) extensionSize() int {
+	size := 4
+	for _, ext := range n.Nifti1Ext {
+		size += int(ext.ESize)
+	}
+	return size
+}
+
+// encodeExtensions serializes the 4-byte extender followed by the extensions
+func (n *Nii) encodeExtensions(byteOrder binary.ByteOrder) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	extender := [4]byte{}
+	if len(n.Nifti1Ext) > 0 {
+		extender[0] = 1
+	}
+	buf.Write(extender[:])
+
+	for _, ext := range n.Nifti1Ext {
+		if int(ext.ESize) != len(ext.EData)+extensionHeaderSize {
+			return nil, fmt.Errorf("extension with ecode %d has esize %d but %d bytes of data", ext.ECode, ext.ESize, len(ext.EData))
+		}
+		err := binary.Write(buf, byteOrder, ext.ESize)
+		if err != nil {
+			return nil, err
+		}
+		err = binary.Write(buf, byteOrder, ext.ECode)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(ext.EData)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNii_AFNIExtension(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{}
+	_, ok := img.AFNIExtensionXML()
+	assert.False(ok)
+
+	xml := `<AFNI_attributes self_idcode="XYZ_abc" NIfTI_nums="64,64,32,1,1,4"></AFNI_attributes>`
+	img.AddAFNIExtension(xml)
+
+	assert.Equal(int32(1), img.NumExt)
+	assert.Equal(int32(0), img.Nifti1Ext[0].ESize%16)
+	assert.Equal(int(img.Nifti1Ext[0].ESize), len(img.Nifti1Ext[0].EData)+8)
+
+	res, ok := img.AFNIExtensionXML()
+	assert.True(ok)
+	assert.Equal(xml, res)
+}
+
+func TestNii_LabelTable(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{}
+	_, ok := img.LabelTable()
+	assert.False(ok)
+
+	img.AddAFNIExtension("<AFNI_attributes></AFNI_attributes>")
+	img.SetLabelTable(map[int]string{0: "Unknown", 2: "Left-Cerebral-White-Matter", 17: "Left\tHippocampus"})
+	img.SetLabelTable(map[int]string{0: "Unknown", 17: "Left\tHippocampus", 53: "Right-Hippocampus"})
+
+	// The table is replaced, the other extensions are kept
+	assert.Equal(int32(2), img.NumExt)
+	_, ok = img.AFNIExtensionXML()
+	assert.True(ok)
+
+	table, ok := img.LabelTable()
+	assert.True(ok)
+	assert.Equal(map[int]string{0: "Unknown", 17: "Left Hippocampus", 53: "Right-Hippocampus"}, table)
+}
@@ -0,0 +1,474 @@
+package nifti
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"math"
+)
+
+// FieldOfView returns the physical extent Dim[i]*|PixDim[i]| of the image along the x, y and z axes, in the spatial
+// units (XYZUnits)
+func (n *Nii) FieldOfView() [3]float64 {
+	var fov [3]float64
+	for i := 0; i < 3; i++ {
+		fov[i] = float64(n.Dim[i+1]) * math.Abs(n.PixDim[i+1])
+	}
+	return fov
+}
+
+// NormalizePixDim makes the grid spacings (PixDim and Dx, Dy, ...) positive. A negative spatial pixdim is taken as a
+// flip of the axis: if the qform is set, the flip is folded into the quaternion and QFac so that the qform scales the
+// axis by the negative spacing. The sform does not depend on the pixdims and is left unchanged
+func (n *Nii) NormalizePixDim() {
+	signs := [3]float64{1, 1, 1}
+	for i := 0; i < 3; i++ {
+		if n.PixDim[i+1] < 0 {
+			signs[i] = -1
+		}
+	}
+	for i := 1; i < 8; i++ {
+		n.PixDim[i] = math.Abs(n.PixDim[i])
+	}
+	n.Dx, n.Dy, n.Dz = n.PixDim[1], n.PixDim[2], n.PixDim[3]
+	n.Dt, n.Du, n.Dv, n.Dw = n.PixDim[4], n.PixDim[5], n.PixDim[6], n.PixDim[7]
+
+	if n.QformCode <= 0 || signs == [3]float64{1, 1, 1} {
+		return
+	}
+
+	R := n.QuaternToMatrix()
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			R.M[row][col] *= signs[col]
+		}
+	}
+	n.MatrixToQuatern(R)
+	n.PixDim[1], n.PixDim[2], n.PixDim[3] = n.Dx, n.Dy, n.Dz
+	n.QtoXYZ = n.QuaternToMatrix()
+	n.QtoIJK = matrix.Mat44Inverse(n.QtoXYZ)
+	if n.SformCode <= 0 {
+		n.Affine = n.QtoXYZ
+		n.MatrixToOrientation(n.QtoXYZ)
+	}
+}
+
+// MapVoxel maps the voxel coordinates (i, j, k) of the src image to the voxel coordinates of the dst image, going
+// through the world coordinates with the best affine (sform, then qform) of each image
+func MapVoxel(src *Nii, dst *Nii, i, j, k float64) (float64, float64, float64) {
+	x, y, z := applyAffine(src.getBestAffine(), i, j, k)
+	return applyAffine(matrix.Mat44Inverse(dst.getBestAffine()), x, y, z)
+}
+
+// applyAffine returns the coordinates (x, y, z) transformed by the affine matrix
+func applyAffine(R matrix.DMat44, x, y, z float64) (float64, float64, float64) {
+	return R.M[0][0]*x + R.M[0][1]*y + R.M[0][2]*z + R.M[0][3],
+		R.M[1][0]*x + R.M[1][1]*y + R.M[1][2]*z + R.M[1][3],
+		R.M[2][0]*x + R.M[2][1]*y + R.M[2][2]*z + R.M[2][3]
+}
+
+// PadTo returns a new image zero-padded to the target (x, y, z) shape. The original voxels are either centered or
+// placed at the corner of the new grid depending on the mode, and the affine origin is shifted so that they keep their
+// world coordinates. The target shape must not be smaller than the current shape
+func (n *Nii) PadTo(shape [3]int64, mode PadMode) (*Nii, error) {
+	oldDims := [3]int64{n.Nx, n.Ny, n.Nz}
+	for i := 0; i < 3; i++ {
+		if shape[i] < oldDims[i] {
+			return nil, fmt.Errorf("target shape %v is smaller than the current shape %v", shape, oldDims)
+		}
+	}
+
+	var offset [3]int64
+	switch mode {
+	case PAD_CENTER:
+		for i := 0; i < 3; i++ {
+			offset[i] = (shape[i] - oldDims[i]) / 2
+		}
+	case PAD_CORNER:
+	default:
+		return nil, fmt.Errorf("invalid pad mode %d", mode)
+	}
+
+	nByPer := int64(n.NByPer)
+	oldVolumeSize := oldDims[0] * oldDims[1] * oldDims[2]
+	if oldVolumeSize == 0 || nByPer == 0 {
+		return nil, errors.New("image has no voxel data")
+	}
+	nVolumes := int64(len(n.Volume)) / (oldVolumeSize * nByPer)
+	newVolumeSize := shape[0] * shape[1] * shape[2]
+
+	// The padding holds the raw value representing zero, which depends on the scaling of each volume
+	newVolume := make([]byte, newVolumeSize*nVolumes*nByPer)
+	for v := int64(0); v < nVolumes; v++ {
+		slope, inter := n.scalingAt(v * oldVolumeSize)
+		fill, err := ConvertVoxelToBytes(0, slope, inter, n.Datatype, n.ByteOrder, n.NByPer)
+		if err != nil {
+			return nil, err
+		}
+		for idx := v * newVolumeSize; idx < (v+1)*newVolumeSize; idx++ {
+			copy(newVolume[idx*nByPer:(idx+1)*nByPer], fill)
+		}
+	}
+
+	// Copy the rows of the original voxels to their new location
+	rowSize := oldDims[0] * nByPer
+	for v := int64(0); v < nVolumes; v++ {
+		for z := int64(0); z < oldDims[2]; z++ {
+			for y := int64(0); y < oldDims[1]; y++ {
+				oldIndex := v*oldVolumeSize + z*oldDims[0]*oldDims[1] + y*oldDims[0]
+				newIndex := v*newVolumeSize + (z+offset[2])*shape[0]*shape[1] + (y+offset[1])*shape[0] + offset[0]
+				copy(newVolume[newIndex*nByPer:newIndex*nByPer+rowSize], n.Volume[oldIndex*nByPer:oldIndex*nByPer+rowSize])
+			}
+		}
+	}
+
+	// Transformation T from the new voxel indexes to the old voxel indexes
+	T := matrix.DMat44{}
+	for i := 0; i < 3; i++ {
+		T.M[i][i] = 1
+		T.M[i][3] = -float64(offset[i])
+	}
+	T.M[3][3] = 1
+	affine := matrix.Mat44Multiply(n.getBestAffine(), T)
+
+	out := *n
+	out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	out.Volume = newVolume
+	out.Nx, out.Ny, out.Nz = shape[0], shape[1], shape[2]
+	out.Dim[1], out.Dim[2], out.Dim[3] = shape[0], shape[1], shape[2]
+	out.NVox = newVolumeSize * nVolumes
+	out.setBestAffine(affine)
+	return &out, nil
+}
+
+// ExtractSliceAlong returns a new 3-D image holding the slice at index along the voxel axis (0: x, 1: y, 2: z) at time
+// t. The axis keeps its place with a size of 1 and the affine origin is shifted so that the voxels keep their world
+// coordinates. A per-volume scaling is replaced by the scl_slope and scl_inter of the volume t
+func (n *Nii) ExtractSliceAlong(axis int, index, t int64) (*Nii, error) {
+	if axis < 0 || axis > 2 {
+		return nil, fmt.Errorf("invalid axis %d, must be 0, 1 or 2", axis)
+	}
+	dims := [3]int64{dimOrOne(n.Nx), dimOrOne(n.Ny), dimOrOne(n.Nz)}
+	if index < 0 || index >= dims[axis] {
+		return nil, fmt.Errorf("invalid index %d along axis %d of size %d", index, axis, dims[axis])
+	}
+	volumeSize := dims[0] * dims[1] * dims[2]
+	nByPer := int64(n.NByPer)
+	if t < 0 || (t+1)*volumeSize*nByPer > int64(len(n.Volume)) {
+		return nil, fmt.Errorf("invalid time value %d", t)
+	}
+
+	shape := dims
+	shape[axis] = 1
+	newVolume := make([]byte, 0, shape[0]*shape[1]*shape[2]*nByPer)
+	var start, end [3]int64
+	end = dims
+	start[axis], end[axis] = index, index+1
+	for z := start[2]; z < end[2]; z++ {
+		for y := start[1]; y < end[1]; y++ {
+			rowStart := (t*volumeSize + z*dims[0]*dims[1] + y*dims[0] + start[0]) * nByPer
+			newVolume = append(newVolume, n.Volume[rowStart:rowStart+(end[0]-start[0])*nByPer]...)
+		}
+	}
+
+	// Transformation T from the new voxel indexes to the old voxel indexes
+	T := matrix.DMat44{}
+	for i := 0; i < 3; i++ {
+		T.M[i][i] = 1
+	}
+	T.M[axis][3] = float64(index)
+	T.M[3][3] = 1
+	affine := matrix.Mat44Multiply(n.getBestAffine(), T)
+
+	out := *n
+	out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	out.keepVolumeScaling(t)
+	out.Volume = newVolume
+	out.NDim, out.Dim[0] = 3, 3
+	out.Nx, out.Ny, out.Nz = shape[0], shape[1], shape[2]
+	out.Dim[1], out.Dim[2], out.Dim[3] = shape[0], shape[1], shape[2]
+	out.Nt, out.Nu, out.Nv, out.Nw = 1, 1, 1, 1
+	out.Dim[4], out.Dim[5], out.Dim[6], out.Dim[7] = 1, 1, 1, 1
+	out.NVox = shape[0] * shape[1] * shape[2]
+	out.setBestAffine(affine)
+	return &out, nil
+}
+
+// FlipAxis flips the voxel data along the voxel axis (0: x, 1: y, 2: z) and updates the affine, negating the
+// corresponding column and adjusting the translation, so that every voxel keeps its world coordinate
+func (n *Nii) FlipAxis(axis int) error {
+	if axis < 0 || axis > 2 {
+		return fmt.Errorf("invalid axis %d, must be 0, 1 or 2", axis)
+	}
+
+	dims := [3]int64{n.Nx, n.Ny, n.Nz}
+	nByPer := int64(n.NByPer)
+	volumeSize := dims[0] * dims[1] * dims[2]
+	if volumeSize == 0 || nByPer == 0 {
+		return errors.New("image has no voxel data")
+	}
+	nVolumes := int64(len(n.Volume)) / (volumeSize * nByPer)
+	strides := [3]int64{1, dims[0], dims[0] * dims[1]}
+
+	tmp := make([]byte, nByPer)
+	for v := int64(0); v < nVolumes; v++ {
+		var idx [3]int64
+		for idx[2] = 0; idx[2] < dims[2]; idx[2]++ {
+			for idx[1] = 0; idx[1] < dims[1]; idx[1]++ {
+				for idx[0] = 0; idx[0] < dims[0]; idx[0]++ {
+					if idx[axis] >= dims[axis]/2 {
+						continue
+					}
+					index := v*volumeSize + idx[0]*strides[0] + idx[1]*strides[1] + idx[2]*strides[2]
+					mirror := index + (dims[axis]-1-2*idx[axis])*strides[axis]
+					copy(tmp, n.Volume[index*nByPer:(index+1)*nByPer])
+					copy(n.Volume[index*nByPer:(index+1)*nByPer], n.Volume[mirror*nByPer:(mirror+1)*nByPer])
+					copy(n.Volume[mirror*nByPer:(mirror+1)*nByPer], tmp)
+				}
+			}
+		}
+	}
+
+	// Transformation T from the new voxel indexes to the old voxel indexes
+	T := matrix.DMat44{}
+	for i := 0; i < 4; i++ {
+		T.M[i][i] = 1
+	}
+	T.M[axis][axis] = -1
+	T.M[axis][3] = float64(dims[axis] - 1)
+	n.setBestAffine(matrix.Mat44Multiply(n.getBestAffine(), T))
+	return nil
+}
+
+// ResampleIsotropic returns a new image resampled to cubic voxels of the given spacing, in the spatial units of the
+// pixdims (usually mm). The new shape covers the same field of view rounded to whole voxels and the first voxel keeps
+// its world coordinate. The values are interpolated with nearest neighbor if nearest is true, which should be used for
+// label maps, and trilinearly otherwise. Values encoded to an integer datatype are rounded to the nearest integer
+func (n *Nii) ResampleIsotropic(spacing float64, nearest bool) (*Nii, error) {
+	if spacing <= 0 {
+		return nil, fmt.Errorf("invalid spacing %v, must be positive", spacing)
+	}
+
+	fov := n.FieldOfView()
+	var newDims [3]int64
+	var steps [3]float64
+	for i := 0; i < 3; i++ {
+		pixDim := math.Abs(n.PixDim[i+1])
+		if pixDim == 0 {
+			return nil, fmt.Errorf("invalid pixdim[%d] %v", i+1, n.PixDim[i+1])
+		}
+		newDims[i] = int64(math.Round(fov[i] / spacing))
+		if newDims[i] < 1 {
+			newDims[i] = 1
+		}
+		steps[i] = spacing / pixDim
+	}
+
+	return n.resample(newDims, steps, [3]float64{}, nearest)
+}
+
+// SetShapeKeepWorld returns a new image resampled to the (x, y, z) shape over the same physical field of view: the
+// pixdims are scaled by the ratio of the old and new dimensions and the affine is adjusted so that the outer voxel
+// edges keep their world coordinates. The values are interpolated trilinearly, or with the nearest neighbor for a label
+// image (NIFTI_INTENT_LABEL)
+func (n *Nii) SetShapeKeepWorld(shape [3]int64) (*Nii, error) {
+	oldDims := [3]int64{n.Nx, n.Ny, n.Nz}
+	var steps, origin [3]float64
+	for i := 0; i < 3; i++ {
+		if shape[i] < 1 {
+			return nil, fmt.Errorf("invalid shape %v, the dimensions must be positive", shape)
+		}
+		if oldDims[i] < 1 {
+			return nil, fmt.Errorf("invalid dimension %d along axis %d", oldDims[i], i)
+		}
+		// The voxel x of the new grid is centered at (x + 0.5) * steps - 0.5 in the old grid
+		steps[i] = float64(oldDims[i]) / float64(shape[i])
+		origin[i] = (steps[i] - 1) / 2
+	}
+	return n.resample(shape, steps, origin, n.IntentCode == int32(NIFTI_INTENT_LABEL))
+}
+
+// Warp returns a new image on the target grid, of the (x, y, z) shape and with the target voxel to world affine, where
+// each voxel is sampled at the world position given by the transform of its own world position. The source position
+// is mapped to the voxels of the image with the inverse of its best affine and the values are interpolated with nearest
+// neighbor if nearest is true, which should be used for label maps, and trilinearly otherwise. Voxels mapped outside
+// the image are set to 0. Values encoded to an integer datatype are rounded to the nearest integer
+func (n *Nii) Warp(transform func(world [3]float64) [3]float64, targetAffine matrix.DMat44, targetShape [3]int64, nearest bool) (*Nii, error) {
+	if transform == nil {
+		return nil, errors.New("transform is nil")
+	}
+	for i := 0; i < 3; i++ {
+		if targetShape[i] < 1 {
+			return nil, fmt.Errorf("invalid shape %v, the dimensions must be positive", targetShape)
+		}
+	}
+	// The inverse of a singular bordered matrix has a zero bottom-right element
+	toSource := matrix.Mat44Inverse(n.getBestAffine())
+	if toSource.M[3][3] == 0 {
+		return nil, errors.New("image affine is singular")
+	}
+	if matrix.Mat44Inverse(targetAffine).M[3][3] == 0 {
+		return nil, errors.New("target affine is singular")
+	}
+
+	oldDims := [3]int64{n.Nx, n.Ny, n.Nz}
+	nByPer := int64(n.NByPer)
+	oldVolumeSize := oldDims[0] * oldDims[1] * oldDims[2]
+	if oldVolumeSize == 0 || nByPer == 0 {
+		return nil, errors.New("image has no voxel data")
+	}
+	nVolumes := int64(len(n.Volume)) / (oldVolumeSize * nByPer)
+
+	// The source positions are shared by all the volumes
+	positions := make([][3]float64, 0, targetShape[0]*targetShape[1]*targetShape[2])
+	inside := make([]bool, 0, cap(positions))
+	for z := int64(0); z < targetShape[2]; z++ {
+		for y := int64(0); y < targetShape[1]; y++ {
+			for x := int64(0); x < targetShape[0]; x++ {
+				var world [3]float64
+				world[0], world[1], world[2] = applyAffine(targetAffine, float64(x), float64(y), float64(z))
+				world = transform(world)
+				var pos [3]float64
+				pos[0], pos[1], pos[2] = applyAffine(toSource, world[0], world[1], world[2])
+				in := true
+				for i := 0; i < 3; i++ {
+					if pos[i] < -0.5 || pos[i] > float64(oldDims[i])-0.5 {
+						in = false
+					}
+				}
+				positions = append(positions, pos)
+				inside = append(inside, in)
+			}
+		}
+	}
+
+	vox := NewVoxels(targetShape[0], targetShape[1], targetShape[2], nVolumes, n.Datatype)
+	idx := 0
+	for v := int64(0); v < nVolumes; v++ {
+		for i, pos := range positions {
+			if inside[i] {
+				vox.voxel[idx] = n.sampleAt(pos, v, nearest)
+			}
+			idx++
+		}
+	}
+
+	out := *n
+	out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	out.Nx, out.Ny, out.Nz = targetShape[0], targetShape[1], targetShape[2]
+	out.Dim[1], out.Dim[2], out.Dim[3] = targetShape[0], targetShape[1], targetShape[2]
+	out.NVox = targetShape[0] * targetShape[1] * targetShape[2] * nVolumes
+	for i := 0; i < 3; i++ {
+		out.PixDim[i+1] = math.Sqrt(targetAffine.M[0][i]*targetAffine.M[0][i] + targetAffine.M[1][i]*targetAffine.M[1][i] +
+			targetAffine.M[2][i]*targetAffine.M[2][i])
+	}
+	out.Dx, out.Dy, out.Dz = out.PixDim[1], out.PixDim[2], out.PixDim[3]
+	err := out.SetVoxelToRawVolumeRounded(vox, ROUND_NEAREST)
+	if err != nil {
+		return nil, err
+	}
+	out.setBestAffine(targetAffine)
+	return &out, nil
+}
+
+// resample returns a new image of the (x, y, z) shape where the voxel (x, y, z) is sampled at the continuous position
+// origin + (x, y, z) * steps of the image, with nearest neighbor or trilinear interpolation. The pixdims and the affine
+// are updated so that the voxels keep their world coordinates
+func (n *Nii) resample(newDims [3]int64, steps, origin [3]float64, nearest bool) (*Nii, error) {
+	oldDims := [3]int64{n.Nx, n.Ny, n.Nz}
+	nByPer := int64(n.NByPer)
+	oldVolumeSize := oldDims[0] * oldDims[1] * oldDims[2]
+	if oldVolumeSize == 0 || nByPer == 0 {
+		return nil, errors.New("image has no voxel data")
+	}
+	nVolumes := int64(len(n.Volume)) / (oldVolumeSize * nByPer)
+
+	vox := NewVoxels(newDims[0], newDims[1], newDims[2], nVolumes, n.Datatype)
+	idx := 0
+	for v := int64(0); v < nVolumes; v++ {
+		for z := int64(0); z < newDims[2]; z++ {
+			for y := int64(0); y < newDims[1]; y++ {
+				for x := int64(0); x < newDims[0]; x++ {
+					pos := [3]float64{
+						origin[0] + float64(x)*steps[0],
+						origin[1] + float64(y)*steps[1],
+						origin[2] + float64(z)*steps[2],
+					}
+					vox.voxel[idx] = n.sampleAt(pos, v, nearest)
+					idx++
+				}
+			}
+		}
+	}
+
+	// Transformation T from the new voxel indexes to the old voxel indexes
+	T := matrix.DMat44{}
+	for i := 0; i < 3; i++ {
+		T.M[i][i] = steps[i]
+		T.M[i][3] = origin[i]
+	}
+	T.M[3][3] = 1
+	affine := matrix.Mat44Multiply(n.getBestAffine(), T)
+
+	out := *n
+	out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	out.Nx, out.Ny, out.Nz = newDims[0], newDims[1], newDims[2]
+	out.Dim[1], out.Dim[2], out.Dim[3] = newDims[0], newDims[1], newDims[2]
+	out.NVox = newDims[0] * newDims[1] * newDims[2] * nVolumes
+	for i := 1; i <= 3; i++ {
+		out.PixDim[i] = n.PixDim[i] * steps[i-1]
+	}
+	out.Dx, out.Dy, out.Dz = math.Abs(out.PixDim[1]), math.Abs(out.PixDim[2]), math.Abs(out.PixDim[3])
+	err := out.SetVoxelToRawVolumeRounded(vox, ROUND_NEAREST)
+	if err != nil {
+		return nil, err
+	}
+	out.setBestAffine(affine)
+	return &out, nil
+}
+
+// sampleAt returns the scaled value of the volume v at the continuous voxel position, clamped to the grid, with nearest
+// neighbor or trilinear interpolation
+func (n *Nii) sampleAt(pos [3]float64, v int64, nearest bool) float64 {
+	dims := [3]int64{n.Nx, n.Ny, n.Nz}
+	base := v * dims[0] * dims[1] * dims[2]
+	for i := 0; i < 3; i++ {
+		pos[i] = math.Max(0, math.Min(pos[i], float64(dims[i]-1)))
+	}
+
+	if nearest {
+		x, y, z := int64(math.Round(pos[0])), int64(math.Round(pos[1])), int64(math.Round(pos[2]))
+		return n.getAtIndex(base + z*dims[0]*dims[1] + y*dims[0] + x)
+	}
+
+	var lower, upper [3]int64
+	var frac [3]float64
+	for i := 0; i < 3; i++ {
+		lower[i] = int64(math.Floor(pos[i]))
+		upper[i] = lower[i] + 1
+		if upper[i] > dims[i]-1 {
+			upper[i] = dims[i] - 1
+		}
+		frac[i] = pos[i] - float64(lower[i])
+	}
+
+	var value float64
+	for corner := 0; corner < 8; corner++ {
+		weight := 1.0
+		var c [3]int64
+		for i := 0; i < 3; i++ {
+			if corner&(1<<i) != 0 {
+				c[i] = upper[i]
+				weight *= frac[i]
+			} else {
+				c[i] = lower[i]
+				weight *= 1 - frac[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		value += weight * n.getAtIndex(base+c[2]*dims[0]*dims[1]+c[1]*dims[0]+c[0])
+	}
+	return value
+}
@@ -0,0 +1,343 @@
+package nifti
+
+import (
+	"encoding/binary"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// newCubeTestImage returns a n³ INT16 image where each voxel value is its index plus one
+func newCubeTestImage(n int64) *Nii {
+	img := newTestNii(n, n, n, 1, DT_INT16)
+	img.Dx, img.Dy, img.Dz = 1, 1, 2
+	img.PixDim = [8]float64{1, 1, 1, 2, 1, 1, 1, 1}
+	img.SformCode = NIFTI_XFORM_SCANNER_ANAT
+	img.StoXYZ = matrix.DMat44{M: [4][4]float64{
+		{1, 0, 0, -10},
+		{0, 1, 0, -20},
+		{0, 0, 2, -30},
+		{0, 0, 0, 1},
+	}}
+	img.Volume = make([]byte, img.NVox*2)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i+1))
+	}
+	return img
+}
+
+func TestNii_PadTo(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+
+	padded, err := img.PadTo([3]int64{8, 8, 8}, PAD_CENTER)
+	assert.NoError(err)
+	assert.Equal([4]int64{8, 8, 8, 1}, padded.GetImgShape())
+	assert.Len(padded.Volume, 8*8*8*2)
+	for z := int64(0); z < 8; z++ {
+		for y := int64(0); y < 8; y++ {
+			for x := int64(0); x < 8; x++ {
+				inside := x >= 2 && x < 6 && y >= 2 && y < 6 && z >= 2 && z < 6
+				if inside {
+					assert.Equal(img.GetAt(x-2, y-2, z-2, 0), padded.GetAt(x, y, z, 0))
+				} else {
+					assert.Equal(0.0, padded.GetAt(x, y, z, 0))
+				}
+			}
+		}
+	}
+	// The original voxels keep their world coordinates
+	assert.Equal(worldCoordinate(img.StoXYZ, 0, 0, 0), worldCoordinate(padded.StoXYZ, 2, 2, 2))
+	assert.Equal(worldCoordinate(img.StoXYZ, 3, 1, 2), worldCoordinate(padded.StoXYZ, 5, 3, 4))
+
+	padded, err = img.PadTo([3]int64{8, 8, 8}, PAD_CORNER)
+	assert.NoError(err)
+	assert.Equal(img.GetAt(3, 3, 3, 0), padded.GetAt(3, 3, 3, 0))
+	assert.Equal(0.0, padded.GetAt(4, 0, 0, 0))
+	assert.Equal(img.StoXYZ, padded.StoXYZ)
+
+	// The original image is left untouched
+	assert.Equal(int64(4), img.Nx)
+	assert.Len(img.Volume, 4*4*4*2)
+
+	_, err = img.PadTo([3]int64{8, 3, 8}, PAD_CENTER)
+	assert.Error(err)
+	_, err = img.PadTo([3]int64{8, 8, 8}, PadMode(5))
+	assert.Error(err)
+}
+
+func TestNii_PadToPerVolumeScaling(t *testing.T) {
+	assert := assert.New(t)
+
+	// Two volumes with a different scaling each
+	img := newCubeTestImage(2)
+	img.Volume = append(img.Volume, img.Volume...)
+	img.NDim, img.Dim[0] = 4, 4
+	img.Nt, img.Dim[4] = 2, 2
+	img.NVox *= 2
+	assert.NoError(img.SetPerVolumeScaling([]float64{2, 0.5}, []float64{10, -1}))
+
+	padded, err := img.PadTo([3]int64{4, 4, 4}, PAD_CORNER)
+	assert.NoError(err)
+	for tp := int64(0); tp < 2; tp++ {
+		assert.Equal(img.GetAt(1, 1, 1, tp), padded.GetAt(1, 1, 1, tp))
+		assert.Equal(0.0, padded.GetAt(3, 3, 3, tp))
+		assert.Equal(0.0, padded.GetAt(2, 0, 0, tp))
+	}
+}
+
+func TestNii_FlipAxis(t *testing.T) {
+	assert := assert.New(t)
+
+	for axis := 0; axis < 3; axis++ {
+		original := newOrientationTestImage()
+		img := newOrientationTestImage()
+
+		err := img.FlipAxis(axis)
+		assert.NoError(err)
+		assert.NotEqual(original.Volume, img.Volume)
+
+		// The flipped voxel keeps its value and its world coordinate
+		oldIdx := [3]int64{1, 2, 1}
+		newIdx := oldIdx
+		newIdx[axis] = [3]int64{4, 3, 2}[axis] - 1 - oldIdx[axis]
+		assert.Equal(original.GetAt(oldIdx[0], oldIdx[1], oldIdx[2], 0), img.GetAt(newIdx[0], newIdx[1], newIdx[2], 0))
+		assert.Equal(worldCoordinate(original.StoXYZ, oldIdx[0], oldIdx[1], oldIdx[2]), worldCoordinate(img.StoXYZ, newIdx[0], newIdx[1], newIdx[2]))
+
+		// Flipping twice returns to the original data and affine
+		err = img.FlipAxis(axis)
+		assert.NoError(err)
+		assert.Equal(original.Volume, img.Volume)
+		assert.Equal(original.StoXYZ, img.StoXYZ)
+	}
+
+	img := newOrientationTestImage()
+	err := img.FlipAxis(3)
+	assert.Error(err)
+}
+
+func TestNii_ResampleIsotropic(t *testing.T) {
+	assert := assert.New(t)
+
+	// 4x4x4 voxels of 1x1x2 mm
+	img := newCubeTestImage(4)
+
+	out, err := img.ResampleIsotropic(1, true)
+	assert.NoError(err)
+	assert.Equal([3]int64{4, 4, 8}, [3]int64{out.Nx, out.Ny, out.Nz})
+	assert.Equal(int64(4*4*8), out.NVox)
+	assert.Len(out.Volume, 4*4*8*2)
+	assert.Equal([3]float64{1, 1, 1}, [3]float64{out.PixDim[1], out.PixDim[2], out.PixDim[3]})
+	assert.Equal([3]float64{1, 1, 1}, [3]float64{out.Dx, out.Dy, out.Dz})
+
+	// Every even z slice matches the original slice and every voxel keeps its world coordinate
+	for z := int64(0); z < 4; z++ {
+		for y := int64(0); y < 4; y++ {
+			for x := int64(0); x < 4; x++ {
+				assert.Equal(img.GetAt(x, y, z, 0), out.GetAt(x, y, 2*z, 0))
+				assert.Equal(worldCoordinate(img.StoXYZ, x, y, z), worldCoordinate(out.StoXYZ, x, y, 2*z))
+			}
+		}
+	}
+
+	// Trilinear interpolation halfway between two slices, rounded to the nearest integer
+	out, err = img.ResampleIsotropic(1, false)
+	assert.NoError(err)
+	assert.Equal((img.GetAt(1, 2, 0, 0)+img.GetAt(1, 2, 1, 0))/2, out.GetAt(1, 2, 1, 0))
+
+	// Coarser grid
+	out, err = img.ResampleIsotropic(2, true)
+	assert.NoError(err)
+	assert.Equal([3]int64{2, 2, 4}, [3]int64{out.Nx, out.Ny, out.Nz})
+	assert.Equal(img.GetAt(2, 2, 1, 0), out.GetAt(1, 1, 1, 0))
+
+	_, err = img.ResampleIsotropic(0, true)
+	assert.Error(err)
+}
+
+func TestNii_NormalizePixDim(t *testing.T) {
+	assert := assert.New(t)
+
+	// Identity rotation with a negative z spacing, as found in some malformed files
+	img := &Nii{
+		QformCode: NIFTI_XFORM_SCANNER_ANAT,
+		QFac:      1,
+		QoffsetX:  -10,
+		QoffsetY:  -20,
+		QoffsetZ:  30,
+		PixDim:    [8]float64{1, 1, 2, -3, -2.5, 0, 0, 0},
+	}
+	img.Dx, img.Dy, img.Dz, img.Dt = 1, 2, -3, -2.5
+
+	img.NormalizePixDim()
+	assert.Equal([8]float64{1, 1, 2, 3, 2.5, 0, 0, 0}, img.PixDim)
+	assert.Equal([4]float64{1, 2, 3, 2.5}, [4]float64{img.Dx, img.Dy, img.Dz, img.Dt})
+	assert.Equal(-1.0, img.QFac)
+
+	// The z axis is flipped by the qform, the offset is unchanged
+	expected := [4][4]float64{
+		{1, 0, 0, -10},
+		{0, 2, 0, -20},
+		{0, 0, -3, 30},
+		{0, 0, 0, 1},
+	}
+	for row := 0; row < 4; row++ {
+		assert.InDeltaSlice(expected[row][:], img.QtoXYZ.M[row][:], 1e-9)
+	}
+	assert.Equal(img.QtoXYZ, img.Affine)
+	assert.Equal([3]int32{NIFTI_L2R, NIFTI_P2A, NIFTI_S2I}, img.IJKOrient)
+
+	// Normalizing again is a no-op
+	qtoXYZ := img.QtoXYZ
+	img.NormalizePixDim()
+	assert.Equal(qtoXYZ, img.QtoXYZ)
+
+	// A negative x spacing
+	img = &Nii{QformCode: NIFTI_XFORM_SCANNER_ANAT, QFac: 1, PixDim: [8]float64{1, -2, 2, 2, 1, 1, 1, 1}}
+	img.Dx, img.Dy, img.Dz = -2, 2, 2
+	img.NormalizePixDim()
+	assert.Equal([3]float64{2, 2, 2}, [3]float64{img.PixDim[1], img.PixDim[2], img.PixDim[3]})
+	assert.InDeltaSlice([]float64{-2, 0, 0}, []float64{img.QtoXYZ.M[0][0], img.QtoXYZ.M[1][0], img.QtoXYZ.M[2][0]}, 1e-9)
+	assert.InDeltaSlice([]float64{0, 2, 0}, []float64{img.QtoXYZ.M[0][1], img.QtoXYZ.M[1][1], img.QtoXYZ.M[2][1]}, 1e-9)
+	assert.InDeltaSlice([]float64{0, 0, 2}, []float64{img.QtoXYZ.M[0][2], img.QtoXYZ.M[1][2], img.QtoXYZ.M[2][2]}, 1e-9)
+}
+
+func TestNii_GetSliceAlong(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+	value := func(x, y, z int64) float64 { return float64(z*16 + y*4 + x + 1) }
+
+	sagittal, err := img.GetSliceAlong(0, 1, 0)
+	assert.NoError(err)
+	coronal, err := img.GetSliceAlong(1, 2, 0)
+	assert.NoError(err)
+	axial, err := img.GetSliceAlong(2, 3, 0)
+	assert.NoError(err)
+	for i := int64(0); i < 4; i++ {
+		for j := int64(0); j < 4; j++ {
+			assert.Equal(value(1, i, j), sagittal[i][j])
+			assert.Equal(value(i, 2, j), coronal[i][j])
+			assert.Equal(value(i, j, 3), axial[i][j])
+		}
+	}
+	slice, err := img.GetSlice(3, 0)
+	assert.NoError(err)
+	assert.Equal(slice, axial)
+
+	_, err = img.GetSliceAlong(3, 0, 0)
+	assert.Error(err)
+	_, err = img.GetSliceAlong(0, 4, 0)
+	assert.Error(err)
+	_, err = img.GetSliceAlong(0, 0, 1)
+	assert.Error(err)
+}
+
+func TestNii_ExtractSliceAlong(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+	for axis, index := range []int64{1, 2, 3} {
+		out, err := img.ExtractSliceAlong(axis, index, 0)
+		assert.NoError(err)
+
+		shape := [4]int64{4, 4, 4, 1}
+		shape[axis] = 1
+		assert.Equal(shape, out.GetImgShape())
+		assert.Equal(int64(16), out.NVox)
+		assert.Len(out.Volume, 16*2)
+
+		expected, err := img.GetSliceAlong(axis, index, 0)
+		assert.NoError(err)
+		actual, err := out.GetSliceAlong(axis, 0, 0)
+		assert.NoError(err)
+		assert.Equal(expected, actual)
+
+		// The voxels keep their world coordinates
+		var coords [3]int64
+		coords[axis] = index
+		assert.Equal(worldCoordinate(img.StoXYZ, coords[0], coords[1], coords[2]), worldCoordinate(out.StoXYZ, 0, 0, 0))
+	}
+
+	_, err := img.ExtractSliceAlong(0, -1, 0)
+	assert.Error(err)
+	_, err = img.ExtractSliceAlong(-1, 0, 0)
+	assert.Error(err)
+}
+
+func TestNii_SetShapeKeepWorld(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+	out, err := img.SetShapeKeepWorld([3]int64{8, 2, 4})
+	assert.NoError(err)
+	assert.Equal([4]int64{8, 2, 4, 1}, out.GetImgShape())
+	assert.Len(out.Volume, 8*2*4*2)
+	assert.Equal(img.FieldOfView(), out.FieldOfView())
+	assert.Equal([3]float64{0.5, 2, 2}, [3]float64{out.PixDim[1], out.PixDim[2], out.PixDim[3]})
+
+	// The outer voxel edges keep their world coordinates
+	edge := func(R matrix.DMat44, dims [3]int64) [2][3]float64 {
+		var corners [2][3]float64
+		for row := 0; row < 3; row++ {
+			for c, pos := range [][3]float64{{-0.5, -0.5, -0.5}, {float64(dims[0]) - 0.5, float64(dims[1]) - 0.5, float64(dims[2]) - 0.5}} {
+				corners[c][row] = R.M[row][0]*pos[0] + R.M[row][1]*pos[1] + R.M[row][2]*pos[2] + R.M[row][3]
+			}
+		}
+		return corners
+	}
+	assert.Equal(edge(img.StoXYZ, [3]int64{4, 4, 4}), edge(out.StoXYZ, [3]int64{8, 2, 4}))
+
+	// Downsampling y by 2 averages the pairs of rows (y = 1 is sampled at 2.5), upsampling x interpolates between the
+	// voxel centers (x = 4 is sampled at 1.75), the INT16 values are rounded
+	assert.Equal(math.Round((img.GetAt(1, 2, 3, 0)+img.GetAt(1, 3, 3, 0))/2+0.75), out.GetAt(4, 1, 3, 0))
+	assert.Equal(img.GetAt(2, 0, 0, 0)+2, out.GetAt(5, 0, 0, 0))
+
+	// Label maps are resampled with nearest neighbor
+	img.IntentCode = int32(NIFTI_INTENT_LABEL)
+	out, err = img.SetShapeKeepWorld([3]int64{8, 8, 8})
+	assert.NoError(err)
+	for _, val := range out.GetVoxels().GetDataset() {
+		assert.Equal(val, float64(int64(val)))
+		assert.True(val >= 1 && val <= 64)
+	}
+
+	_, err = img.SetShapeKeepWorld([3]int64{0, 4, 4})
+	assert.Error(err)
+}
+
+func TestNii_Warp(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+	identity := func(world [3]float64) [3]float64 { return world }
+
+	// The identity transform on the image grid reproduces the input
+	for _, nearest := range []bool{true, false} {
+		warped, err := img.Warp(identity, img.StoXYZ, [3]int64{4, 4, 4}, nearest)
+		assert.NoError(err)
+		assert.Equal(img.Volume, warped.Volume)
+		assert.Equal(img.StoXYZ, warped.StoXYZ)
+		assert.Equal(img.PixDim, warped.PixDim)
+	}
+
+	// A translation of one voxel along x samples the next voxel, the last column falls outside the image
+	shift := func(world [3]float64) [3]float64 { return [3]float64{world[0] + 1, world[1], world[2]} }
+	warped, err := img.Warp(shift, img.StoXYZ, [3]int64{4, 4, 4}, false)
+	assert.NoError(err)
+	for z := int64(0); z < 4; z++ {
+		for y := int64(0); y < 4; y++ {
+			for x := int64(0); x < 3; x++ {
+				assert.Equal(img.GetAt(x+1, y, z, 0), warped.GetAt(x, y, z, 0))
+			}
+			assert.Equal(0.0, warped.GetAt(3, y, z, 0))
+		}
+	}
+
+	_, err = img.Warp(identity, matrix.DMat44{}, [3]int64{4, 4, 4}, false)
+	assert.Error(err)
+	_, err = img.Warp(identity, img.StoXYZ, [3]int64{4, 0, 4}, false)
+	assert.Error(err)
+}
@@ -1,5 +1,11 @@
 package nifti
 
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
 // Nii1Header defines the structure of the NIFTI-1 header
 type Nii1Header struct {
 	SizeofHdr      int32      `json:"sizeof_hdr"`
@@ -87,3 +93,37 @@ type Nii2Header struct {
 	DimInfo       uint8      `json:"dim_info"`
 	UnusedStr     [15]uint8  `json:"unused_str"`
 }
+
+// DiffHeaders compares the two NIfTI-1 headers field by field and returns the differing fields with their values, e.g.
+// "SformCode: 1 != 4". Byte array fields such as Descrip are shown as strings. A nil header is compared as an empty one
+func DiffHeaders(a, b *Nii1Header) []string {
+	if a == nil {
+		a = &Nii1Header{}
+	}
+	if b == nil {
+		b = &Nii1Header{}
+	}
+
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var diffs []string
+	for i := 0; i < va.NumField(); i++ {
+		fa, fb := va.Field(i), vb.Field(i)
+		if reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			continue
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: %s != %s", va.Type().Field(i).Name, formatHeaderField(fa), formatHeaderField(fb)))
+	}
+	return diffs
+}
+
+// formatHeaderField formats the header field value, showing the byte arrays as quoted strings
+func formatHeaderField(field reflect.Value) string {
+	if field.Kind() == reflect.Array && field.Type().Elem().Kind() == reflect.Uint8 {
+		bArr := make([]byte, field.Len())
+		for i := range bArr {
+			bArr[i] = byte(field.Index(i).Uint())
+		}
+		return fmt.Sprintf("%q", strings.TrimRight(string(bArr), "\x00"))
+	}
+	return fmt.Sprintf("%v", field.Interface())
+}
@@ -0,0 +1,21 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiffHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	a := MakeNewNii1Header(&[8]int16{3, 4, 4, 4, 1, 1, 1, 1}, DT_INT16)
+	b := *a
+	assert.Empty(DiffHeaders(a, &b))
+
+	a.SformCode = NIFTI_XFORM_SCANNER_ANAT
+	b.SformCode = NIFTI_XFORM_MNI_152
+	assert.Equal([]string{"SformCode: 1 != 4"}, DiffHeaders(a, &b))
+
+	copy(b.Descrip[:], "edited")
+	assert.Equal([]string{`Descrip: "" != "edited"`, `SformCode: 1 != 4`}, DiffHeaders(a, &b))
+}
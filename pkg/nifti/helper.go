@@ -8,8 +8,10 @@ import (
 	"fmt"
 	gzip "github.com/klauspost/pgzip"
 	"github.com/okieraised/gonii/internal/system"
+	"io"
 	"math"
 	"os"
+	"path/filepath"
 )
 
 // IsValidDatatype checks whether the datatype is valid for NIFTI format
@@ -183,6 +185,43 @@ func getDatatype(datatype int32) string {
 	return ILLEGAL
 }
 
+// String returns the name of the datatype, e.g. "INT16"
+func (dt Datatype) String() string {
+	return getDatatype(int32(dt))
+}
+
+// IsValid returns true if the datatype is a known NIfTI datatype code
+func (dt Datatype) IsValid() bool {
+	_, ok := ValidDatatype[int32(dt)]
+	return ok
+}
+
+// spatialUnitToMM returns the factor converting a length in the spatial unit to millimeters. Unknown units are assumed
+// to be millimeters
+func spatialUnitToMM(xyzUnits int32) float64 {
+	switch uint8(xyzUnits) {
+	case NIFTI_UNITS_METER:
+		return 1000
+	case NIFTI_UNITS_MICRON:
+		return 0.001
+	default:
+		return 1
+	}
+}
+
+// getDimName returns the voxel axis name of the freq_dim, phase_dim or slice_dim value stored in dim_info
+func getDimName(dim int32) string {
+	switch dim {
+	case 1:
+		return "i"
+	case 2:
+		return "j"
+	case 3:
+		return "k"
+	}
+	return "unset"
+}
+
 // getSliceCode returns the name of the slice code
 func getSliceCode(sliceCode int32) string {
 	switch sliceCode {
@@ -245,7 +284,20 @@ func AssignDatatypeSize(datatype int32) (int16, int16) {
 	return nByper, swapSize
 }
 
-// needHeaderSwap checks whether byte swapping is needed. dim0 should be in [0,7], and headerSize should be accurate.
+// saneDims checks that the number of dimensions dim[0] is in [1,7] and that the used dimensions are positive
+func saneDims(dim [8]int64) bool {
+	if dim[0] < 1 || dim[0] > 7 {
+		return false
+	}
+	for i := int64(1); i <= dim[0]; i++ {
+		if dim[i] < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// needHeaderSwap checks whether byte swapping is needed. dim0 should be in [1,7], and headerSize should be accurate.
 //
 // Returns:
 //
@@ -257,12 +309,29 @@ func AssignDatatypeSize(datatype int32) (int16, int16) {
 func needHeaderSwap(dim0 int16) int {
 	d0 := dim0
 	if d0 != 0 {
-		if d0 > 0 && d0 < 7 {
+		if d0 > 0 && d0 <= 7 {
 			return 0
 		}
 
 		d0 = swapInt16(d0)
-		if d0 > 0 && d0 < 7 {
+		if d0 > 0 && d0 <= 7 {
+			return 1
+		}
+		return -1
+	}
+	return -2
+}
+
+// needHeader2Swap is the NIfTI-2 version of needHeaderSwap, where dim0 is stored as int64
+func needHeader2Swap(dim0 int64) int {
+	d0 := dim0
+	if d0 != 0 {
+		if d0 > 0 && d0 <= 7 {
+			return 0
+		}
+
+		d0 = swapInt64(d0)
+		if d0 > 0 && d0 <= 7 {
 			return 1
 		}
 		return -1
@@ -604,7 +673,7 @@ func uint32ToFloat64(v uint32, datatype int32) float64 {
 		value = float64(int32(v))
 	case DT_UINT32:
 		value = float64(v)
-	case DT_FLOAT32, DT_RGBA32:
+	case DT_FLOAT32:
 		value = float64(math.Float32frombits(v))
 	}
 	return value
@@ -615,12 +684,22 @@ func prettyPrint(i interface{}) string {
 	return string(s)
 }
 
-// ConvertVoxelToBytes converts the voxel in float64 back to bytes slice based on datatype and NByPer
+// ConvertVoxelToBytes converts the voxel in float64 back to bytes slice based on datatype and NByPer. Values encoded to
+// an integer datatype are truncated toward zero
 func ConvertVoxelToBytes(voxel, slope, intercept float64, datatype int32, binaryOrder binary.ByteOrder, nByPer int32) ([]byte, error) {
+	return ConvertVoxelToBytesRounded(voxel, slope, intercept, datatype, binaryOrder, nByPer, ROUND_TRUNCATE)
+}
+
+// ConvertVoxelToBytesRounded converts the voxel in float64 back to bytes slice based on datatype and NByPer. Values
+// encoded to an integer datatype are rounded after rescaling according to the rounding mode
+func ConvertVoxelToBytesRounded(voxel, slope, intercept float64, datatype int32, binaryOrder binary.ByteOrder, nByPer int32, mode RoundingMode) ([]byte, error) {
 	// Check if we need to rescale
-	if slope != 0 && datatype != DT_RGB24 {
+	if slope != 0 && datatype != DT_RGB24 && datatype != DT_RGBA32 {
 		voxel = (voxel - intercept) / slope
 	}
+	if isIntegerDatatype(datatype) {
+		voxel = roundVoxel(voxel, mode)
+	}
 
 	switch nByPer {
 	case 0:
@@ -661,8 +740,13 @@ func ConvertVoxelToBytes(voxel, slope, intercept float64, datatype int32, binary
 		}
 		return b[:3], nil
 	case 4: // This fits Uint32
-		v := math.Float32bits(float32(voxel))
 		b := make([]byte, 4)
+		if datatype == DT_RGBA32 {
+			// The color components are stored in R, G, B, A order regardless of the byte order
+			binary.BigEndian.PutUint32(b, uint32(voxel))
+			return b, nil
+		}
+		v := math.Float32bits(float32(voxel))
 		switch binaryOrder {
 		case binary.LittleEndian:
 			binary.LittleEndian.PutUint32(b, v)
@@ -687,16 +771,137 @@ func ConvertVoxelToBytes(voxel, slope, intercept float64, datatype int32, binary
 	return nil, errors.New("unsupported datatype")
 }
 
+// isIntegerDatatype returns whether the datatype stores integer values
+func isIntegerDatatype(datatype int32) bool {
+	switch datatype {
+	case DT_BINARY, DT_INT8, DT_UINT8, DT_INT16, DT_UINT16, DT_INT32, DT_UINT32, DT_INT64, DT_UINT64:
+		return true
+	}
+	return false
+}
+
+// roundVoxel rounds the value to an integer according to the rounding mode
+func roundVoxel(voxel float64, mode RoundingMode) float64 {
+	switch mode {
+	case ROUND_NEAREST:
+		return math.Round(voxel)
+	case ROUND_HALF_EVEN:
+		return math.RoundToEven(voxel)
+	case ROUND_FLOOR:
+		return math.Floor(voxel)
+	case ROUND_CEIL:
+		return math.Ceil(voxel)
+	default:
+		return math.Trunc(voxel)
+	}
+}
+
 func WriteToFile(filePath string, compression bool, dataset []byte) error {
-	file, err := os.Create(filePath)
+	return writeToFile(filePath, compression, dataset, false)
+}
+
+// writeToFile writes the dataset to filePath. If noClobber is set, the file is created exclusively so that an existing
+// file, even one created concurrently, is never overwritten
+func writeToFile(filePath string, compression bool, dataset []byte, noClobber bool) error {
+	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if noClobber {
+		flag = os.O_RDWR | os.O_CREATE | os.O_EXCL
+	}
+	file, err := os.OpenFile(filePath, flag, 0666)
 	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("file %s already exists", filePath)
+		}
 		return err
 	}
 	defer file.Close()
 
+	return writeDataset(file, compression, dataset)
+}
+
+// WriteToFileAtomic writes the dataset to a temporary file in the same directory then renames it to filePath, so that
+// filePath never contains a partially written file
+func WriteToFileAtomic(filePath string, compression bool, dataset []byte) error {
+	return writeToFileAtomic(filePath, compression, dataset, false)
+}
+
+// writeToFileAtomic writes the dataset to a temporary file then moves it to filePath. If noClobber is set, the
+// temporary file is hard-linked to filePath instead of renamed, which fails if filePath exists
+func writeToFileAtomic(filePath string, compression bool, dataset []byte, noClobber bool) error {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpFilePath := file.Name()
+
+	// The temporary file is created with 0600 permission, use the permission of the file being replaced instead
+	var mode os.FileMode = 0644
+	if info, statErr := os.Stat(filePath); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	err = writeDataset(file, compression, dataset)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if noClobber {
+			err = moveNoClobber(tmpFilePath, filePath)
+		} else {
+			err = os.Rename(tmpFilePath, filePath)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(tmpFilePath)
+		return err
+	}
+	return nil
+}
+
+// linkFile creates a hard link, replaced in the tests to simulate a filesystem without hard links
+var linkFile = os.Link
+
+// moveNoClobber moves the temporary file to filePath unless filePath exists. The temporary file is hard-linked to
+// filePath, which fails if filePath exists. On filesystems without hard links, e.g. FAT, filePath is created
+// exclusively first, then the temporary file is renamed over it
+func moveNoClobber(tmpFilePath, filePath string) error {
+	err := linkFile(tmpFilePath, filePath)
+	if err == nil {
+		return os.Remove(tmpFilePath)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("file %s already exists", filePath)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("file %s already exists", filePath)
+		}
+		return err
+	}
+	err = file.Close()
+	if err == nil {
+		err = os.Rename(tmpFilePath, filePath)
+	}
+	if err != nil {
+		_ = os.Remove(filePath)
+	}
+	return err
+}
+
+// writeDataset writes the dataset to the file, compressed with gzip if compression is true
+func writeDataset(file io.Writer, compression bool, dataset []byte) error {
 	if compression { // If the compression is set to true, then write a compressed file
 		gzipWriter := gzip.NewWriter(file)
-		_, err = gzipWriter.Write(dataset)
+		_, err := gzipWriter.Write(dataset)
 		if err != nil {
 			return err
 		}
@@ -705,7 +910,7 @@ func WriteToFile(filePath string, compression bool, dataset []byte) error {
 			return err
 		}
 	} else { // Otherwise, just write normal file
-		_, err = file.Write(dataset)
+		_, err := file.Write(dataset)
 		if err != nil {
 			return err
 		}
@@ -713,27 +918,79 @@ func WriteToFile(filePath string, compression bool, dataset []byte) error {
 	return nil
 }
 
+// RLEEncode encodes the 1-D float64 array as alternating background (zero) and foreground (nonzero) run lengths. The
+// first run is always a background run, so the encoded array starts with 0 if the first value is nonzero. An all-zero
+// array is encoded as a single background run and an all-nonzero array as an empty background run followed by a
+// single foreground run. The foreground values are not kept, use RLEEncodeValues for multi-valued arrays
 func RLEEncode(original []float64) ([]float64, error) {
 	var rleEncoded []float64
 
 	if len(original) == 0 {
 		return nil, errors.New("array has length zero")
 	}
+	if original[0] != 0 {
+		rleEncoded = append(rleEncoded, 0)
+	}
 	for i := 0; i < len(original); i++ {
 		var count float64 = 1
-		if i == 0 && original[i] != 0 {
-			rleEncoded = append(rleEncoded, 0)
-		}
-		for {
-			if i < len(original)-1 && original[i] == original[i+1] {
-				count++
-				i++
-			} else {
-				break
-			}
+		for i < len(original)-1 && (original[i] != 0) == (original[i+1] != 0) {
+			count++
+			i++
 		}
 		rleEncoded = append(rleEncoded, count)
 	}
 
 	return rleEncoded, nil
 }
+
+// dimOrOne returns the dimension, or 1 if the dimension is not set
+func dimOrOne(dim int64) int64 {
+	if dim <= 0 {
+		return 1
+	}
+	return dim
+}
+
+// RunValuePair defines a run of identical values in a RLE-encoded array
+type RunValuePair struct {
+	Value  float64
+	Length int
+}
+
+// RLEEncodeValues encodes the 1-D float64 array as (value, length) runs. Unlike RLEEncode, the values are kept so that
+// arrays with arbitrary values can be decoded exactly by RLEDecodeValues
+func RLEEncodeValues(data []float64) ([]RunValuePair, error) {
+	if len(data) == 0 {
+		return nil, errors.New("array has length zero")
+	}
+
+	runs := []RunValuePair{{Value: data[0], Length: 1}}
+	for _, val := range data[1:] {
+		last := &runs[len(runs)-1]
+		if val == last.Value {
+			last.Length++
+			continue
+		}
+		runs = append(runs, RunValuePair{Value: val, Length: 1})
+	}
+	return runs, nil
+}
+
+// RLEDecodeValues decodes the (value, length) runs returned by RLEEncodeValues back to the 1-D float64 array
+func RLEDecodeValues(runs []RunValuePair) ([]float64, error) {
+	total := 0
+	for _, run := range runs {
+		if run.Length < 0 {
+			return nil, fmt.Errorf("invalid negative run length %d", run.Length)
+		}
+		total += run.Length
+	}
+
+	data := make([]float64, 0, total)
+	for _, run := range runs {
+		for i := 0; i < run.Length; i++ {
+			data = append(data, run.Value)
+		}
+	}
+	return data, nil
+}
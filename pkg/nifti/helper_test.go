@@ -0,0 +1,93 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRLEEncodeValues(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []float64{0, 0, 3, 3, 3, 1.5, 0, -2, -2, 7}
+	runs, err := RLEEncodeValues(data)
+	assert.NoError(err)
+	assert.Equal([]RunValuePair{
+		{Value: 0, Length: 2},
+		{Value: 3, Length: 3},
+		{Value: 1.5, Length: 1},
+		{Value: 0, Length: 1},
+		{Value: -2, Length: 2},
+		{Value: 7, Length: 1},
+	}, runs)
+
+	decoded, err := RLEDecodeValues(runs)
+	assert.NoError(err)
+	assert.Equal(data, decoded)
+
+	_, err = RLEEncodeValues(nil)
+	assert.Error(err)
+}
+
+func TestRLEEncode_AllZero(t *testing.T) {
+	assert := assert.New(t)
+
+	segment := SegmentRLE{DecodedSeg: make([]float64, 6), PixVal: 1}
+	err := segment.Encode()
+	assert.NoError(err)
+	assert.Equal([]float64{6}, segment.EncodedSeg)
+
+	original := segment.DecodedSeg
+	segment.Decode()
+	assert.Equal(original, segment.DecodedSeg)
+}
+
+func TestRLEEncode_AllOne(t *testing.T) {
+	assert := assert.New(t)
+
+	segment := SegmentRLE{DecodedSeg: []float64{1, 1, 1, 1, 1, 1}, PixVal: 1}
+	err := segment.Encode()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 6}, segment.EncodedSeg)
+
+	original := segment.DecodedSeg
+	segment.Decode()
+	assert.Equal(original, segment.DecodedSeg)
+}
+
+func TestRLEEncode_Alternation(t *testing.T) {
+	assert := assert.New(t)
+
+	// Adjacent nonzero values belong to the same foreground run so the background/foreground alternation holds
+	encoded, err := RLEEncode([]float64{2, 3, 0, 0, 5, 0})
+	assert.NoError(err)
+	assert.Equal([]float64{0, 2, 2, 1, 1}, encoded)
+}
+
+func TestWriteToFileAtomic_NoClobberWithoutHardLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	// Simulate a filesystem without hard links, e.g. FAT
+	linkFile = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	defer func() { linkFile = os.Link }()
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "image.nii")
+	assert.NoError(writeToFileAtomic(filePath, false, []byte("new"), true))
+	bData, err := os.ReadFile(filePath)
+	assert.NoError(err)
+	assert.Equal([]byte("new"), bData)
+
+	// The existing file is not replaced and the temporary file is removed
+	assert.Error(writeToFileAtomic(filePath, false, []byte("other"), true))
+	bData, err = os.ReadFile(filePath)
+	assert.NoError(err)
+	assert.Equal([]byte("new"), bData)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(err)
+	assert.Len(entries, 1)
+}
@@ -1,10 +1,15 @@
 package nifti
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/okieraised/gonii/internal/system"
 	"github.com/okieraised/gonii/pkg/matrix"
+	"io"
 	"math"
 	"strings"
 )
@@ -78,6 +83,13 @@ type Nii struct {
 	Affine        matrix.DMat44    `json:"affine"`         // self-add. Affine matrix
 	VoxOffset     float64          `json:"vox_offset"`     // self-add. Voxel offset
 	Version       int              `json:"version"`        // self-add. Used for version identification when writing
+	VolumeSlopes  []float64        `json:"volume_slopes"`  // self-add. Per-volume scaling slopes overriding SclSlope
+	VolumeInters  []float64        `json:"volume_inters"`  // self-add. Per-volume scaling intercepts overriding SclInter
+	MBFactor      int              `json:"mb_factor"`      // self-add. Multiband factor, 0 if inferred from the timing
+	DataTypeStr   [10]byte         `json:"data_type"`      // self-add. Unused NIfTI-1 data_type field, kept for round-trips
+	DbName        [18]byte         `json:"db_name"`        // self-add. Unused NIfTI-1 db_name field, kept for round-trips
+	UnusedStr     [15]byte         `json:"unused_str"`     // self-add. Unused NIfTI-2 unused_str field, kept for round-trips
+	HeaderGap     []byte           `json:"-"`              // self-add. Raw bytes between the header and vox_offset, kept with the header
 }
 
 // Nifti1Ext defines the NIfTI-1 extension
@@ -87,6 +99,50 @@ type Nifti1Ext struct {
 	ESize int32  `json:"e_size"`
 }
 
+// nifti1ExtMetadata defines the JSON representation of an extension without its data
+type nifti1ExtMetadata struct {
+	ECode int32 `json:"e_code"`
+	ESize int32 `json:"e_size"`
+}
+
+// MarshalJSON marshals the NIfTI metadata to JSON. The image data (Volume) and the extension data (EData) are omitted
+// so that logging an image does not dump its content
+func (n *Nii) MarshalJSON() ([]byte, error) {
+	// niiMetadata has the same fields but not the MarshalJSON method, to avoid an infinite recursion
+	type niiMetadata Nii
+
+	extensions := make([]nifti1ExtMetadata, len(n.Nifti1Ext))
+	for i, ext := range n.Nifti1Ext {
+		extensions[i] = nifti1ExtMetadata{ECode: ext.ECode, ESize: ext.ESize}
+	}
+
+	// The outer fields shadow the embedded Volume and Nifti1Ext fields
+	return json.Marshal(struct {
+		*niiMetadata
+		Volume    []byte              `json:"volume,omitempty"`
+		Nifti1Ext []nifti1ExtMetadata `json:"nifti1_ext"`
+	}{
+		niiMetadata: (*niiMetadata)(n),
+		Nifti1Ext:   extensions,
+	})
+}
+
+// VolumeHash returns the SHA-256 checksum of the raw image data. Images with identical data have the same hash
+// regardless of their header
+func (n *Nii) VolumeHash() [32]byte {
+	return sha256.Sum256(n.Volume)
+}
+
+// MetadataHash returns the SHA-256 checksum of the metadata normalized as JSON by MarshalJSON, i.e. without the image
+// data and the extension data
+func (n *Nii) MetadataHash() ([32]byte, error) {
+	bMetadata, err := n.MarshalJSON()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(bMetadata), nil
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 // Get methods
 //----------------------------------------------------------------------------------------------------------------------
@@ -169,6 +225,11 @@ func (n *Nii) GetDatatype() string {
 	return getDatatype(n.Datatype)
 }
 
+// GetTypedDatatype returns the datatype code of the image as a typed Datatype
+func (n *Nii) GetTypedDatatype() Datatype {
+	return Datatype(n.Datatype)
+}
+
 // GetOrientation returns the image orientation
 func (n *Nii) GetOrientation() [3]string {
 	res := [3]string{}
@@ -211,13 +272,128 @@ func (n *Nii) GetVoxels() *Voxels {
 	return vox
 }
 
-// GetAt returns the value at (x, y, z, t) location
+// VoxelValue defines the value of the voxel at (X, Y, Z, T) location
+type VoxelValue struct {
+	X, Y, Z, T int64
+	V          float64
+}
+
+// VoxelChannel streams the voxel values in storage order (x varying fastest) without materializing the whole volume.
+// The channel is closed once all the voxels have been sent or when the context is canceled
+func (n *Nii) VoxelChannel(ctx context.Context) <-chan VoxelValue {
+	ch := make(chan VoxelValue, 64)
+
+	go func() {
+		defer close(ch)
+		for t := int64(0); t < dimOrOne(n.Nt); t++ {
+			for z := int64(0); z < dimOrOne(n.Nz); z++ {
+				for y := int64(0); y < n.Ny; y++ {
+					for x := int64(0); x < n.Nx; x++ {
+						select {
+						case ch <- VoxelValue{X: x, Y: y, Z: z, T: t, V: n.GetAt(x, y, z, t)}:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// GetVoxelsInRegion returns the values inside the box [x0, x1) x [y0, y1) x [z0, z1) at time t, decoding only the
+// requested voxels. The values are ordered with x varying fastest, then y, then z
+func (n *Nii) GetVoxelsInRegion(x0, y0, z0, x1, y1, z1, t int64) ([]float64, error) {
+	if x0 < 0 || x1 > n.Nx || x0 >= x1 {
+		return nil, fmt.Errorf("invalid x range [%d, %d)", x0, x1)
+	}
+	if y0 < 0 || y1 > n.Ny || y0 >= y1 {
+		return nil, fmt.Errorf("invalid y range [%d, %d)", y0, y1)
+	}
+	if z0 < 0 || z1 > n.Nz || z0 >= z1 {
+		return nil, fmt.Errorf("invalid z range [%d, %d)", z0, z1)
+	}
+	if t < 0 || t >= n.Nt {
+		return nil, fmt.Errorf("invalid time value %d", t)
+	}
+
+	values := make([]float64, 0, (x1-x0)*(y1-y0)*(z1-z0))
+	for z := z0; z < z1; z++ {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				values = append(values, n.GetAt(x, y, z, t))
+			}
+		}
+	}
+	return values, nil
+}
+
+// GetAt returns the value at (x, y, z, t) location. Out-of-range coordinates return 0, use GetAtChecked to get an
+// error instead
 func (n *Nii) GetAt(x, y, z, t int64) float64 {
-	tIndex := t * n.Nx * n.Ny * n.Nz
-	zIndex := n.Nx * n.Ny * z
-	yIndex := n.Nx * y
-	xIndex := x
-	index := tIndex + zIndex + yIndex + xIndex
+	index, err := n.voxelIndex(x, y, z, t)
+	if err != nil {
+		return 0
+	}
+	return n.getAtIndex(index)
+}
+
+// GetAtChecked returns the value at (x, y, z, t) location or an error if the coordinates are out of range
+func (n *Nii) GetAtChecked(x, y, z, t int64) (float64, error) {
+	index, err := n.voxelIndex(x, y, z, t)
+	if err != nil {
+		return 0, err
+	}
+	return n.getAtIndex(index), nil
+}
+
+// GetRGBAAt returns the color components of the RGB24 or RGBA32 voxel at (x, y, z, t) location, stored as consecutive
+// bytes regardless of the byte order. The alpha of RGB24 voxels is 255. Out-of-range coordinates and other datatypes
+// return 0 for all the components
+func (n *Nii) GetRGBAAt(x, y, z, t int64) (r, g, b, a uint8) {
+	if n.Datatype != DT_RGB24 && n.Datatype != DT_RGBA32 {
+		return 0, 0, 0, 0
+	}
+	index, err := n.voxelIndex(x, y, z, t)
+	if err != nil {
+		return 0, 0, 0, 0
+	}
+
+	dataPoint := n.Volume[index*int64(n.NByPer) : (index+1)*int64(n.NByPer)]
+	if n.Datatype == DT_RGB24 {
+		return dataPoint[0], dataPoint[1], dataPoint[2], math.MaxUint8
+	}
+	return dataPoint[0], dataPoint[1], dataPoint[2], dataPoint[3]
+}
+
+// DisplayValue returns the value at (x, y, z, t) location for display: the scaling (scl_slope, scl_inter) is applied
+// then the value is clamped to [CalMin, CalMax] if the calibration range is set
+func (n *Nii) DisplayValue(x, y, z, t int64) float64 {
+	value := n.GetAt(x, y, z, t)
+	if n.CalMax > n.CalMin {
+		value = math.Max(n.CalMin, math.Min(n.CalMax, value))
+	}
+	return value
+}
+
+// voxelIndex returns the voxel index of the (x, y, z, t) location after checking that the coordinates are within the
+// image dimensions and the voxel is within the raw volume. t spans all the volumes beyond the third dimension. Zero
+// dimensions, e.g. Nz for a hand-built 2-D image, are treated as 1
+func (n *Nii) voxelIndex(x, y, z, t int64) (int64, error) {
+	nx, ny, nz := dimOrOne(n.Nx), dimOrOne(n.Ny), dimOrOne(n.Nz)
+	if x < 0 || x >= nx || y < 0 || y >= ny || z < 0 || z >= nz || t < 0 {
+		return 0, fmt.Errorf("coordinates (%d, %d, %d, %d) out of range for dimensions (%d, %d, %d)", x, y, z, t, nx, ny, nz)
+	}
+	index := t*nx*ny*nz + z*nx*ny + y*nx + x
+	if (index+1)*int64(n.NByPer) > int64(len(n.Volume)) {
+		return 0, fmt.Errorf("index out of range. Max volume size is %d", len(n.Volume))
+	}
+	return index, nil
+}
+
+// getAtIndex returns the scaled value of the voxel at the index in the raw volume
+func (n *Nii) getAtIndex(index int64) float64 {
 	nByPer := int64(n.NByPer)
 
 	dataPoint := n.Volume[index*nByPer : (index+1)*nByPer]
@@ -226,7 +402,11 @@ func (n *Nii) GetAt(x, y, z, t int64) float64 {
 	switch n.NByPer {
 	case 0:
 	case 1:
-		value = float64(dataPoint[0])
+		if n.Datatype == DT_INT8 {
+			value = float64(int8(dataPoint[0]))
+		} else {
+			value = float64(dataPoint[0])
+		}
 	case 2: // This fits Uint16
 		var v uint16
 		switch n.ByteOrder {
@@ -251,6 +431,11 @@ func (n *Nii) GetAt(x, y, z, t int64) float64 {
 		}
 		value = float64(math.Float32frombits(v))
 	case 4: // This fits Uint32
+		if n.Datatype == DT_RGBA32 {
+			// The color components are stored in R, G, B, A order regardless of the byte order
+			value = float64(binary.BigEndian.Uint32(dataPoint))
+			break
+		}
 		var v uint32
 		switch n.ByteOrder {
 		case binary.LittleEndian:
@@ -273,8 +458,9 @@ func (n *Nii) GetAt(x, y, z, t int64) float64 {
 	default:
 	}
 
-	if n.SclSlope != 0 && n.Datatype != DT_RGB24 {
-		value = n.SclSlope*value + n.SclInter
+	slope, inter := n.scalingAt(index)
+	if slope != 0 && n.Datatype != DT_RGB24 && n.Datatype != DT_RGBA32 {
+		value = slope*value + inter
 	}
 	return value
 }
@@ -332,7 +518,52 @@ func (n *Nii) GetSlice(z, t int64) ([][]float64, error) {
 	return slice, nil
 }
 
-// GetVolume return the whole image volume at time t
+// GetSliceAlong returns the 2-D slice at index along the voxel axis (0: x or sagittal, 1: y or coronal, 2: z or
+// axial) at time t. The slice is indexed by the two remaining axes in order: [y][z] for axis 0, [x][z] for axis 1 and
+// [x][y] for axis 2, as GetSlice
+func (n *Nii) GetSliceAlong(axis int, index, t int64) ([][]float64, error) {
+	if axis < 0 || axis > 2 {
+		return nil, fmt.Errorf("invalid axis %d, must be 0, 1 or 2", axis)
+	}
+	dims := [3]int64{dimOrOne(n.Nx), dimOrOne(n.Ny), dimOrOne(n.Nz)}
+	if index < 0 || index >= dims[axis] {
+		return nil, fmt.Errorf("invalid index %d along axis %d of size %d", index, axis, dims[axis])
+	}
+	if t < 0 || t >= n.volumeCount() {
+		return nil, fmt.Errorf("invalid time value %d", t)
+	}
+
+	// The two remaining axes, in order
+	var rowAxis, colAxis int
+	switch axis {
+	case 0:
+		rowAxis, colAxis = 1, 2
+	case 1:
+		rowAxis, colAxis = 0, 2
+	case 2:
+		rowAxis, colAxis = 0, 1
+	}
+
+	slice := make([][]float64, dims[rowAxis])
+	var coords [3]int64
+	coords[axis] = index
+	for i := range slice {
+		slice[i] = make([]float64, dims[colAxis])
+		coords[rowAxis] = int64(i)
+		for j := range slice[i] {
+			coords[colAxis] = int64(j)
+			value, err := n.GetAtChecked(coords[0], coords[1], coords[2], t)
+			if err != nil {
+				return nil, err
+			}
+			slice[i][j] = value
+		}
+	}
+	return slice, nil
+}
+
+// GetVolume return the whole image volume at time t, indexed as [x][y][z]. See ToArray3D for the [z][y][x] storage
+// order variant, which is faster to build
 func (n *Nii) GetVolume(t int64) ([][][]float64, error) {
 	sliceX := n.Nx
 	sliceY := n.Ny
@@ -359,6 +590,65 @@ func (n *Nii) GetVolume(t int64) ([][][]float64, error) {
 	return volume, nil
 }
 
+// GetVolumeFlat returns the whole image volume at time t as a single slice in storage order, x varying fastest, so that
+// the value at (x, y, z) is at index z*Nx*Ny + y*Nx + x. The raw volume is decoded in one pass. t spans all the volumes
+// beyond the third dimension
+func (n *Nii) GetVolumeFlat(t int64) ([]float64, error) {
+	if t < 0 || t >= n.volumeCount() {
+		return nil, fmt.Errorf("invalid time value %d", t)
+	}
+	volumeSize := dimOrOne(n.Nx) * dimOrOne(n.Ny) * dimOrOne(n.Nz)
+	return n.getFlat(t*volumeSize, volumeSize)
+}
+
+// ToArray3D returns the whole image volume at time t indexed as [z][y][x], i.e. in the NIfTI storage order where x
+// varies fastest, so that ToArray3D(t)[z][y][x] is GetAt(x, y, z, t). Unlike GetVolume, which is indexed as [x][y][z],
+// the rows share a single contiguous backing slice decoded in one pass
+func (n *Nii) ToArray3D(t int64) ([][][]float64, error) {
+	flat, err := n.GetVolumeFlat(t)
+	if err != nil {
+		return nil, err
+	}
+
+	nx, ny, nz := dimOrOne(n.Nx), dimOrOne(n.Ny), dimOrOne(n.Nz)
+	volume := make([][][]float64, nz)
+	for z := range volume {
+		volume[z] = make([][]float64, ny)
+		for y := range volume[z] {
+			start := (int64(z)*ny + int64(y)) * nx
+			volume[z][y] = flat[start : start+nx : start+nx]
+		}
+	}
+	return volume, nil
+}
+
+// GetSliceFlat returns the image in x-y dimension at slice z and time t as a single slice in storage order, x varying
+// fastest, so that the value at (x, y) is at index y*Nx + x
+func (n *Nii) GetSliceFlat(z, t int64) ([]float64, error) {
+	if t < 0 || t >= n.volumeCount() {
+		return nil, fmt.Errorf("invalid time value %d", t)
+	}
+	nz := dimOrOne(n.Nz)
+	if z < 0 || z >= nz {
+		return nil, fmt.Errorf("invalid z value %d", z)
+	}
+	sliceSize := dimOrOne(n.Nx) * dimOrOne(n.Ny)
+	return n.getFlat((t*nz+z)*sliceSize, sliceSize)
+}
+
+// getFlat decodes count consecutive voxels of the raw volume starting at the voxel index start
+func (n *Nii) getFlat(start, count int64) ([]float64, error) {
+	if (start+count)*int64(n.NByPer) > int64(len(n.Volume)) {
+		return nil, fmt.Errorf("index out of range. Max volume size is %d", len(n.Volume))
+	}
+
+	values := make([]float64, count)
+	for i := range values {
+		values[i] = n.getAtIndex(start + int64(i))
+	}
+	return values, nil
+}
+
 // GetUnitsOfMeasurements returns the spatial and temporal units of measurements
 func (n *Nii) GetUnitsOfMeasurements() ([2]string, error) {
 	units := [2]string{}
@@ -522,6 +812,21 @@ func (n *Nii) GetSliceDim() int32 {
 	return n.SliceDim
 }
 
+// FreqDimName returns the voxel axis ("i", "j" or "k") of the frequency encoding direction, or "unset"
+func (n *Nii) FreqDimName() string {
+	return getDimName(n.FreqDim)
+}
+
+// PhaseDimName returns the voxel axis ("i", "j" or "k") of the phase encoding direction, or "unset"
+func (n *Nii) PhaseDimName() string {
+	return getDimName(n.PhaseDim)
+}
+
+// SliceDimName returns the voxel axis ("i", "j" or "k") of the slice direction, or "unset"
+func (n *Nii) SliceDimName() string {
+	return getDimName(n.SliceDim)
+}
+
 //----------------------------------------------------------------------------------------------------------------------
 // Set methods
 //----------------------------------------------------------------------------------------------------------------------
@@ -566,9 +871,14 @@ func (n *Nii) SetDatatype(datatype int32) error {
 	return fmt.Errorf("unknown datatype value %d", datatype)
 }
 
-// SetAffine sets the new 4x4 affine matrix
+// SetTypedDatatype sets the datatype of the image from a typed Datatype
+func (n *Nii) SetTypedDatatype(datatype Datatype) error {
+	return n.SetDatatype(int32(datatype))
+}
+
+// SetAffine sets the new 4x4 affine matrix. The sform and the qform, whichever are set, are updated accordingly
 func (n *Nii) SetAffine(mat matrix.DMat44) {
-	n.Affine = mat
+	n.setBestAffine(mat)
 }
 
 // SetDescrip returns the description with trailing null bytes removed
@@ -651,9 +961,11 @@ func (n *Nii) SetSclInter(sclInter float64) {
 	n.SclInter = sclInter
 }
 
-// SetPixDim sets the PixDim parameter
-func (n *Nii) SetPixDim() [8]float64 {
-	return n.PixDim
+// SetPixDim sets the PixDim parameter and keeps the grid spacings (Dx, Dy, ...) in sync
+func (n *Nii) SetPixDim(pixDim [8]float64) {
+	n.PixDim = pixDim
+	n.Dx, n.Dy, n.Dz = pixDim[1], pixDim[2], pixDim[3]
+	n.Dt, n.Du, n.Dv, n.Dw = pixDim[4], pixDim[5], pixDim[6], pixDim[7]
 }
 
 // SetDim sets the Dim parameter
@@ -696,6 +1008,25 @@ func (n *Nii) SetIntentP3(intentP3 float64) {
 	n.IntentP3 = intentP3
 }
 
+// SetStatIntent sets the statistical intent code and its distribution parameters after checking that the number of
+// parameters matches the intent code, e.g. 1 degrees of freedom for a t-test or 2 for an F-test
+func (n *Nii) SetStatIntent(code int32, params ...float64) error {
+	nParams, ok := NiiStatIntentParams[int16(code)]
+	if !ok {
+		return fmt.Errorf("intent code %d is not a statistical intent", code)
+	}
+	if len(params) != nParams {
+		return fmt.Errorf("intent code %d requires %d parameters, got %d", code, nParams, len(params))
+	}
+
+	var intentParams [3]float64
+	copy(intentParams[:], params)
+
+	n.IntentCode = code
+	n.IntentP1, n.IntentP2, n.IntentP3 = intentParams[0], intentParams[1], intentParams[2]
+	return nil
+}
+
 // SetFreqDim sets the FreqDim parameters
 func (n *Nii) SetFreqDim(freqDim int32) {
 	n.FreqDim = freqDim
@@ -751,20 +1082,123 @@ func (n *Nii) SetVolume(vol []byte) error {
 	return nil
 }
 
+// SetInt16Volume encodes the stored (unscaled) values of an INT16 image directly to the volume bytes in the image's
+// byte order, without the float64 round trip of SetVoxelToRawVolume. The length of data must be NVox
+func (n *Nii) SetInt16Volume(data []int16) error {
+	if n.Datatype != DT_INT16 {
+		return fmt.Errorf("expected datatype INT16, got %s", n.GetDatatype())
+	}
+	if int64(len(data)) != n.NVox {
+		return fmt.Errorf("expected %d voxels, got %d", n.NVox, len(data))
+	}
+
+	byteOrder := n.volumeByteOrder()
+	volume := make([]byte, 2*len(data))
+	for i, v := range data {
+		byteOrder.PutUint16(volume[2*i:], uint16(v))
+	}
+	n.Volume = volume
+	return nil
+}
+
+// SetFloat32Volume encodes the stored (unscaled) values of a FLOAT32 image directly to the volume bytes in the image's
+// byte order, without the float64 round trip of SetVoxelToRawVolume. The length of data must be NVox
+func (n *Nii) SetFloat32Volume(data []float32) error {
+	if n.Datatype != DT_FLOAT32 {
+		return fmt.Errorf("expected datatype FLOAT32, got %s", n.GetDatatype())
+	}
+	if int64(len(data)) != n.NVox {
+		return fmt.Errorf("expected %d voxels, got %d", n.NVox, len(data))
+	}
+
+	byteOrder := n.volumeByteOrder()
+	volume := make([]byte, 4*len(data))
+	for i, v := range data {
+		byteOrder.PutUint32(volume[4*i:], math.Float32bits(v))
+	}
+	n.Volume = volume
+	return nil
+}
+
+// DumpVolume writes the raw voxel bytes of the volume, in the image's byte order and without any header, for sharing
+// the volume separately from its metadata. The length of the volume must match NVox and the datatype size
+func (n *Nii) DumpVolume(w io.Writer) error {
+	expectedLen := n.NVox * int64(n.NByPer)
+	if int64(len(n.Volume)) != expectedLen {
+		return fmt.Errorf("expected length of volume does not match. Expected %d Actual %d", expectedLen, len(n.Volume))
+	}
+	_, err := w.Write(n.Volume)
+	return err
+}
+
+// LoadVolume reads exactly expectedLen raw voxel bytes, as written by DumpVolume, into the volume. The bytes are
+// interpreted with the current header, so expectedLen must match NVox and the datatype size. An expectedLen of 0 or
+// less uses the length computed from the header. The volume is left unchanged on error
+func (n *Nii) LoadVolume(r io.Reader, expectedLen int64) error {
+	headerLen := n.NVox * int64(n.NByPer)
+	if expectedLen <= 0 {
+		expectedLen = headerLen
+	}
+	if expectedLen != headerLen {
+		return fmt.Errorf("expected length %d does not match the header volume length %d", expectedLen, headerLen)
+	}
+
+	volume := make([]byte, expectedLen)
+	read, err := io.ReadFull(r, volume)
+	if err != nil {
+		return fmt.Errorf("expected %d bytes of volume, got %d: %w", expectedLen, read, err)
+	}
+	n.Volume = volume
+	return nil
+}
+
+// volumeByteOrder returns the byte order of the volume, defaulting to the native endian if unset like the writer does
+func (n *Nii) volumeByteOrder() binary.ByteOrder {
+	if n.ByteOrder == nil {
+		return system.NativeEndian
+	}
+	return n.ByteOrder
+}
+
+// SwapVolumeByteOrder reverses the bytes of each swap unit (SwapSize, e.g. each half of a complex) of the raw volume in
+// place and flips the byte order, so that the decoded values are unchanged. Single byte datatypes, including RGB, are
+// left untouched but their byte order is flipped too
+func (n *Nii) SwapVolumeByteOrder() error {
+	swapSize := int(n.SwapSize)
+	if swapSize == 0 {
+		_, size := AssignDatatypeSize(n.Datatype)
+		swapSize = int(size)
+	}
+	if swapSize > 1 {
+		if len(n.Volume)%swapSize != 0 {
+			return fmt.Errorf("volume size %d is not a multiple of the swap size %d", len(n.Volume), swapSize)
+		}
+		for start := 0; start < len(n.Volume); start += swapSize {
+			unit := n.Volume[start : start+swapSize]
+			for i, j := 0, swapSize-1; i < j; i, j = i+1, j-1 {
+				unit[i], unit[j] = unit[j], unit[i]
+			}
+		}
+	}
+
+	if n.volumeByteOrder() == binary.LittleEndian {
+		n.ByteOrder = binary.BigEndian
+	} else {
+		n.ByteOrder = binary.LittleEndian
+	}
+	return nil
+}
+
 // SetAt sets the new value in bytes at (x, y, z, t) location
 func (n *Nii) SetAt(newVal float64, x, y, z, t int64) error {
-
-	tIndex := t * n.Nx * n.Ny * n.Nz
-	zIndex := n.Nx * n.Ny * z
-	yIndex := n.Nx * y
-	xIndex := x
-	index := tIndex + zIndex + yIndex + xIndex
+	index, err := n.voxelIndex(x, y, z, t)
+	if err != nil {
+		return err
+	}
 	nByPer := int64(n.NByPer)
 
-	if index*nByPer > int64(len(n.Volume)) || (index+1)*nByPer > int64(len(n.Volume)) {
-		return fmt.Errorf("index out of range. Max volume size is %d", len(n.Volume))
-	}
-	bVal, err := ConvertVoxelToBytes(newVal, n.SclSlope, n.SclInter, n.Datatype, n.ByteOrder, n.NByPer)
+	slope, inter := n.scalingAt(index)
+	bVal, err := ConvertVoxelToBytes(newVal, slope, inter, n.Datatype, n.ByteOrder, n.NByPer)
 	if err != nil {
 		return err
 	}
@@ -772,13 +1206,21 @@ func (n *Nii) SetAt(newVal float64, x, y, z, t int64) error {
 	return nil
 }
 
-// SetVoxelToRawVolume converts the 1-D slice of float64 back to byte array
+// SetVoxelToRawVolume converts the 1-D slice of float64 back to byte array. Values encoded to an integer datatype are
+// truncated toward zero
 func (n *Nii) SetVoxelToRawVolume(vox *Voxels) error {
+	return n.SetVoxelToRawVolumeRounded(vox, ROUND_TRUNCATE)
+}
+
+// SetVoxelToRawVolumeRounded converts the 1-D slice of float64 back to byte array, rounding the values encoded to an
+// integer datatype according to the rounding mode, e.g. ROUND_NEAREST for resampled label maps
+func (n *Nii) SetVoxelToRawVolumeRounded(vox *Voxels, mode RoundingMode) error {
 	result := make([]byte, vox.GetRawByteSize(), vox.GetRawByteSize())
 	nByPer := n.NByPer
 
 	for index, voxel := range vox.voxel {
-		bVal, err := ConvertVoxelToBytes(voxel, n.SclSlope, n.SclInter, n.Datatype, n.ByteOrder, nByPer)
+		slope, inter := n.scalingAt(int64(index))
+		bVal, err := ConvertVoxelToBytesRounded(voxel, slope, inter, n.Datatype, n.ByteOrder, nByPer, mode)
 		if err != nil {
 			return err
 		}
@@ -787,3 +1229,88 @@ func (n *Nii) SetVoxelToRawVolume(vox *Voxels) error {
 	n.Volume = result
 	return nil
 }
+
+// RemapLabels replaces each voxel value found in the mapping by its mapped value and writes the result back to the
+// raw volume. Unmapped values are left unchanged
+func (n *Nii) RemapLabels(mapping map[float64]float64) error {
+	vox := n.GetVoxels()
+	vox.Remap(mapping)
+	return n.SetVoxelToRawVolume(vox)
+}
+
+// ClipRobustRange clips the voxel intensities to the lowPct and highPct percentiles, similar to the FSL robust range,
+// and stores the clip values in CalMin and CalMax for display
+func (n *Nii) ClipRobustRange(lowPct, highPct float64) error {
+	if lowPct >= highPct {
+		return fmt.Errorf("low percentile %v must be less than high percentile %v", lowPct, highPct)
+	}
+
+	vox := n.GetVoxels()
+	clipValues, err := vox.Percentiles(lowPct, highPct)
+	if err != nil {
+		return err
+	}
+	vox.Clip(clipValues[0], clipValues[1])
+
+	err = n.SetVoxelToRawVolume(vox)
+	if err != nil {
+		return err
+	}
+	n.CalMin, n.CalMax = clipValues[0], clipValues[1]
+	return nil
+}
+
+// LabelVolumes returns the physical volume in mm³ of each nonzero label, computed from the number of voxels times the
+// voxel volume (dx*dy*dz) converted to millimeters using the spatial unit. An unknown spatial unit is assumed to be
+// millimeters. For 4-D images, the voxels of all the volumes are counted
+func (n *Nii) LabelVolumes() map[float64]float64 {
+	scale := spatialUnitToMM(n.XYZUnits)
+	voxelVolume := math.Abs(n.Dx*scale) * math.Abs(n.Dy*scale) * math.Abs(n.Dz*scale)
+
+	volumes := make(map[float64]float64)
+	for label, count := range n.GetVoxels().MapValueOccurrence() {
+		if label == 0 {
+			continue
+		}
+		volumes[label] = float64(count) * voxelVolume
+	}
+	return volumes
+}
+
+// CenterOfMassWorld returns the world coordinates of the centroid of the voxels equal to the label, mapping the voxel
+// centroid (see Voxels.CenterOfMass) with the best affine. false is returned if the label is absent
+func (n *Nii) CenterOfMassWorld(label float64) ([3]float64, bool) {
+	center, ok := n.GetVoxels().CenterOfMass(label)
+	if !ok {
+		return [3]float64{}, false
+	}
+	var world [3]float64
+	world[0], world[1], world[2] = applyAffine(n.getBestAffine(), center[0], center[1], center[2])
+	return world, true
+}
+
+// Anonymize clears the header fields that may contain identifying information (Descrip, AuxFile, IntentName, DbName)
+// and the bytes preserved between the header and the voxel data, and drops the extensions. If ecodes are specified,
+// only the extensions with matching ecode are dropped. The per-volume scaling extension is always kept so that the
+// scaling still matches the file
+func (n *Nii) Anonymize(ecodes ...int32) {
+	n.Descrip = [80]byte{}
+	n.AuxFile = [24]byte{}
+	n.IntentName = [16]byte{}
+	n.DbName = [18]byte{}
+	n.HeaderGap = nil
+
+	dropped := make(map[int32]bool, len(ecodes))
+	for _, ecode := range ecodes {
+		dropped[ecode] = true
+	}
+
+	var kept []Nifti1Ext
+	for _, ext := range n.Nifti1Ext {
+		if isPerVolumeScalingExtension(ext) || (len(ecodes) > 0 && !dropped[ext.ECode]) {
+			kept = append(kept, ext)
+		}
+	}
+	n.Nifti1Ext = kept
+	n.NumExt = int32(len(kept))
+}
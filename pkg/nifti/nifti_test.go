@@ -0,0 +1,613 @@
+package nifti
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newTestNii returns a little endian image with the dimensions and the datatype and a zeroed volume. NDim is 4 if the
+// image has more than one volume, 3 otherwise
+func newTestNii(nx, ny, nz, nt int64, datatype int32) *Nii {
+	nByPer, swapSize := AssignDatatypeSize(datatype)
+	nDim := int64(3)
+	if nt > 1 {
+		nDim = 4
+	}
+	img := &Nii{
+		NDim:      nDim,
+		Nx:        nx,
+		Ny:        ny,
+		Nz:        nz,
+		Nt:        nt,
+		Dim:       [8]int64{nDim, nx, ny, nz, nt, 1, 1, 1},
+		NVox:      nx * ny * nz * nt,
+		NByPer:    int32(nByPer),
+		SwapSize:  int32(swapSize),
+		Datatype:  datatype,
+		ByteOrder: binary.LittleEndian,
+	}
+	img.Volume = make([]byte, img.NVox*int64(nByPer))
+	return img
+}
+
+func TestNii_SetPixDim(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{}
+	pixDim := [8]float64{1, 0.5, 0.75, 2, 3, 1, 1, 1}
+	img.SetPixDim(pixDim)
+
+	assert.Equal(pixDim, img.GetPixDim())
+	assert.Equal(0.5, img.Dx)
+	assert.Equal(0.75, img.Dy)
+	assert.Equal(2.0, img.Dz)
+	assert.Equal(3.0, img.Dt)
+}
+
+func TestNii_Anonymize(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{}
+	assert.NoError(img.SetDescrip("John Doe, 1970-01-01"))
+	assert.NoError(img.SetAuxFile("patient_1234.txt"))
+	assert.NoError(img.SetIntentName("subject-42"))
+	copy(img.DbName[:], "John Doe")
+	img.Nifti1Ext = []Nifti1Ext{{ECode: 2, ESize: 16}, {ECode: 4, ESize: 16}}
+	img.NumExt = 2
+
+	img.Anonymize()
+	assert.Equal([18]byte{}, img.DbName)
+	assert.Equal("", img.GetDescrip())
+	assert.Equal("", img.GetAuxFile())
+	assert.Equal("", img.GetIntentName())
+	assert.Empty(img.Nifti1Ext)
+	assert.Equal(int32(0), img.NumExt)
+
+	// Only drop the DICOM extension
+	img.Nifti1Ext = []Nifti1Ext{{ECode: 2, ESize: 16}, {ECode: 4, ESize: 16}}
+	img.NumExt = 2
+	img.Anonymize(2)
+	assert.Equal([]Nifti1Ext{{ECode: 4, ESize: 16}}, img.Nifti1Ext)
+	assert.Equal(int32(1), img.NumExt)
+
+	// The per-volume scaling is kept with its extension and the preserved header bytes are cleared
+	img = newTestNii(2, 1, 1, 2, DT_UINT8)
+	assert.NoError(img.SetPerVolumeScaling([]float64{2, 3}, []float64{0, 1}))
+	img.AddExtension(NIFTI_ECODE_COMMENT, []byte("John Doe"))
+	img.HeaderGap = []byte("John Doe")
+	for _, ecodes := range [][]int32{nil, {NIFTI_ECODE_COMMENT}} {
+		img.Anonymize(ecodes...)
+		assert.Nil(img.HeaderGap)
+		assert.Len(img.Nifti1Ext, 1)
+		assert.True(isPerVolumeScalingExtension(img.Nifti1Ext[0]))
+		assert.Equal([]float64{2, 3}, img.VolumeSlopes)
+	}
+}
+
+func TestNii_GetVoxelsInRegion(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(6, 5, 4, 2, DT_INT16)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+
+	values, err := img.GetVoxelsInRegion(1, 2, 1, 4, 5, 3, 1)
+	assert.NoError(err)
+	assert.Len(values, 3*3*2)
+
+	// Each voxel holds its index t*120 + z*30 + y*6 + x
+	expected := []float64{
+		163, 164, 165, 169, 170, 171, 175, 176, 177,
+		193, 194, 195, 199, 200, 201, 205, 206, 207,
+	}
+	assert.Equal(expected, values)
+
+	_, err = img.GetVoxelsInRegion(0, 0, 0, 7, 5, 4, 0)
+	assert.Error(err)
+	_, err = img.GetVoxelsInRegion(0, 0, 0, 6, 5, 4, 2)
+	assert.Error(err)
+}
+
+func TestDatatype_String(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("INT16", Datatype(DT_INT16).String())
+	assert.Equal("FLOAT32", Datatype(DT_FLOAT32).String())
+	assert.Equal(ILLEGAL, Datatype(3).String())
+	assert.False(Datatype(3).IsValid())
+
+	img := &Nii{}
+	assert.NoError(img.SetTypedDatatype(Datatype(DT_UINT16)))
+	assert.Equal(DT_UINT16, img.Datatype)
+	assert.Equal(Datatype(DT_UINT16), img.GetTypedDatatype())
+	assert.Error(img.SetTypedDatatype(Datatype(3)))
+}
+
+func TestNii_RemapLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(4, 4, 2, 1, DT_UINT8)
+	img.Volume[0], img.Volume[1], img.Volume[2] = 1, 1, 2
+	img.Volume[31] = 3
+
+	err := img.RemapLabels(map[float64]float64{1: 10, 2: 20})
+	assert.NoError(err)
+	assert.Equal(map[float64]int{0: 28, 10: 2, 20: 1, 3: 1}, img.GetVoxels().MapValueOccurrence())
+	assert.Equal(byte(10), img.Volume[0])
+	assert.Equal(byte(3), img.Volume[31])
+}
+
+func TestNii_LabelVolumes(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(4, 4, 2, 1, DT_UINT8)
+	img.Dx, img.Dy, img.Dz = 0.5, 2, 3
+	img.XYZUnits = int32(NIFTI_UNITS_MM)
+	img.Volume[0], img.Volume[1], img.Volume[2] = 1, 1, 1
+	img.Volume[20] = 4
+
+	assert.Equal(map[float64]float64{1: 9, 4: 3}, img.LabelVolumes())
+
+	// Same grid spacing expressed in meters
+	img.Dx, img.Dy, img.Dz = 0.0005, 0.002, 0.003
+	img.XYZUnits = int32(NIFTI_UNITS_METER)
+	volumes := img.LabelVolumes()
+	assert.InDelta(9.0, volumes[1], 1e-9)
+	assert.InDelta(3.0, volumes[4], 1e-9)
+}
+
+func TestNii_SetStatIntent(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{}
+
+	// t-test with 20 degrees of freedom
+	assert.NoError(img.SetStatIntent(int32(NIFTI_INTENT_TTEST), 20))
+	assert.Equal(int32(NIFTI_INTENT_TTEST), img.IntentCode)
+	assert.Equal(20.0, img.IntentP1)
+	assert.Error(img.SetStatIntent(int32(NIFTI_INTENT_TTEST)))
+	assert.Error(img.SetStatIntent(int32(NIFTI_INTENT_TTEST), 20, 3))
+
+	// F-test with 3 and 40 degrees of freedom
+	assert.NoError(img.SetStatIntent(int32(NIFTI_INTENT_FTEST), 3, 40))
+	assert.Equal([3]float64{3, 40, 0}, [3]float64{img.IntentP1, img.IntentP2, img.IntentP3})
+	assert.Error(img.SetStatIntent(int32(NIFTI_INTENT_FTEST), 3))
+
+	// chi-squared with 5 degrees of freedom clears the unused parameters
+	assert.NoError(img.SetStatIntent(int32(NIFTI_INTENT_CHISQ), 5))
+	assert.Equal([3]float64{5, 0, 0}, [3]float64{img.IntentP1, img.IntentP2, img.IntentP3})
+
+	// Not a statistical intent
+	assert.Error(img.SetStatIntent(int32(NIFTI_INTENT_LABEL)))
+}
+
+func TestNii_GetAtChecked(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(3, 2, 2, 2, DT_INT16)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+
+	value, err := img.GetAtChecked(2, 1, 1, 1)
+	assert.NoError(err)
+	assert.Equal(23.0, value)
+	assert.Equal(23.0, img.GetAt(2, 1, 1, 1))
+
+	for _, coords := range [][4]int64{
+		{3, 0, 0, 0},
+		{0, 2, 0, 0},
+		{0, 0, 2, 0},
+		{0, 0, 0, 2},
+		{-1, 0, 0, 0},
+		{0, 0, 0, -1},
+	} {
+		_, err = img.GetAtChecked(coords[0], coords[1], coords[2], coords[3])
+		assert.Error(err, "coordinates %v", coords)
+		assert.NotPanics(func() { img.GetAt(coords[0], coords[1], coords[2], coords[3]) })
+		assert.Equal(0.0, img.GetAt(coords[0], coords[1], coords[2], coords[3]))
+	}
+}
+
+func TestNii_SetAt2D(t *testing.T) {
+	assert := assert.New(t)
+
+	// Hand-built 2-D image without Nz and Nt
+	img := &Nii{
+		NDim:     2,
+		Nx:       4,
+		Ny:       3,
+		Dim:      [8]int64{2, 4, 3},
+		NVox:     4 * 3,
+		NByPer:   1,
+		Datatype: DT_UINT8,
+	}
+	img.ByteOrder = binary.LittleEndian
+	img.Volume = make([]byte, img.NVox)
+
+	err := img.SetAt(9, 3, 2, 0, 0)
+	assert.NoError(err)
+	assert.Equal(byte(9), img.Volume[11])
+	assert.Equal(9.0, img.GetAt(3, 2, 0, 0))
+
+	err = img.SetAt(9, 0, 0, 1, 0)
+	assert.Error(err)
+}
+
+func TestNii_MarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{
+		Nx:        2,
+		Ny:        2,
+		Datatype:  DT_UINT8,
+		Volume:    []byte{1, 2, 3, 4},
+		Nifti1Ext: []Nifti1Ext{{ECode: 4, EData: []byte("secret"), ESize: 16}},
+		NumExt:    1,
+	}
+	assert.NoError(img.SetDescrip("test image"))
+
+	bData, err := json.Marshal(img)
+	assert.NoError(err)
+	assert.NotContains(string(bData), `"volume"`)
+	assert.NotContains(string(bData), `"e_data"`)
+
+	var decoded map[string]interface{}
+	err = json.Unmarshal(bData, &decoded)
+	assert.NoError(err)
+	assert.Equal(2.0, decoded["Nx"])
+	assert.Equal(float64(DT_UINT8), decoded["datatype"])
+	assert.Equal([]interface{}{map[string]interface{}{"e_code": 4.0, "e_size": 16.0}}, decoded["nifti1_ext"])
+
+	// The image itself is left untouched
+	assert.Equal([]byte{1, 2, 3, 4}, img.Volume)
+	assert.Equal([]byte("secret"), img.Nifti1Ext[0].EData)
+}
+
+func TestNii_DisplayValue(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(4, 1, 1, 1, DT_UINT8)
+	img.SclSlope, img.SclInter = 2, -10
+	img.Volume = []byte{0, 10, 20, 100}
+
+	// Without calibration range only the scaling is applied
+	assert.Equal(-10.0, img.DisplayValue(0, 0, 0, 0))
+	assert.Equal(190.0, img.DisplayValue(3, 0, 0, 0))
+
+	img.CalMin, img.CalMax = 0, 50
+	assert.Equal(0.0, img.DisplayValue(0, 0, 0, 0))
+	assert.Equal(10.0, img.DisplayValue(1, 0, 0, 0))
+	assert.Equal(30.0, img.DisplayValue(2, 0, 0, 0))
+	assert.Equal(50.0, img.DisplayValue(3, 0, 0, 0))
+	assert.Equal(190.0, img.GetAt(3, 0, 0, 0))
+}
+
+func TestNii_VoxelChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+
+	var count int64
+	for voxel := range img.VoxelChannel(context.Background()) {
+		assert.Equal(img.GetAt(voxel.X, voxel.Y, voxel.Z, voxel.T), voxel.V)
+		count++
+	}
+	assert.Equal(img.NVox, count)
+
+	// The channel is closed after the cancellation
+	img = newCubeTestImage(16)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := img.VoxelChannel(ctx)
+	<-ch
+	cancel()
+	count = 0
+	for range ch {
+		count++
+	}
+	assert.Less(count, img.NVox)
+}
+
+func TestNii_VolumeHash(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newCubeTestImage(4)
+	other := newCubeTestImage(4)
+	assert.NoError(other.SetDescrip("other description"))
+
+	assert.Equal(img.VolumeHash(), other.VolumeHash())
+	imgHash, err := img.MetadataHash()
+	assert.NoError(err)
+	otherHash, err := other.MetadataHash()
+	assert.NoError(err)
+	assert.NotEqual(imgHash, otherHash)
+
+	other.Volume[0]++
+	assert.NotEqual(img.VolumeHash(), other.VolumeHash())
+	sameHash, err := other.MetadataHash()
+	assert.NoError(err)
+	assert.Equal(otherHash, sameHash)
+}
+
+func TestNii_SetTypedVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(3, 2, 2, 1, DT_INT16)
+
+	for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		img.ByteOrder = byteOrder
+		data := make([]int16, img.NVox)
+		for i := range data {
+			data[i] = int16(i*100 - 500)
+		}
+		err := img.SetInt16Volume(data)
+		assert.NoError(err)
+		assert.Equal(-500.0, img.GetAt(0, 0, 0, 0))
+		assert.Equal(600.0, img.GetAt(2, 1, 1, 0))
+
+		// Same bytes as the Voxels path for the non-negative values
+		data[0], data[1], data[2], data[3], data[4] = 0, 1, 2, 3, 4
+		err = img.SetInt16Volume(data)
+		assert.NoError(err)
+		vox := img.GetVoxels()
+		expected := append([]byte(nil), img.Volume...)
+		err = img.SetVoxelToRawVolume(vox)
+		assert.NoError(err)
+		assert.Equal(expected, img.Volume)
+	}
+
+	err := img.SetInt16Volume(make([]int16, 5))
+	assert.Error(err)
+	err = img.SetFloat32Volume(make([]float32, img.NVox))
+	assert.Error(err)
+
+	img.Datatype, img.NByPer = DT_FLOAT32, 4
+	data := make([]float32, img.NVox)
+	for i := range data {
+		data[i] = float32(i) + 0.5
+	}
+	err = img.SetFloat32Volume(data)
+	assert.NoError(err)
+	assert.Len(img.Volume, int(img.NVox*4))
+	assert.Equal(11.5, img.GetAt(2, 1, 1, 0))
+
+	err = img.SetFloat32Volume(make([]float32, img.NVox+1))
+	assert.Error(err)
+}
+
+func TestNii_SetVoxelToRawVolumeRounded(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(5, 1, 1, 1, DT_INT16)
+
+	vox := NewVoxels(5, 1, 1, 1, DT_INT16)
+	for x, value := range []float64{1.6, 1.4, 2.5, 3.5, 0.5} {
+		vox.Set(int64(x), 0, 0, 0, value)
+	}
+
+	cases := map[RoundingMode][]float64{
+		ROUND_TRUNCATE:  {1, 1, 2, 3, 0},
+		ROUND_NEAREST:   {2, 1, 3, 4, 1},
+		ROUND_HALF_EVEN: {2, 1, 2, 4, 0},
+		ROUND_FLOOR:     {1, 1, 2, 3, 0},
+		ROUND_CEIL:      {2, 2, 3, 4, 1},
+	}
+	for mode, expected := range cases {
+		err := img.SetVoxelToRawVolumeRounded(vox, mode)
+		assert.NoError(err)
+		for x := range expected {
+			assert.Equal(expected[x], img.GetAt(int64(x), 0, 0, 0), "mode %d, x %d", mode, x)
+		}
+	}
+
+	// The default path truncates
+	err := img.SetVoxelToRawVolume(vox)
+	assert.NoError(err)
+	assert.Equal(1.0, img.GetAt(0, 0, 0, 0))
+
+	// Float datatypes are not rounded
+	img.Datatype, img.NByPer = DT_FLOAT32, 4
+	vox = NewVoxels(5, 1, 1, 1, DT_FLOAT32)
+	vox.Set(0, 0, 0, 0, 1.5)
+	err = img.SetVoxelToRawVolumeRounded(vox, ROUND_NEAREST)
+	assert.NoError(err)
+	assert.Equal(1.5, img.GetAt(0, 0, 0, 0))
+}
+
+func TestNii_GetVolumeFlat(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(3, 2, 2, 2, DT_INT16)
+	img.SclSlope, img.SclInter = 2, 1
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+
+	flat, err := img.GetVolumeFlat(1)
+	assert.NoError(err)
+	assert.Len(flat, 12)
+	nested, err := img.GetVolume(1)
+	assert.NoError(err)
+	for z := int64(0); z < 2; z++ {
+		for y := int64(0); y < 2; y++ {
+			for x := int64(0); x < 3; x++ {
+				assert.Equal(nested[x][y][z], flat[z*6+y*3+x])
+			}
+		}
+	}
+	assert.Equal(2*12.0+1, flat[0])
+
+	slice, err := img.GetSliceFlat(1, 1)
+	assert.NoError(err)
+	assert.Equal(flat[6:], slice)
+
+	_, err = img.GetVolumeFlat(2)
+	assert.Error(err)
+	_, err = img.GetVolumeFlat(-1)
+	assert.Error(err)
+	_, err = img.GetSliceFlat(2, 0)
+	assert.Error(err)
+	_, err = img.GetSliceFlat(0, 2)
+	assert.Error(err)
+}
+
+func TestNii_DumpLoadVolume(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(3, 2, 2, 1, DT_INT16)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i*7))
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(img.DumpVolume(&buf))
+	assert.Equal(img.Volume, buf.Bytes())
+
+	out := *img
+	out.Volume = nil
+	assert.NoError(out.LoadVolume(bytes.NewReader(buf.Bytes()), 24))
+	assert.Equal(img.Volume, out.Volume)
+	assert.Equal(7.0*11, out.GetAt(2, 1, 1, 0))
+
+	out.Volume = nil
+	assert.NoError(out.LoadVolume(bytes.NewReader(buf.Bytes()), 0))
+	assert.Equal(img.Volume, out.Volume)
+
+	out.Volume = nil
+	assert.Error(out.LoadVolume(bytes.NewReader(buf.Bytes()), 12))
+	assert.Error(out.LoadVolume(bytes.NewReader(buf.Bytes()[:20]), 24))
+	assert.Nil(out.Volume)
+
+	out.Volume = img.Volume[:10]
+	assert.Error(out.DumpVolume(&buf))
+}
+
+func TestNii_ToArray3D(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(3, 2, 4, 2, DT_INT16)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+
+	volume, err := img.ToArray3D(1)
+	assert.NoError(err)
+	assert.Len(volume, 4)
+	assert.Len(volume[0], 2)
+	assert.Len(volume[0][0], 3)
+	for z := int64(0); z < 4; z++ {
+		for y := int64(0); y < 2; y++ {
+			for x := int64(0); x < 3; x++ {
+				assert.Equal(img.GetAt(x, y, z, 1), volume[z][y][x])
+			}
+		}
+	}
+
+	_, err = img.ToArray3D(2)
+	assert.Error(err)
+}
+
+func TestNii_SetAtInt8(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(2, 2, 1, 1, DT_INT8)
+
+	assert.NoError(img.SetAt(-5, 1, 0, 0, 0))
+	assert.NoError(img.SetAt(-128, 0, 1, 0, 0))
+	assert.NoError(img.SetAt(127, 1, 1, 0, 0))
+	assert.Equal(byte(0xfb), img.Volume[1])
+	assert.Equal(-5.0, img.GetAt(1, 0, 0, 0))
+	assert.Equal(-128.0, img.GetAt(0, 1, 0, 0))
+	assert.Equal(127.0, img.GetAt(1, 1, 0, 0))
+
+	// UINT8 values are unsigned
+	img.Datatype = DT_UINT8
+	assert.Equal(251.0, img.GetAt(1, 0, 0, 0))
+}
+
+func TestNii_GetRGBAAt(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(2, 2, 1, 1, DT_RGBA32)
+	img.SclSlope, img.SclInter = 2, 1
+	img.ByteOrder = binary.BigEndian
+	img.Volume = []byte{
+		255, 0, 0, 255,
+		0, 128, 0, 64,
+		0, 0, 32, 0,
+		10, 20, 30, 40,
+	}
+
+	r, g, b, a := img.GetRGBAAt(1, 0, 0, 0)
+	assert.Equal([4]uint8{0, 128, 0, 64}, [4]uint8{r, g, b, a})
+	r, g, b, a = img.GetRGBAAt(1, 1, 0, 0)
+	assert.Equal([4]uint8{10, 20, 30, 40}, [4]uint8{r, g, b, a})
+	r, g, b, a = img.GetRGBAAt(2, 0, 0, 0)
+	assert.Equal([4]uint8{0, 0, 0, 0}, [4]uint8{r, g, b, a})
+
+	// RGBA32 voxels are packed as 0xRRGGBBAA and the scaling is not applied to them
+	assert.Equal(float64(0x00800040), img.GetAt(1, 0, 0, 0))
+	assert.Equal(float64(0x0a141e28), img.GetVoxels().Get(1, 1, 0, 0))
+	scaled := img.GetAt(1, 1, 0, 0)
+	img.SclSlope, img.SclInter = 0, 0
+	assert.Equal(scaled, img.GetAt(1, 1, 0, 0))
+
+	// Setting a packed value writes the components back
+	img.ByteOrder = binary.LittleEndian
+	assert.NoError(img.SetAt(float64(0x01020304), 0, 1, 0, 0))
+	r, g, b, a = img.GetRGBAAt(0, 1, 0, 0)
+	assert.Equal([4]uint8{1, 2, 3, 4}, [4]uint8{r, g, b, a})
+	assert.Equal(float64(0x01020304), img.GetAt(0, 1, 0, 0))
+
+	// RGB24 voxels are opaque
+	img.Datatype, img.NByPer = DT_RGB24, 3
+	r, g, b, a = img.GetRGBAAt(1, 0, 0, 0)
+	assert.Equal([4]uint8{255, 0, 128, 255}, [4]uint8{r, g, b, a})
+}
+
+func TestNii_SwapVolumeByteOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, datatype := range []int32{DT_INT16, DT_UINT16, DT_FLOAT32, DT_UINT8} {
+		img := newTestNii(3, 2, 1, 1, datatype)
+		img.ByteOrder = binary.BigEndian
+		for i := int64(0); i < img.NVox; i++ {
+			assert.NoError(img.SetAt(float64(i*7+1), i%3, i/3, 0, 0))
+		}
+		before := img.GetVoxels()
+
+		assert.NoError(img.SwapVolumeByteOrder())
+		assert.Equal(binary.LittleEndian, img.ByteOrder)
+		assert.Equal(before, img.GetVoxels(), img.GetDatatype())
+
+		assert.NoError(img.SwapVolumeByteOrder())
+		assert.Equal(binary.BigEndian, img.ByteOrder)
+		assert.Equal(before, img.GetVoxels(), img.GetDatatype())
+	}
+}
+
+func TestNii_CenterOfMassWorld(t *testing.T) {
+	assert := assert.New(t)
+
+	// The label fills the 2x2x2 cube at the center of the 4x4x4 grid
+	img := newCubeTestImage(4)
+	img.Volume = make([]byte, len(img.Volume))
+	for z := int64(1); z <= 2; z++ {
+		for y := int64(1); y <= 2; y++ {
+			for x := int64(1); x <= 2; x++ {
+				assert.NoError(img.SetAt(1, x, y, z, 0))
+			}
+		}
+	}
+
+	world, ok := img.CenterOfMassWorld(1)
+	assert.True(ok)
+	assert.Equal([3]float64{1.5 - 10, 1.5 - 20, 2*1.5 - 30}, world)
+	_, ok = img.CenterOfMassWorld(2)
+	assert.False(ok)
+}
@@ -0,0 +1,330 @@
+package nifti
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"math"
+	"strings"
+)
+
+// orientationAxisCodes maps the single-letter axis codes to the orientation codes. The letter is the direction the
+// voxel axis points to, e.g. "R" means the axis goes from Left to Right
+var orientationAxisCodes = map[string]int32{
+	"R": NIFTI_L2R,
+	"L": NIFTI_R2L,
+	"A": NIFTI_P2A,
+	"P": NIFTI_A2P,
+	"S": NIFTI_I2S,
+	"I": NIFTI_S2I,
+}
+
+// parseOrientation converts the target orientation to orientation codes. Each axis can be given either as a
+// single-letter code ("R", "L", "A", "P", "S", "I") or as the string returned by GetOrientation
+func parseOrientation(target [3]string) ([3]int32, error) {
+	var codes [3]int32
+	var seen [3]bool
+
+	for i, axis := range target {
+		code, ok := orientationAxisCodes[strings.ToUpper(strings.TrimSpace(axis))]
+		if !ok {
+			for orientCode, orientName := range OrietationToString {
+				if orientCode != NIFTI_UNKNOWN_ORIENT && orientName == axis {
+					code, ok = int32(orientCode), true
+					break
+				}
+			}
+		}
+		if !ok {
+			return codes, fmt.Errorf("invalid orientation %q", axis)
+		}
+
+		worldAxis := (code - 1) / 2
+		if seen[worldAxis] {
+			return codes, fmt.Errorf("orientation %v uses the same axis twice", target)
+		}
+		seen[worldAxis] = true
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// orientationVector returns the world axis (0: x, 1: y, 2: z) and the direction (+1 or -1) of an orientation code
+func orientationVector(code int32) (int, float64) {
+	if code%2 == 1 {
+		return int((code - 1) / 2), 1
+	}
+	return int((code - 1) / 2), -1
+}
+
+// getBestAffine returns the voxel to world transformation, using the sform if set, then the qform, then the default
+// affine
+func (n *Nii) getBestAffine() matrix.DMat44 {
+	if n.SformCode > 0 {
+		return n.StoXYZ
+	}
+	if n.QformCode > 0 {
+		return n.QtoXYZ
+	}
+	return n.DefaultAffine()
+}
+
+// setBestAffine updates the sform and the qform, whichever are set, and the affine from the new voxel to world
+// transformation. If neither is set, the sform is set with the scanner anatomical code
+func (n *Nii) setBestAffine(R matrix.DMat44) {
+	if n.SformCode <= 0 && n.QformCode <= 0 {
+		n.SformCode = NIFTI_XFORM_SCANNER_ANAT
+	}
+	if n.SformCode > 0 {
+		n.StoXYZ = R
+		n.StoIJK = matrix.Mat44Inverse(R)
+	}
+	if n.QformCode > 0 {
+		n.MatrixToQuatern(R)
+		n.PixDim[1], n.PixDim[2], n.PixDim[3] = n.Dx, n.Dy, n.Dz
+		n.QtoXYZ = n.QuaternToMatrix()
+		n.QtoIJK = matrix.Mat44Inverse(n.QtoXYZ)
+	}
+	n.Affine = R
+	n.MatrixToOrientation(R)
+}
+
+// shiftVoxelOrigin moves the origin of the voxel grid to the voxel (i, j, k), e.g. for a sub-image starting at that
+// voxel: the translations of the qform, the sform and the affine are shifted along their columns so that the new voxel
+// (0, 0, 0) keeps the world coordinates of the old voxel (i, j, k)
+func (n *Nii) shiftVoxelOrigin(i, j, k float64) {
+	shift := func(R *matrix.DMat44) {
+		for row := 0; row < 3; row++ {
+			R.M[row][3] += i*R.M[row][0] + j*R.M[row][1] + k*R.M[row][2]
+		}
+	}
+
+	shift(&n.QtoXYZ)
+	n.QtoIJK = matrix.Mat44Inverse(n.QtoXYZ)
+	n.QoffsetX, n.QoffsetY, n.QoffsetZ = n.QtoXYZ.M[0][3], n.QtoXYZ.M[1][3], n.QtoXYZ.M[2][3]
+	shift(&n.StoXYZ)
+	n.StoIJK = matrix.Mat44Inverse(n.StoXYZ)
+	shift(&n.Affine)
+}
+
+// directionTolerance is the maximal difference between the direction cosines of two images in the same orientation
+const directionTolerance = 1e-4
+
+// SameOrientation returns whether the image and the other one have the same direction cosines, i.e. the normalized
+// columns of their best affines are equal within tolerance. The voxel sizes and the translations are ignored, so
+// that images of different resolutions in the same space compare equal
+func (n *Nii) SameOrientation(other *Nii) bool {
+	if other == nil {
+		return false
+	}
+	R1, R2 := n.getBestAffine(), other.getBestAffine()
+	for col := 0; col < 3; col++ {
+		d1, d2 := directionCosines(R1, col), directionCosines(R2, col)
+		for row := 0; row < 3; row++ {
+			if math.Abs(d1[row]-d2[row]) > directionTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FormsConsistent returns whether the qform (QtoXYZ) and the sform (StoXYZ) describe the same voxel to world mapping,
+// i.e. all their rotation, scaling and translation elements differ by at most tol, along with the maximal element
+// difference. A discrepancy usually indicates a corrupted header. If either form is unset (code 0), there is nothing
+// to compare and the forms are considered consistent
+func (n *Nii) FormsConsistent(tol float64) (bool, float64) {
+	if n.QformCode <= 0 || n.SformCode <= 0 {
+		return true, 0
+	}
+
+	var maxDiff float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			maxDiff = math.Max(maxDiff, math.Abs(n.QtoXYZ.M[i][j]-n.StoXYZ.M[i][j]))
+		}
+	}
+	return maxDiff <= tol, maxDiff
+}
+
+// directionCosines returns the normalized column of the affine, or a zero vector for a zero column
+func directionCosines(R matrix.DMat44, col int) [3]float64 {
+	norm := math.Sqrt(R.M[0][col]*R.M[0][col] + R.M[1][col]*R.M[1][col] + R.M[2][col]*R.M[2][col])
+	if norm == 0 {
+		return [3]float64{}
+	}
+	return [3]float64{R.M[0][col] / norm, R.M[1][col] / norm, R.M[2][col] / norm}
+}
+
+// SetIdentityAffine resets the geometry to a neutral RAS orientation, e.g. for synthetic data: the sform is set to the
+// diagonal affine with the spacing (absolute values, a zero spacing stands for 1) and a zero origin, with the scanner
+// anatomical code, and the qform is cleared. The pixdims, the derived matrices and the orientation are updated
+func (n *Nii) SetIdentityAffine(spacing [3]float64) {
+	var R matrix.DMat44
+	for i := 0; i < 3; i++ {
+		spacing[i] = math.Abs(spacing[i])
+		if spacing[i] == 0 {
+			spacing[i] = 1
+		}
+		R.M[i][i] = spacing[i]
+	}
+	R.M[3][3] = 1
+
+	n.QformCode = NIFTI_XFORM_UNKNOWN
+	n.QuaternB, n.QuaternC, n.QuaternD = 0, 0, 0
+	n.QoffsetX, n.QoffsetY, n.QoffsetZ = 0, 0, 0
+	n.QFac, n.PixDim[0] = 1, 1
+	n.QtoXYZ = R
+	n.QtoIJK = matrix.Mat44Inverse(R)
+
+	n.SformCode = NIFTI_XFORM_SCANNER_ANAT
+	n.StoXYZ = R
+	n.StoIJK = matrix.Mat44Inverse(R)
+
+	n.Dx, n.Dy, n.Dz = spacing[0], spacing[1], spacing[2]
+	n.PixDim[1], n.PixDim[2], n.PixDim[3] = spacing[0], spacing[1], spacing[2]
+	n.Affine = R
+	n.MatrixToOrientation(R)
+}
+
+// getOrientationCodes returns the orientation codes of the voxel axes from the best affine
+func (n *Nii) getOrientationCodes() ([3]int32, error) {
+	tmp := &Nii{}
+	tmp.MatrixToOrientation(n.getBestAffine())
+	for _, code := range tmp.IJKOrient {
+		if code == NIFTI_UNKNOWN_ORIENT {
+			return tmp.IJKOrient, errors.New("unable to determine the current orientation")
+		}
+	}
+	return tmp.IJKOrient, nil
+}
+
+// RelabelOrientation changes the orientation metadata to the target without moving any voxel data. The qform and sform
+// are recomputed so that the voxel axes point to the target directions, i.e. the world coordinates are relabeled (for
+// example to correct a mislabeled left/right). Use ReorientData to move the voxels while keeping the anatomy in place
+func (n *Nii) RelabelOrientation(target [3]string) error {
+	targetCodes, err := parseOrientation(target)
+	if err != nil {
+		return err
+	}
+	currentCodes, err := n.getOrientationCodes()
+	if err != nil {
+		return err
+	}
+
+	// World transformation P mapping the current direction of each voxel axis to its target direction
+	var P matrix.DMat44
+	P.M[3][3] = 1
+	for i := 0; i < 3; i++ {
+		currentAxis, currentSign := orientationVector(currentCodes[i])
+		targetAxis, targetSign := orientationVector(targetCodes[i])
+		P.M[targetAxis][currentAxis] = targetSign * currentSign
+	}
+
+	n.setBestAffine(matrix.Mat44Multiply(P, n.getBestAffine()))
+	return nil
+}
+
+// ReorientData permutes and flips the voxel data so that the voxel axes follow the target orientation. Unlike
+// RelabelOrientation, the anatomy stays in place: the affine is updated so that every voxel keeps its world coordinate
+func (n *Nii) ReorientData(target [3]string) error {
+	targetCodes, err := parseOrientation(target)
+	if err != nil {
+		return err
+	}
+	currentCodes, err := n.getOrientationCodes()
+	if err != nil {
+		return err
+	}
+
+	oldDims := [3]int64{n.Nx, n.Ny, n.Nz}
+	oldPixDim := n.PixDim
+
+	// For each new voxel axis, find the old voxel axis along the same world axis and whether it must be flipped
+	var srcAxes [3]int
+	var flips [3]bool
+	for i := 0; i < 3; i++ {
+		targetAxis, targetSign := orientationVector(targetCodes[i])
+		for j := 0; j < 3; j++ {
+			currentAxis, currentSign := orientationVector(currentCodes[j])
+			if currentAxis == targetAxis {
+				srcAxes[i] = j
+				flips[i] = currentSign != targetSign
+			}
+		}
+	}
+
+	var newDims [3]int64
+	for i := 0; i < 3; i++ {
+		newDims[i] = oldDims[srcAxes[i]]
+	}
+
+	// Move the voxels, the volumes beyond the third dimension are reoriented independently
+	nByPer := int64(n.NByPer)
+	volumeSize := oldDims[0] * oldDims[1] * oldDims[2]
+	if volumeSize == 0 || nByPer == 0 {
+		return errors.New("image has no voxel data")
+	}
+	nVolumes := int64(len(n.Volume)) / (volumeSize * nByPer)
+	newVolume := make([]byte, len(n.Volume))
+	oldStrides := [3]int64{1, oldDims[0], oldDims[0] * oldDims[1]}
+
+	for v := int64(0); v < nVolumes; v++ {
+		base := v * volumeSize
+		newIndex := base
+		var newIdx [3]int64
+		for newIdx[2] = 0; newIdx[2] < newDims[2]; newIdx[2]++ {
+			for newIdx[1] = 0; newIdx[1] < newDims[1]; newIdx[1]++ {
+				for newIdx[0] = 0; newIdx[0] < newDims[0]; newIdx[0]++ {
+					oldIndex := base
+					for i := 0; i < 3; i++ {
+						idx := newIdx[i]
+						if flips[i] {
+							idx = newDims[i] - 1 - idx
+						}
+						oldIndex += idx * oldStrides[srcAxes[i]]
+					}
+					copy(newVolume[newIndex*nByPer:(newIndex+1)*nByPer], n.Volume[oldIndex*nByPer:(oldIndex+1)*nByPer])
+					newIndex++
+				}
+			}
+		}
+	}
+
+	// Transformation T from the new voxel indexes to the old voxel indexes
+	var T matrix.DMat44
+	T.M[3][3] = 1
+	for i := 0; i < 3; i++ {
+		if flips[i] {
+			T.M[srcAxes[i]][i] = -1
+			T.M[srcAxes[i]][3] = float64(newDims[i] - 1)
+		} else {
+			T.M[srcAxes[i]][i] = 1
+		}
+	}
+	affine := matrix.Mat44Multiply(n.getBestAffine(), T)
+
+	// Update the dimensions, the grid spacings and the dimension info
+	n.Volume = newVolume
+	n.Nx, n.Ny, n.Nz = newDims[0], newDims[1], newDims[2]
+	n.Dim[1], n.Dim[2], n.Dim[3] = newDims[0], newDims[1], newDims[2]
+	for i := 0; i < 3; i++ {
+		n.PixDim[i+1] = oldPixDim[srcAxes[i]+1]
+	}
+	n.Dx, n.Dy, n.Dz = n.PixDim[1], n.PixDim[2], n.PixDim[3]
+
+	newDimIndex := func(oldDim int32) int32 {
+		for i := 0; i < 3; i++ {
+			if int32(srcAxes[i]+1) == oldDim {
+				return int32(i + 1)
+			}
+		}
+		return oldDim
+	}
+	n.FreqDim = newDimIndex(n.FreqDim)
+	n.PhaseDim = newDimIndex(n.PhaseDim)
+	n.SliceDim = newDimIndex(n.SliceDim)
+
+	n.setBestAffine(affine)
+	return nil
+}
@@ -0,0 +1,129 @@
+package nifti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/okieraised/gonii/pkg/matrix"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newOrientationTestImage returns a 4x3x2 INT16 RAS image where each voxel value is its index
+func newOrientationTestImage() *Nii {
+	img := newTestNii(4, 3, 2, 1, DT_INT16)
+	img.Dx, img.Dy, img.Dz = 1, 2, 3
+	img.PixDim = [8]float64{1, 1, 2, 3, 1, 1, 1, 1}
+	img.SformCode = NIFTI_XFORM_SCANNER_ANAT
+	img.StoXYZ = matrix.DMat44{M: [4][4]float64{
+		{1, 0, 0, -10},
+		{0, 2, 0, -20},
+		{0, 0, 3, -30},
+		{0, 0, 0, 1},
+	}}
+	img.Volume = make([]byte, img.NVox*2)
+	for i := int64(0); i < img.NVox; i++ {
+		binary.LittleEndian.PutUint16(img.Volume[2*i:], uint16(i))
+	}
+	img.MatrixToOrientation(img.StoXYZ)
+	return img
+}
+
+// worldCoordinate applies the affine to the voxel indexes
+func worldCoordinate(R matrix.DMat44, i, j, k int64) [3]float64 {
+	var res [3]float64
+	for row := 0; row < 3; row++ {
+		res[row] = R.M[row][0]*float64(i) + R.M[row][1]*float64(j) + R.M[row][2]*float64(k) + R.M[row][3]
+	}
+	return res
+}
+
+func TestNii_RelabelOrientation(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newOrientationTestImage()
+	assert.Equal([3]string{OrietationToString[NIFTI_L2R], OrietationToString[NIFTI_P2A], OrietationToString[NIFTI_I2S]}, img.GetOrientation())
+	volume := bytes.Clone(img.Volume)
+
+	// Correct a mislabeled left/right
+	err := img.RelabelOrientation([3]string{"L", "A", "S"})
+	assert.NoError(err)
+	assert.Equal([3]string{OrietationToString[NIFTI_R2L], OrietationToString[NIFTI_P2A], OrietationToString[NIFTI_I2S]}, img.GetOrientation())
+	assert.Equal(volume, img.Volume)
+	assert.Equal(-1.0, img.StoXYZ.M[0][0])
+	assert.Equal(10.0, img.StoXYZ.M[0][3])
+
+	err = img.RelabelOrientation([3]string{"L", "R", "S"})
+	assert.Error(err)
+	err = img.RelabelOrientation([3]string{"X", "A", "S"})
+	assert.Error(err)
+}
+
+func TestNii_ReorientData(t *testing.T) {
+	assert := assert.New(t)
+
+	original := newOrientationTestImage()
+	img := newOrientationTestImage()
+
+	err := img.ReorientData([3]string{"A", "L", "S"})
+	assert.NoError(err)
+	assert.Equal([3]string{OrietationToString[NIFTI_P2A], OrietationToString[NIFTI_R2L], OrietationToString[NIFTI_I2S]}, img.GetOrientation())
+	assert.Equal([4]int64{3, 4, 2, 1}, img.GetImgShape())
+	assert.Equal([4]float64{2, 1, 3, 1}, img.GetVoxelSize())
+	assert.NotEqual(original.Volume, img.Volume)
+
+	// Every voxel keeps its value and its world coordinate
+	for k := int64(0); k < 2; k++ {
+		for j := int64(0); j < 4; j++ {
+			for i := int64(0); i < 3; i++ {
+				oldI, oldJ := 3-j, i
+				assert.Equal(original.GetAt(oldI, oldJ, k, 0), img.GetAt(i, j, k, 0))
+				assert.Equal(worldCoordinate(original.StoXYZ, oldI, oldJ, k), worldCoordinate(img.StoXYZ, i, j, k))
+			}
+		}
+	}
+}
+
+func TestNii_SameOrientation(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newOrientationTestImage()
+	assert.True(img.SameOrientation(img))
+
+	// Different voxel sizes and translation, same directions
+	other := newOrientationTestImage()
+	other.StoXYZ = matrix.DMat44{M: [4][4]float64{
+		{0.5, 0, 0, 7},
+		{0, 0.5, 0, 8},
+		{0, 0, 4, 9},
+		{0, 0, 0, 1},
+	}}
+	assert.True(img.SameOrientation(other))
+	assert.True(other.SameOrientation(img))
+
+	// Flipped left/right
+	assert.NoError(other.FlipAxis(0))
+	assert.False(img.SameOrientation(other))
+	assert.False(img.SameOrientation(nil))
+}
+
+func TestNii_SetIdentityAffine(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newOrientationTestImage()
+	img.QformCode = NIFTI_XFORM_SCANNER_ANAT
+	img.MatrixToQuatern(matrix.DMat44{M: [4][4]float64{
+		{0, -1, 0, 5},
+		{1, 0, 0, 6},
+		{0, 0, -1, 7},
+		{0, 0, 0, 1},
+	}})
+
+	img.SetIdentityAffine([3]float64{0.5, 0.5, 3})
+	assert.Equal([3]string{OrietationToString[NIFTI_L2R], OrietationToString[NIFTI_P2A], OrietationToString[NIFTI_I2S]}, img.GetOrientation())
+	assert.Equal(int32(NIFTI_XFORM_UNKNOWN), img.QformCode)
+	assert.Equal(int32(NIFTI_XFORM_SCANNER_ANAT), img.SformCode)
+	assert.Equal([3]float64{0.5, 0.5, 3}, [3]float64{img.PixDim[1], img.PixDim[2], img.PixDim[3]})
+	assert.Equal([3]float64{1, 1, 6}, worldCoordinate(img.StoXYZ, 2, 2, 2))
+	assert.Equal([3]float64{2, 2, 2}, worldCoordinate(img.StoIJK, 1, 1, 6))
+	assert.Equal(img.StoXYZ, img.Affine)
+}
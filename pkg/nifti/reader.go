@@ -5,8 +5,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	gzip "github.com/klauspost/pgzip"
 	"github.com/okieraised/gonii/pkg/matrix"
+	"hash/crc32"
 	"io"
+	"strings"
 )
 
 type Reader interface {
@@ -20,6 +23,33 @@ type Reader interface {
 	GetHeader(prettyShow bool) interface{}
 }
 
+// PartialReader is implemented by the readers that can read a part of the image without parsing the whole volume.
+// NiiReader implements it
+type PartialReader interface {
+	// ReadVolumeAt reads only the 3-D volume at timepoint t
+	ReadVolumeAt(t int64) (*Nii, error)
+	// ReadSliceAt reads only the x-y slice at (z, t)
+	ReadSliceAt(z, t int64) (*Nii, error)
+	// ReadSliceChecksum returns the CRC-32 checksum of the x-y slice at (z, t)
+	ReadSliceChecksum(z, t int64) (uint32, error)
+}
+
+// HeaderReader is implemented by the readers that expose the header as read. NiiReader implements it
+type HeaderReader interface {
+	// ParseHeader parses only the header and the extensions without reading the image data
+	ParseHeader() error
+	// RawHeaderBytes returns the raw header bytes exactly as read
+	RawHeaderBytes() []byte
+	// Warnings returns the header inconsistencies fixed by the last parse
+	Warnings() []string
+}
+
+// CloneableReader is implemented by the readers that can be cloned for concurrent use. NiiReader implements it
+type CloneableReader interface {
+	// Clone returns an independent reader sharing the underlying file buffer
+	Clone() Reader
+}
+
 // NiiReader define the NIfTI reader structure.
 type NiiReader struct {
 	reader       *bytes.Reader
@@ -30,6 +60,13 @@ type NiiReader struct {
 	data         *Nii             // Contains the NIFTI data structure
 	header       interface{}      // Contains the NIFTI header
 	version      int              // Define the version of NIFTI image (1 or 2)
+	forceVersion int              // If non-zero, skip the version detection and parse as this version
+	robustRange  []float64        // If set, the low and high percentiles to clip the voxel intensities to after parsing
+	lenientMagic bool             // Whether to accept an unknown magic string as long as the dimensions are sane
+	headerOnly   bool             // Whether Parse reads only the header and the extensions
+	noAutoFix    bool             // Whether to reject the header inconsistencies instead of fixing them
+	warnings     []string         // Header inconsistencies fixed by the last parse
+	partialData  *Nii             // Image structure parsed from the header for the partial reads, without the image data
 }
 
 func (r *NiiReader) SetBinaryOrder(bo binary.ByteOrder) {
@@ -38,10 +75,37 @@ func (r *NiiReader) SetBinaryOrder(bo binary.ByteOrder) {
 
 func (r *NiiReader) SetHdrReader(hdrRd *bytes.Reader) {
 	r.hReader = hdrRd
+	r.partialData = nil
+}
+
+// GetHdrReader returns the separate header reader in case of NIfTI pair .hdr/.img. Returns nil for a single file
+func (r *NiiReader) GetHdrReader() *bytes.Reader {
+	return r.hReader
+}
+
+func (r *NiiReader) SetForceVersion(version int) {
+	r.forceVersion = version
+}
+
+func (r *NiiReader) SetRobustRange(lowPct, highPct float64) {
+	r.robustRange = []float64{lowPct, highPct}
+}
+
+func (r *NiiReader) SetStrictMagic(strictMagic bool) {
+	r.lenientMagic = !strictMagic
+}
+
+func (r *NiiReader) SetHeaderOnly(headerOnly bool) {
+	r.headerOnly = headerOnly
+}
+
+func (r *NiiReader) SetNoAutoFix(noAutoFix bool) {
+	r.noAutoFix = noAutoFix
 }
 
 func (r *NiiReader) SetReader(rd *bytes.Reader) {
 	r.reader = rd
+	r.partialData = nil
 }
 
 func (r *NiiReader) SetDataset(ds *Nii) {
@@ -86,6 +150,12 @@ func (r *NiiReader) GetNiiData() *Nii {
 	return r.data
 }
 
+// Warnings returns the header inconsistencies fixed by the last parse, e.g. a bitpix that disagrees with the datatype.
+// These are reported as errors instead with SetNoAutoFix
+func (r *NiiReader) Warnings() []string {
+	return r.warnings
+}
+
 // GetBinaryOrder returns the NIfTI file binary order
 func (r *NiiReader) GetBinaryOrder() binary.ByteOrder {
 	return r.binaryOrder
@@ -93,20 +163,233 @@ func (r *NiiReader) GetBinaryOrder() binary.ByteOrder {
 
 // Parse returns the raw byte array into NIfTI-1/2 header and dataset structure
 func (r *NiiReader) Parse() error {
+	if r.headerOnly {
+		return r.parseHeader()
+	}
+
 	err := r.getVersion()
 	if err != nil {
 		return err
 	}
 
-	err = r.parseNIfTI()
+	err = r.parseNIfTI(true)
 	if err != nil {
 		return err
 	}
+
+	if r.robustRange != nil {
+		err = r.data.ClipRobustRange(r.robustRange[0], r.robustRange[1])
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// parseNIfTI parse the NIfTI header and the data
-func (r *NiiReader) parseNIfTI() error {
+// ParseHeader parses only the header and the extensions. The image data is not read so the reader may hold the header
+// bytes only
+func (r *NiiReader) ParseHeader() error {
+	return r.parseHeader()
+}
+
+// RawHeaderBytes returns the exact 348 (NIfTI-1) or 540 (NIfTI-2) header bytes as read from the file, without byte
+// swapping. Returns nil if the version has not been determined by parsing yet
+func (r *NiiReader) RawHeaderBytes() []byte {
+	hReader := r.reader
+	if r.hReader != nil {
+		hReader = r.hReader
+	}
+	if hReader == nil {
+		return nil
+	}
+
+	var size int
+	switch r.version {
+	case NIIVersion1:
+		size = NII1HeaderSize
+	case NIIVersion2:
+		size = NII2HeaderSize
+	default:
+		return nil
+	}
+
+	buf := make([]byte, size)
+	_, err := hReader.ReadAt(buf, 0)
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// Clone returns a reader sharing the underlying (immutable) file buffer but with its own read cursors and its own copy
+// of the parsed image structure. A NiiReader is not safe for concurrent use since parsing and partial reads seek the
+// shared cursor: give each goroutine its own clone instead, e.g. to call ReadSliceAt in parallel
+func (r *NiiReader) Clone() Reader {
+	return r.clone()
+}
+
+// clone returns a copy of the reader with its own read cursors and its own copy of the parsed image structure
+func (r *NiiReader) clone() *NiiReader {
+	clone := *r
+	if r.reader != nil {
+		// Copying the bytes.Reader value shares the byte slice but not the read offset
+		rd := *r.reader
+		clone.reader = &rd
+	}
+	if r.hReader != nil {
+		hRd := *r.hReader
+		clone.hReader = &hRd
+	}
+	if r.data != nil {
+		data := *r.data
+		data.Nifti1Ext = append([]Nifti1Ext(nil), r.data.Nifti1Ext...)
+		clone.data = &data
+	}
+	return &clone
+}
+
+// ReadVolumeAt reads only the volume at timepoint t into a 3-D NIfTI image structure, without decoding the rest of the
+// series. The header is parsed on the first partial read only and the parsed image structure of the reader is left
+// untouched
+func (r *NiiReader) ReadVolumeAt(t int64) (*Nii, error) {
+	hdr, err := r.partialHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if t < 0 || t >= hdr.Nt {
+		return nil, fmt.Errorf("invalid timepoint %d, must be in range [0, %d)", t, hdr.Nt)
+	}
+
+	volumeSize := hdr.Nx * hdr.Ny * hdr.Nz * int64(hdr.NByPer)
+	buf, err := r.readImageBytes(hdr, t*volumeSize, volumeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := hdr.subImage(0, hdr.Nz, t, buf)
+	return volume, nil
+}
+
+// ReadSliceAt reads only the x-y slice at (z, t) into a single-slice NIfTI image structure, whose voxels keep their
+// world coordinates
+func (r *NiiReader) ReadSliceAt(z, t int64) (*Nii, error) {
+	hdr, buf, err := r.readSliceBytes(z, t)
+	if err != nil {
+		return nil, err
+	}
+	return hdr.subImage(z, 1, t, buf), nil
+}
+
+// ReadSliceChecksum returns the CRC-32 (IEEE) checksum of the raw bytes of the x-y slice at (z, t), which can be
+// compared against a manifest to detect corrupted partial reads
+func (r *NiiReader) ReadSliceChecksum(z, t int64) (uint32, error) {
+	_, buf, err := r.readSliceBytes(z, t)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+// readSliceBytes reads only the raw bytes of the x-y slice at (z, t) and returns them along with the header image
+// structure
+func (r *NiiReader) readSliceBytes(z, t int64) (*Nii, []byte, error) {
+	hdr, err := r.partialHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if z < 0 || z >= hdr.Nz {
+		return nil, nil, fmt.Errorf("invalid slice %d, must be in range [0, %d)", z, hdr.Nz)
+	}
+	if t < 0 || t >= hdr.Nt {
+		return nil, nil, fmt.Errorf("invalid timepoint %d, must be in range [0, %d)", t, hdr.Nt)
+	}
+
+	sliceSize := hdr.Nx * hdr.Ny * int64(hdr.NByPer)
+	buf, err := r.readImageBytes(hdr, (t*hdr.Nz+z)*sliceSize, sliceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hdr, buf, nil
+}
+
+// partialHeader returns the image structure, without the image data, used by the partial reads. The header is parsed
+// by a clone of the reader on the first call and cached, so that the image structure of the reader is not modified
+func (r *NiiReader) partialHeader() (*Nii, error) {
+	if r.partialData != nil {
+		return r.partialData, nil
+	}
+
+	parser := r.clone()
+	parser.data = new(Nii)
+	parser.retainHeader = false
+	err := parser.parseHeader()
+	if err != nil {
+		return nil, err
+	}
+	r.partialData = parser.data
+	return r.partialData, nil
+}
+
+// parseHeader parses the header and the extensions without reading the image data
+func (r *NiiReader) parseHeader() error {
+	if r.reader == nil {
+		return errors.New("image reader is nil")
+	}
+
+	hReader := r.reader
+	if r.hReader != nil {
+		hReader = r.hReader
+	}
+	_, err := hReader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = r.getVersion()
+	if err != nil {
+		return err
+	}
+	return r.parseNIfTI(false)
+}
+
+// readImageBytes reads size bytes of image data starting at offset bytes after the VoxOffset of the header image
+// structure. The read does not move the cursor of the reader
+func (r *NiiReader) readImageBytes(hdr *Nii, offset, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := r.reader.ReadAt(buf, int64(hdr.VoxOffset)+offset)
+	if int64(n) < size {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// subImage returns a copy of the image structure holding nz slices of the timepoint t, starting at the slice z. The
+// origin is shifted so that the voxels keep their world coordinates and the per-volume scaling is replaced by the
+// scaling of the timepoint
+func (n *Nii) subImage(z, nz, t int64, buf []byte) *Nii {
+	img := *n
+	img.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	img.keepVolumeScaling(t)
+	img.NDim, img.Dim[0] = 3, 3
+	img.Nz, img.Dim[3] = nz, nz
+	img.Nt, img.Dim[4] = 1, 1
+	img.Nu, img.Dim[5] = 1, 1
+	img.Nv, img.Dim[6] = 1, 1
+	img.Nw, img.Dim[7] = 1, 1
+	img.NVox = img.Nx * img.Ny * img.Nz
+	img.Volume = buf
+	if z != 0 {
+		img.shiftVoxelOrigin(0, 0, float64(z))
+	}
+	return &img
+}
+
+// parseNIfTI parse the NIfTI header and the data. If readVolume is false, only the header is parsed
+func (r *NiiReader) parseNIfTI(readVolume bool) error {
 	var hReader *bytes.Reader
 	if r.hReader != nil {
 		hReader = r.hReader
@@ -114,74 +397,191 @@ func (r *NiiReader) parseNIfTI() error {
 		hReader = r.reader
 	}
 
-	_, err := hReader.Seek(0, 0)
+	header, niftiType, err := r.readHeader(hReader)
 	if err != nil {
 		return err
 	}
+	r.warnings = nil
+	err = r.parseData(header, readVolume)
+	if err != nil {
+		return err
+	}
+	r.data.NiftiType = niftiType
+
+	err = r.parseExtensions(hReader)
+	if err != nil {
+		return err
+	}
+	err = r.data.loadPerVolumeScaling()
+	if err != nil {
+		return err
+	}
+
+	if r.retainHeader {
+		r.header = header
+		r.data.HeaderGap, err = r.readHeaderGap()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readHeaderGap returns the raw bytes between the end of the header and vox_offset of a single file, i.e. the
+// extender, the extensions and the padding, so that a retained header can be rewritten verbatim. Returns nil for a
+// .hdr/.img pair
+func (r *NiiReader) readHeaderGap() ([]byte, error) {
+	if r.hReader != nil {
+		return nil, nil
+	}
+
+	var hdrSize int64
+	switch r.version {
+	case NIIVersion1:
+		hdrSize = NII1HeaderSize
+	case NIIVersion2:
+		hdrSize = NII2HeaderSize
+	default:
+		return nil, nil
+	}
+	end := int64(r.data.VoxOffset)
+	if end > r.reader.Size() {
+		end = r.reader.Size()
+	}
+	if end <= hdrSize {
+		return nil, nil
+	}
+
+	gap := make([]byte, end-hdrSize)
+	_, err := r.reader.ReadAt(gap, hdrSize)
+	if err != nil {
+		return nil, err
+	}
+	return gap, nil
+}
+
+// ParseHeaderBytes parses only the NIfTI-1 or NIfTI-2 header structure (*Nii1Header or *Nii2Header) from the start of
+// a NIfTI file, possibly gzipped, and returns the version and the byte order of the header. The bytes may be truncated
+// after the header, e.g. the first kilobytes of a large file, which allows triaging files without reading or
+// decompressing the image data
+func ParseHeaderBytes(b []byte) (int, interface{}, binary.ByteOrder, error) {
+	if len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		defer gzipReader.Close()
+
+		// Only the header is needed, a truncated stream is fine as long as it holds the largest header
+		bHeader := make([]byte, NII2HeaderSize)
+		n, err := io.ReadFull(gzipReader, bHeader)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return 0, nil, nil, err
+		}
+		b = bHeader[:n]
+	}
+
+	r := &NiiReader{
+		reader:      bytes.NewReader(b),
+		binaryOrder: binary.LittleEndian,
+		data:        &Nii{},
+	}
+	err := r.getVersion()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	header, _, err := r.readHeader(r.reader)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return r.version, header, r.binaryOrder, nil
+}
+
+// readHeader reads the NIfTI-1 or NIfTI-2 header structure of the version from the start of hReader, checks its magic
+// string and returns it along with the file type
+func (r *NiiReader) readHeader(hReader *bytes.Reader) (interface{}, int32, error) {
+	_, err := hReader.Seek(0, 0)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	var dim0 int64
 	var header interface{}
+	var niftiType int32
 
 	switch r.version {
 	case NIIVersion1:
 		n1Header := new(Nii1Header)
 		err = binary.Read(hReader, r.binaryOrder, n1Header)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		if n1Header.Magic != NIFTI_1_MAGIC_SINGLE && n1Header.Magic != NIFTI_1_MAGIC_PAIR {
-			return errors.New("invalid NIFTI-1 magic string")
+
+		// The header size matched the byte order but the dimensions may have been written swapped by a buggy tool
+		if needHeaderSwap(n1Header.Dim[0]) > 0 {
+			for i := range n1Header.Dim {
+				n1Header.Dim[i] = swapInt16(n1Header.Dim[i])
+			}
 		}
-		dim0 = int64(n1Header.Dim[0])
 
-		if dim0 < 0 || dim0 > 7 {
-			if r.binaryOrder == binary.LittleEndian {
-				r.binaryOrder = binary.BigEndian
-			} else {
-				r.binaryOrder = binary.LittleEndian
+		if n1Header.Magic != NIFTI_1_MAGIC_SINGLE && n1Header.Magic != NIFTI_1_MAGIC_PAIR {
+			var dims [8]int64
+			for i, dim := range n1Header.Dim {
+				dims[i] = int64(dim)
+			}
+			if !r.lenientMagic || !saneDims(dims) {
+				return nil, 0, errors.New("invalid NIFTI-1 magic string")
 			}
 		}
+		niftiType = r.fileType(n1Header.Magic == NIFTI_1_MAGIC_SINGLE, n1Header.Magic == NIFTI_1_MAGIC_PAIR)
 		header = n1Header
 	case NIIVersion2:
 		n2Header := new(Nii2Header)
 		err = binary.Read(hReader, r.binaryOrder, n2Header)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		if n2Header.Magic != NIFTI_2_MAGIC_SINGLE && n2Header.Magic != NIFTI_2_MAGIC_PAIR {
-			return errors.New("invalid NIFTI-2 magic string")
+
+		// The header size matched the byte order but the dimensions may have been written swapped by a buggy tool
+		if needHeader2Swap(n2Header.Dim[0]) > 0 {
+			for i := range n2Header.Dim {
+				n2Header.Dim[i] = swapInt64(n2Header.Dim[i])
+			}
 		}
-		dim0 = n2Header.Dim[0]
 
-		if dim0 < 0 || dim0 > 7 {
-			if r.binaryOrder == binary.LittleEndian {
-				r.binaryOrder = binary.BigEndian
-			} else {
-				r.binaryOrder = binary.LittleEndian
+		if n2Header.Magic != NIFTI_2_MAGIC_SINGLE && n2Header.Magic != NIFTI_2_MAGIC_PAIR {
+			if !r.lenientMagic || !saneDims(n2Header.Dim) {
+				return nil, 0, errors.New("invalid NIFTI-2 magic string")
 			}
 		}
+		niftiType = r.fileType(n2Header.Magic == NIFTI_2_MAGIC_SINGLE, n2Header.Magic == NIFTI_2_MAGIC_PAIR)
 		header = n2Header
 	default:
-		return errors.New("invalid version")
-	}
-	err = r.parseData(header)
-	if err != nil {
-		return err
+		return nil, 0, errors.New("invalid version")
 	}
+	return header, niftiType, nil
+}
 
-	if r.retainHeader {
-		r.header = header
+// fileType returns the NIfTI file type from the magic string: a pair if the magic says so or a separate header reader
+// is used, a single file otherwise, and Analyze if the magic string is unknown (lenient magic)
+func (r *NiiReader) fileType(singleMagic, pairMagic bool) int32 {
+	switch {
+	case !singleMagic && !pairMagic:
+		return NIFTI_FTYPE_ANALYZE
+	case pairMagic || r.hReader != nil:
+		return NIFTI_FTYPE_PAIR
+	default:
+		return NIFTI_FTYPE_SINGLE
 	}
-
-	return nil
 }
 
-// parseData parse the raw byte array into NIFTI-1 or NIFTI-2 data structure
-func (r *NiiReader) parseData(header interface{}) error {
+// parseData parse the raw byte array into NIFTI-1 or NIFTI-2 data structure. The image data is only read if
+// readVolume is true
+func (r *NiiReader) parseData(header interface{}, readVolume bool) error {
 	var statDim int64 = 1
 	var bitpix int16
 	var qFormCode, sFormCode, intentCode, sliceCode, datatype, freqDim, phaseDim, sliceDim int32
-	var pixDim0, sclSlope, sclInter, intentP1, intentP2, intentP3, quaternB, quaternC, quaternD, sliceDuration, calMin, calMax float64
+	var pixDim0, sclSlope, sclInter, intentP1, intentP2, intentP3, quaternB, quaternC, quaternD, sliceDuration, tOffset, calMin, calMax float64
 	var sRowX, sRowY, sRowZ [4]float64
 	var intentName [16]uint8
 	var descrip [80]uint8
@@ -207,6 +607,7 @@ func (r *NiiReader) parseData(header interface{}) error {
 		sliceStart = int64(n1Header.SliceStart)
 		sliceEnd = int64(n1Header.SliceEnd)
 		sliceDuration = float64(n1Header.SliceDuration)
+		tOffset = float64(n1Header.Toffset)
 
 		calMin = float64(n1Header.CalMin)
 		calMax = float64(n1Header.CalMax)
@@ -262,6 +663,8 @@ func (r *NiiReader) parseData(header interface{}) error {
 		r.data.QoffsetX, r.data.QoffsetY, r.data.QoffsetZ = float64(n1Header.QoffsetX), float64(n1Header.QoffsetY), float64(n1Header.QoffsetZ)
 
 		r.data.AuxFile = n1Header.AuxFile
+		r.data.DataTypeStr = n1Header.DataTypeUnused
+		r.data.DbName = n1Header.DbName
 
 	case NIIVersion2:
 		n2Header := header.(*Nii2Header)
@@ -282,6 +685,7 @@ func (r *NiiReader) parseData(header interface{}) error {
 		sliceStart = n2Header.SliceStart
 		sliceEnd = n2Header.SliceEnd
 		sliceDuration = n2Header.SliceDuration
+		tOffset = n2Header.Toffset
 
 		calMin = n2Header.CalMin
 		calMax = n2Header.CalMax
@@ -336,6 +740,20 @@ func (r *NiiReader) parseData(header interface{}) error {
 		r.data.QoffsetX, r.data.QoffsetY, r.data.QoffsetZ = n2Header.QoffsetX, n2Header.QoffsetY, n2Header.QoffsetZ
 
 		r.data.AuxFile = n2Header.AuxFile
+		r.data.UnusedStr = n2Header.UnusedStr
+	}
+
+	// In strict mode, the non-positive dimensions up to dim[0] are errors instead of being fixed
+	if r.noAutoFix {
+		var invalidDims []string
+		for i := int64(1); i <= r.data.NDim && i < 8; i++ {
+			if r.data.Dim[i] <= 0 {
+				invalidDims = append(invalidDims, fmt.Sprintf("dim[%d]=%d", i, r.data.Dim[i]))
+			}
+		}
+		if len(invalidDims) > 0 {
+			return fmt.Errorf("invalid dimensions: %s", strings.Join(invalidDims, ", "))
+		}
 	}
 
 	// Fix bad value in header
@@ -363,6 +781,17 @@ func (r *NiiReader) parseData(header interface{}) error {
 	// Set the byte order
 	r.data.ByteOrder = r.binaryOrder
 
+	// Hand-edited headers may have a bitpix that disagrees with the datatype. The datatype wins since it is also used to
+	// decode the voxel values
+	if r.data.NByPer > 0 && int32(bitpix) != 8*r.data.NByPer {
+		mismatch := fmt.Sprintf("bitpix %d does not match datatype %s", bitpix, getDatatype(datatype))
+		if r.noAutoFix {
+			return errors.New(mismatch)
+		}
+		r.warnings = append(r.warnings, fmt.Sprintf("%s, using %d", mismatch, 8*r.data.NByPer))
+		bitpix = int16(8 * r.data.NByPer)
+	}
+
 	if bitpix == 0 {
 		return errors.New("number of bits per voxel value (bitpix) is zero")
 	}
@@ -463,6 +892,7 @@ func (r *NiiReader) parseData(header interface{}) error {
 	r.data.SliceStart = sliceStart
 	r.data.SliceEnd = sliceEnd
 	r.data.SliceDuration = sliceDuration
+	r.data.TOffset = tOffset
 
 	r.data.CalMin = calMin
 	r.data.CalMax = calMax
@@ -476,17 +906,19 @@ func (r *NiiReader) parseData(header interface{}) error {
 	r.data.VoxOffset = float64(voxOffset)
 	dataSize := r.data.Dim[1] * r.data.Dim[2] * r.data.Dim[3] * r.data.Dim[4] * statDim * (int64(bitpix) / 8)
 
-	_, err := r.reader.Seek(voxOffset, 0)
-	if err != nil {
-		return err
-	}
+	if readVolume {
+		_, err := r.reader.Seek(voxOffset, 0)
+		if err != nil {
+			return err
+		}
 
-	buf := make([]byte, dataSize)
-	_, err = io.ReadFull(r.reader, buf)
-	if err != nil {
-		return err
+		buf := make([]byte, dataSize)
+		_, err = io.ReadFull(r.reader, buf)
+		if err != nil {
+			return err
+		}
+		r.data.Volume = buf
 	}
-	r.data.Volume = buf
 
 	affine := matrix.DMat44{}
 	affine.M[0] = sRowX
@@ -511,6 +943,10 @@ func (r *NiiReader) getVersion() error {
 		hReader = r.reader
 	}
 
+	if r.forceVersion != 0 {
+		return r.setForcedVersion(hReader)
+	}
+
 	err := binary.Read(hReader, r.binaryOrder, &hSize)
 	if err != nil {
 		return err
@@ -544,3 +980,41 @@ func (r *NiiReader) getVersion() error {
 	r.data.Version = r.version
 	return nil
 }
+
+// setForcedVersion sets the version specified by the user without checking the header size. Since the header size
+// cannot be trusted, the byte order is determined from dim[0] which must be in range [1, 7]
+func (r *NiiReader) setForcedVersion(hReader *bytes.Reader) error {
+	var dimOffset int64
+	var dimSize int
+
+	switch r.forceVersion {
+	case NIIVersion1:
+		dimOffset, dimSize = 40, 2
+	case NIIVersion2:
+		dimOffset, dimSize = 16, 8
+	default:
+		return fmt.Errorf("invalid forced NIfTI version %d", r.forceVersion)
+	}
+
+	bDim0 := make([]byte, dimSize)
+	_, err := hReader.ReadAt(bDim0, dimOffset)
+	if err != nil {
+		return err
+	}
+
+	var dim0 int64
+	if dimSize == 2 {
+		dim0 = int64(int16(binary.LittleEndian.Uint16(bDim0)))
+	} else {
+		dim0 = int64(binary.LittleEndian.Uint64(bDim0))
+	}
+	if dim0 < 1 || dim0 > 7 {
+		r.binaryOrder = binary.BigEndian
+	} else {
+		r.binaryOrder = binary.LittleEndian
+	}
+
+	r.version = r.forceVersion
+	r.data.Version = r.version
+	return nil
+}
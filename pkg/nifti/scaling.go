@@ -0,0 +1,102 @@
+package nifti
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// perVolumeScalingPrefix identifies the comment extension holding the per-volume scaling factors
+const perVolumeScalingPrefix = "gonii:per_volume_scaling "
+
+// perVolumeScaling defines the JSON content of the per-volume scaling extension
+type perVolumeScaling struct {
+	Slopes []float64 `json:"slopes"`
+	Inters []float64 `json:"inters"`
+}
+
+// SetPerVolumeScaling sets a scaling slope and intercept for each volume of a 4-D (or higher) image, which override
+// SclSlope and SclInter in GetAt, SetAt and SetVoxelToRawVolume. As for scl_slope, a zero slope leaves the volume
+// unscaled. The factors are stored in a comment extension so that they are kept on write and restored on read.
+// Passing nil slopes and intercepts removes the per-volume scaling
+func (n *Nii) SetPerVolumeScaling(slopes, inters []float64) error {
+	if slopes == nil && inters == nil {
+		n.VolumeSlopes, n.VolumeInters = nil, nil
+		n.removeExtensions(isPerVolumeScalingExtension)
+		return nil
+	}
+
+	if len(slopes) != len(inters) {
+		return fmt.Errorf("got %d slopes but %d intercepts", len(slopes), len(inters))
+	}
+	nVolumes := n.volumeCount()
+	if int64(len(slopes)) != nVolumes {
+		return fmt.Errorf("expected scaling factors for %d volumes, got %d", nVolumes, len(slopes))
+	}
+
+	bData, err := json.Marshal(perVolumeScaling{Slopes: slopes, Inters: inters})
+	if err != nil {
+		return err
+	}
+
+	n.VolumeSlopes = append([]float64(nil), slopes...)
+	n.VolumeInters = append([]float64(nil), inters...)
+	n.removeExtensions(isPerVolumeScalingExtension)
+	n.AddExtension(NIFTI_ECODE_COMMENT, append([]byte(perVolumeScalingPrefix), bData...))
+	return nil
+}
+
+// volumeCount returns the number of 3-D volumes of the image
+func (n *Nii) volumeCount() int64 {
+	return n.NVox / (dimOrOne(n.Nx) * dimOrOne(n.Ny) * dimOrOne(n.Nz))
+}
+
+// scalingAt returns the scaling slope and intercept of the voxel at the index in the raw volume
+func (n *Nii) scalingAt(index int64) (float64, float64) {
+	if n.VolumeSlopes != nil {
+		t := index / (dimOrOne(n.Nx) * dimOrOne(n.Ny) * dimOrOne(n.Nz))
+		if t < int64(len(n.VolumeSlopes)) {
+			return n.VolumeSlopes[t], n.VolumeInters[t]
+		}
+	}
+	return n.SclSlope, n.SclInter
+}
+
+// keepVolumeScaling replaces the per-volume scaling, if any, by the scaling of the volume t set as the global scaling
+// and drops the per-volume scaling extension, for an image derived from that volume only. It must be called before
+// the dimensions of the image are changed
+func (n *Nii) keepVolumeScaling(t int64) {
+	if n.VolumeSlopes == nil {
+		return
+	}
+	n.SclSlope, n.SclInter = n.scalingAt(t * dimOrOne(n.Nx) * dimOrOne(n.Ny) * dimOrOne(n.Nz))
+	n.VolumeSlopes, n.VolumeInters = nil, nil
+	n.removeExtensions(isPerVolumeScalingExtension)
+}
+
+// loadPerVolumeScaling restores the per-volume scaling factors from the extension, if any
+func (n *Nii) loadPerVolumeScaling() error {
+	for _, ext := range n.Nifti1Ext {
+		if !isPerVolumeScalingExtension(ext) {
+			continue
+		}
+		var scaling perVolumeScaling
+		bData := bytes.TrimRight(ext.EData[len(perVolumeScalingPrefix):], "\x00")
+		err := json.Unmarshal(bData, &scaling)
+		if err != nil {
+			return fmt.Errorf("invalid per-volume scaling extension: %w", err)
+		}
+		if len(scaling.Slopes) != len(scaling.Inters) || int64(len(scaling.Slopes)) != n.volumeCount() {
+			return errors.New("invalid per-volume scaling extension: the number of factors does not match the number of volumes")
+		}
+		n.VolumeSlopes, n.VolumeInters = scaling.Slopes, scaling.Inters
+		return nil
+	}
+	return nil
+}
+
+// isPerVolumeScalingExtension returns whether the extension holds the per-volume scaling factors
+func isPerVolumeScalingExtension(ext Nifti1Ext) bool {
+	return ext.ECode == NIFTI_ECODE_COMMENT && bytes.HasPrefix(ext.EData, []byte(perVolumeScalingPrefix))
+}
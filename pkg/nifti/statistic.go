@@ -0,0 +1,62 @@
+package nifti
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/internal/utils"
+)
+
+// ToPValues returns a new FLOAT32 image where each voxel statistic is converted to its upper tail p-value according
+// to the intent code and the intent parameters. The t-test, z-score, F-test and chi-squared intents are supported.
+// The returned image has the NIFTI_INTENT_PVAL intent code
+func (n *Nii) ToPValues() (*Nii, error) {
+	var pValue func(stat float64) float64
+
+	switch int16(n.IntentCode) {
+	case NIFTI_INTENT_ZSCORE:
+		pValue = utils.NormalSF
+	case NIFTI_INTENT_TTEST:
+		dof := n.IntentP1
+		pValue = func(stat float64) float64 { return utils.StudentTSF(stat, dof) }
+	case NIFTI_INTENT_FTEST:
+		dof1, dof2 := n.IntentP1, n.IntentP2
+		pValue = func(stat float64) float64 { return utils.FSF(stat, dof1, dof2) }
+	case NIFTI_INTENT_CHISQ:
+		dof := n.IntentP1
+		pValue = func(stat float64) float64 { return utils.ChiSquareSF(stat, dof) }
+	default:
+		return nil, fmt.Errorf("unsupported intent code %d for p-value conversion", n.IntentCode)
+	}
+
+	if n.Nx*n.Ny*n.Nz == 0 || n.NByPer == 0 {
+		return nil, errors.New("image has no voxel data")
+	}
+
+	out := *n
+	out.Nifti1Ext = append([]Nifti1Ext(nil), n.Nifti1Ext...)
+	out.Datatype = DT_FLOAT32
+	out.NByPer, out.SwapSize = 4, 4
+	out.SclSlope, out.SclInter = 1, 0
+	out.VolumeSlopes, out.VolumeInters = nil, nil
+	out.removeExtensions(isPerVolumeScalingExtension)
+	out.CalMin, out.CalMax = 0, 0
+	out.IntentCode = int32(NIFTI_INTENT_PVAL)
+	out.IntentP1, out.IntentP2, out.IntentP3 = 0, 0, 0
+
+	nVolumes := int64(len(n.Volume)) / (n.Nx * n.Ny * n.Nz * int64(n.NByPer))
+	out.Volume = make([]byte, n.Nx*n.Ny*n.Nz*nVolumes*4)
+
+	for t := int64(0); t < nVolumes; t++ {
+		for z := int64(0); z < n.Nz; z++ {
+			for y := int64(0); y < n.Ny; y++ {
+				for x := int64(0); x < n.Nx; x++ {
+					err := out.SetAt(pValue(n.GetAt(x, y, z, t)), x, y, z, t)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,35 @@
+package nifti
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestNii_ToPValues(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestNii(4, 1, 1, 1, DT_FLOAT32)
+	img.IntentCode = int32(NIFTI_INTENT_ZSCORE)
+	zValues := []float32{0, 1.6449, 1.96, 3.0902}
+	for i, z := range zValues {
+		binary.LittleEndian.PutUint32(img.Volume[4*i:], math.Float32bits(z))
+	}
+
+	pImg, err := img.ToPValues()
+	assert.NoError(err)
+	assert.Equal(int32(NIFTI_INTENT_PVAL), pImg.IntentCode)
+	assert.Equal(DT_FLOAT32, pImg.Datatype)
+	for i, expected := range []float64{0.5, 0.05, 0.025, 0.001} {
+		assert.InDelta(expected, pImg.GetAt(int64(i), 0, 0, 0), 1e-4)
+	}
+
+	// The input image is left untouched
+	assert.Equal(int32(NIFTI_INTENT_ZSCORE), img.IntentCode)
+	assert.InDelta(1.96, img.GetAt(2, 0, 0, 0), 1e-6)
+
+	img.IntentCode = int32(NIFTI_INTENT_LABEL)
+	_, err = img.ToPValues()
+	assert.Error(err)
+}
@@ -0,0 +1,123 @@
+package nifti
+
+import (
+	"fmt"
+	"math"
+)
+
+// MultibandFactor returns the number of slices acquired simultaneously (multiband or simultaneous multi-slice
+// factor). The factor set with SetMultibandFactor is returned if any. Otherwise, it is inferred from the slice duration
+// and the repetition time (Dt), since all the slice groups are acquired within one repetition. 1 is returned if it
+// cannot be inferred
+func (n *Nii) MultibandFactor() int {
+	if n.MBFactor > 0 {
+		return n.MBFactor
+	}
+
+	_, _, count, err := n.sliceRange()
+	if err != nil || n.SliceDuration <= 0 || n.Dt <= 0 {
+		return 1
+	}
+	factor := int64(math.Round(float64(count) * n.SliceDuration / n.Dt))
+	if factor < 2 || count%factor != 0 {
+		return 1
+	}
+	return int(factor)
+}
+
+// SetMultibandFactor sets the number of slices acquired simultaneously. A factor of 0 restores the inference from the
+// slice duration and the repetition time
+func (n *Nii) SetMultibandFactor(factor int) {
+	n.MBFactor = factor
+}
+
+// SliceTimes returns the acquisition time of each slice along the slice dimension, relative to the start of the
+// volume, from the slice timing pattern (slice code), the slice duration and the multiband factor. With a multiband
+// factor m, the slices are acquired in m simultaneous groups following the pattern. The slices outside the
+// [SliceStart, SliceEnd] range have a time of 0
+func (n *Nii) SliceTimes() ([]float64, error) {
+	start, nSlices, count, err := n.sliceRange()
+	if err != nil {
+		return nil, err
+	}
+
+	factor := int64(n.MultibandFactor())
+	if count%factor != 0 {
+		return nil, fmt.Errorf("%d slices cannot be split in %d multiband groups", count, factor)
+	}
+	groupSize := count / factor
+
+	times := make([]float64, nSlices)
+	for s := start; s < start+count; s++ {
+		order, err := sliceOrder(n.SliceCode, (s-start)%groupSize, groupSize)
+		if err != nil {
+			return nil, err
+		}
+		times[s] = float64(order) * n.SliceDuration
+	}
+	return times, nil
+}
+
+// sliceRange returns the first slice, the number of slices along the slice dimension and the number of slices of the
+// [SliceStart, SliceEnd] range. An unset SliceEnd stands for the last slice
+func (n *Nii) sliceRange() (int64, int64, int64, error) {
+	if n.SliceDim < 1 || n.SliceDim > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid slice dimension %d", n.SliceDim)
+	}
+	nSlices := n.Dim[n.SliceDim]
+
+	start, end := n.SliceStart, n.SliceEnd
+	if end <= 0 {
+		end = nSlices - 1
+	}
+	if start < 0 || start > end || end >= nSlices {
+		return 0, 0, 0, fmt.Errorf("invalid slice range [%d, %d] for %d slices", start, end, nSlices)
+	}
+	return start, nSlices, end - start + 1, nil
+}
+
+// sliceOrder returns the acquisition order of the slice p among the count slices following the slice timing pattern
+func sliceOrder(sliceCode int32, p, count int64) (int64, error) {
+	switch sliceCode {
+	case NIFTI_SLICE_SEQ_INC:
+		return p, nil
+	case NIFTI_SLICE_SEQ_DEC:
+		return count - 1 - p, nil
+	case NIFTI_SLICE_ALT_INC:
+		return alternateOrder(p, count), nil
+	case NIFTI_SLICE_ALT_DEC:
+		return alternateOrder(count-1-p, count), nil
+	case NIFTI_SLICE_ALT_INC2:
+		return alternate2Order(p, count), nil
+	case NIFTI_SLICE_ALT_DEC2:
+		return alternate2Order(count-1-p, count), nil
+	default:
+		return 0, fmt.Errorf("unsupported slice code %d", sliceCode)
+	}
+}
+
+// alternateOrder returns the acquisition order of the slice p when the even slices are acquired first (0, 2, 4, ...,
+// 1, 3, 5, ...)
+func alternateOrder(p, count int64) int64 {
+	if p%2 == 0 {
+		return p / 2
+	}
+	return (count+1)/2 + p/2
+}
+
+// alternate2Order returns the acquisition order of the slice p when the odd slices are acquired first (1, 3, 5, ...,
+// 0, 2, 4, ...)
+func alternate2Order(p, count int64) int64 {
+	if p%2 == 1 {
+		return p / 2
+	}
+	return count/2 + p/2
+}
+
+// VolumeTime returns the acquisition time of the volume t, TOffset + t*Dt. The time is in the temporal unit of the
+// image (TimeUnits: seconds, milliseconds or microseconds, see GetUnitsOfMeasurements) and is not converted, as both
+// toffset and pixdim[4] are stored in that unit. For the spectral units (Hz, ppm, rad/s), the fourth dimension is not
+// time and the result is the coordinate of the volume along it
+func (n *Nii) VolumeTime(t int64) float64 {
+	return n.TOffset + float64(t)*n.Dt
+}
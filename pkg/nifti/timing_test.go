@@ -0,0 +1,71 @@
+package nifti
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNii_SliceTimes(t *testing.T) {
+	assert := assert.New(t)
+
+	img := &Nii{
+		Dim:           [8]int64{4, 64, 64, 8, 10, 1, 1, 1},
+		SliceDim:      3,
+		SliceCode:     NIFTI_SLICE_SEQ_INC,
+		SliceDuration: 0.25,
+	}
+
+	times, err := img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 0.25, 0.5, 0.75, 1, 1.25, 1.5, 1.75}, times)
+	assert.Equal(1, img.MultibandFactor())
+
+	img.SliceCode = NIFTI_SLICE_ALT_INC
+	times, err = img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 1, 0.25, 1.25, 0.5, 1.5, 0.75, 1.75}, times)
+
+	img.SliceCode = NIFTI_SLICE_ALT_DEC2
+	times, err = img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0.75, 1.75, 0.5, 1.5, 0.25, 1.25, 0, 1}, times)
+
+	_, err = (&Nii{Dim: img.Dim, SliceDim: 3}).SliceTimes()
+	assert.Error(err)
+}
+
+func TestNii_MultibandFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	// 8 slices acquired in two simultaneous groups of 4 within a 1 s repetition
+	img := &Nii{
+		Dim:           [8]int64{4, 64, 64, 8, 10, 1, 1, 1},
+		SliceDim:      3,
+		SliceCode:     NIFTI_SLICE_SEQ_INC,
+		SliceDuration: 0.25,
+		Dt:            1,
+	}
+	assert.Equal(2, img.MultibandFactor())
+
+	times, err := img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 0.25, 0.5, 0.75, 0, 0.25, 0.5, 0.75}, times)
+
+	img.SliceCode = NIFTI_SLICE_ALT_INC
+	times, err = img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 0.5, 0.25, 0.75, 0, 0.5, 0.25, 0.75}, times)
+
+	// The factor set explicitly wins over the inference
+	img.Dt = 0
+	assert.Equal(1, img.MultibandFactor())
+	img.SetMultibandFactor(2)
+	assert.Equal(2, img.MultibandFactor())
+	times, err = img.SliceTimes()
+	assert.NoError(err)
+	assert.Equal([]float64{0, 0.5, 0.25, 0.75, 0, 0.5, 0.25, 0.75}, times)
+
+	img.SetMultibandFactor(3)
+	_, err = img.SliceTimes()
+	assert.Error(err)
+}
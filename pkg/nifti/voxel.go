@@ -1,8 +1,14 @@
 package nifti
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/okieraised/gonii/internal/utils"
+	"io"
+	"math"
+	"sort"
 )
 
 // Voxels defines the structure of Voxel values
@@ -25,6 +31,11 @@ func NewVoxels(dimX, dimY, dimZ, dimT int64, datatype int32) *Voxels {
 	}
 }
 
+// NewTypedVoxels returns a pointer to the Voxels with specified input parameters and a typed Datatype
+func NewTypedVoxels(dimX, dimY, dimZ, dimT int64, datatype Datatype) *Voxels {
+	return NewVoxels(dimX, dimY, dimZ, dimT, int32(datatype))
+}
+
 // Flip flips the image along the specified axes
 func (v *Voxels) Flip(flipX, flipY, flipZ bool) *Voxels {
 	if flipX {
@@ -121,6 +132,23 @@ func (v *Voxels) Get(x, y, z, t int64) float64 {
 	return v.voxel[idx]
 }
 
+// ForEachInStorageOrder calls fn for each voxel, walking the backing slice linearly (x fastest, then y, z and t) and
+// decoding the coordinates incrementally. This is much more cache friendly than nested loops with x outermost
+func (v *Voxels) ForEachInStorageOrder(fn func(idx int64, x, y, z, t int64, val float64)) {
+	var idx int64
+	for t := int64(0); t < v.dimT; t++ {
+		for z := int64(0); z < v.dimZ; z++ {
+			for y := int64(0); y < v.dimY; y++ {
+				row := v.voxel[idx : idx+v.dimX]
+				for x, val := range row {
+					fn(idx, int64(x), y, z, t, val)
+					idx++
+				}
+			}
+		}
+	}
+}
+
 // GetDimX returns the dimX information
 func (v *Voxels) GetDimX() int64 {
 	return v.dimX
@@ -196,6 +224,54 @@ func (v *Voxels) Histogram(bins int) (utils.Histogram, error) {
 	return utils.Hist(bins, v.voxel)
 }
 
+// HistogramRange returns the histogram of the voxels over bins of the fixed [min, max] range, so that histograms of
+// different images can be compared. Values outside the range go to the edge bins if clamp is true and are dropped
+// otherwise
+func (v *Voxels) HistogramRange(bins int, min, max float64, clamp bool) (utils.Histogram, error) {
+	return utils.HistRange(bins, min, max, v.voxel, clamp)
+}
+
+// otsuBins is the number of histogram bins used to compute the Otsu threshold
+const otsuBins = 256
+
+// OtsuThreshold returns the threshold separating the voxel intensities into the two classes with the maximal
+// between-class variance (Otsu's method), computed from a 256-bin histogram. The voxels above the threshold are the
+// foreground. If all the voxels have the same value, this value is returned
+func (v *Voxels) OtsuThreshold() float64 {
+	hist, err := utils.Hist(otsuBins, v.voxel)
+	if err != nil || len(hist.Buckets) == 0 {
+		return 0
+	}
+	if len(hist.Buckets) == 1 {
+		return hist.Buckets[0].Min
+	}
+
+	var total, sumAll float64
+	for _, bkt := range hist.Buckets {
+		total += float64(bkt.Count)
+		sumAll += float64(bkt.Count) * (bkt.Min + bkt.Max) / 2
+	}
+
+	var weightBackground, sumBackground, bestVariance float64
+	threshold := hist.Buckets[0].Max
+	for _, bkt := range hist.Buckets[:len(hist.Buckets)-1] {
+		weightBackground += float64(bkt.Count)
+		sumBackground += float64(bkt.Count) * (bkt.Min + bkt.Max) / 2
+		weightForeground := total - weightBackground
+		if weightBackground == 0 || weightForeground == 0 {
+			continue
+		}
+		meanBackground := sumBackground / weightBackground
+		meanForeground := (sumAll - sumBackground) / weightForeground
+		variance := weightBackground * weightForeground * (meanBackground - meanForeground) * (meanBackground - meanForeground)
+		if variance > bestVariance {
+			bestVariance = variance
+			threshold = bkt.Max
+		}
+	}
+	return threshold
+}
+
 // RLEEncode encodes the 1-D float64 array using the RLE encoding
 func (v *Voxels) RLEEncode() ([]float64, error) {
 	return RLEEncode(v.voxel)
@@ -210,6 +286,349 @@ func (v *Voxels) MapValueOccurrence() map[float64]int {
 	return valMapper
 }
 
+// ValueCount defines the number of occurrences of a voxel value
+type ValueCount struct {
+	Value float64
+	Count int
+}
+
+// TopValues returns the n most frequent nonzero voxel values sorted by descending count. Values with the same count are
+// sorted by ascending value. If n is not positive, all the nonzero values are returned
+func (v *Voxels) TopValues(n int) []ValueCount {
+	var counts []ValueCount
+	for val, count := range v.MapValueOccurrence() {
+		if val == 0 {
+			continue
+		}
+		counts = append(counts, ValueCount{Value: val, Count: count})
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+	if n > 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Remap replaces each voxel value found in the mapping by its mapped value. Unmapped values are left unchanged
+func (v *Voxels) Remap(mapping map[float64]float64) {
+	for idx, val := range v.voxel {
+		if newVal, ok := mapping[val]; ok {
+			v.voxel[idx] = newVal
+		}
+	}
+}
+
+// Percentiles returns the voxel values at the given percentiles (0 to 100), linearly interpolated between the closest
+// ranks of the sorted values
+func (v *Voxels) Percentiles(pcts ...float64) ([]float64, error) {
+	if len(v.voxel) == 0 {
+		return nil, errors.New("voxels are empty")
+	}
+
+	sorted := append([]float64(nil), v.voxel...)
+	sort.Float64s(sorted)
+
+	result := make([]float64, len(pcts))
+	for i, pct := range pcts {
+		if pct < 0 || pct > 100 {
+			return nil, fmt.Errorf("percentile %v must be between 0 and 100", pct)
+		}
+		rank := pct / 100 * float64(len(sorted)-1)
+		lower := int(rank)
+		if lower >= len(sorted)-1 {
+			result[i] = sorted[len(sorted)-1]
+			continue
+		}
+		result[i] = sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+	}
+	return result, nil
+}
+
+// Clip limits the voxel values to the [min, max] range
+func (v *Voxels) Clip(min, max float64) {
+	for idx, val := range v.voxel {
+		if val < min {
+			v.voxel[idx] = min
+		} else if val > max {
+			v.voxel[idx] = max
+		}
+	}
+}
+
+// IsBinaryMask returns whether all the voxel values are 0 or 1
+func (v *Voxels) IsBinaryMask() bool {
+	for _, val := range v.voxel {
+		if val != 0 && val != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsIntegerLabeled returns whether all the voxel values are integers, e.g. a label map that should be resampled with
+// nearest neighbor interpolation
+func (v *Voxels) IsIntegerLabeled() bool {
+	for _, val := range v.voxel {
+		if val != math.Trunc(val) {
+			return false
+		}
+	}
+	return true
+}
+
+// GradientMagnitude returns the FLOAT32 voxels holding the gradient magnitude of each 3-D volume, computed with
+// central differences scaled by the voxel spacing (dx, dy, dz), e.g. the pixdims. One-sided differences are used at the
+// borders and the derivative along an axis of size 1 is 0
+func (v *Voxels) GradientMagnitude(dx, dy, dz float64) *Voxels {
+	out := NewVoxels(v.dimX, v.dimY, v.dimZ, v.dimT, DT_FLOAT32)
+	strides := [3]int64{1, v.dimX, v.dimX * v.dimY}
+	dims := [3]int64{v.dimX, v.dimY, v.dimZ}
+	spacings := [3]float64{dx, dy, dz}
+
+	idx := int64(0)
+	for t := int64(0); t < v.dimT; t++ {
+		for z := int64(0); z < v.dimZ; z++ {
+			for y := int64(0); y < v.dimY; y++ {
+				for x := int64(0); x < v.dimX; x++ {
+					coords := [3]int64{x, y, z}
+					var sumSquares float64
+					for axis := 0; axis < 3; axis++ {
+						if dims[axis] < 2 {
+							continue
+						}
+						prev, next := idx, idx
+						if coords[axis] > 0 {
+							prev -= strides[axis]
+						}
+						if coords[axis] < dims[axis]-1 {
+							next += strides[axis]
+						}
+						steps := float64((next - prev) / strides[axis])
+						derivative := (v.voxel[next] - v.voxel[prev]) / (steps * spacings[axis])
+						sumSquares += derivative * derivative
+					}
+					out.voxel[idx] = math.Sqrt(sumSquares)
+					idx++
+				}
+			}
+		}
+	}
+	return out
+}
+
+// checkSameShape returns an error if the other voxels do not have the same dimensions
+func (v *Voxels) checkSameShape(other *Voxels) error {
+	if other == nil {
+		return errors.New("other voxels is nil")
+	}
+	if v.dimX != other.dimX || v.dimY != other.dimY || v.dimZ != other.dimZ || v.dimT != other.dimT {
+		return fmt.Errorf("dimensions mismatch: [%d %d %d %d] vs [%d %d %d %d]",
+			v.dimX, v.dimY, v.dimZ, v.dimT, other.dimX, other.dimY, other.dimZ, other.dimT)
+	}
+	return nil
+}
+
+// labelOverlap returns the number of voxels with the label in v, in other and in both
+func (v *Voxels) labelOverlap(other *Voxels, label float64) (int, int, int, error) {
+	err := v.checkSameShape(other)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var countV, countOther, intersection int
+	for idx, val := range v.voxel {
+		inV := val == label
+		inOther := other.voxel[idx] == label
+		if inV {
+			countV++
+		}
+		if inOther {
+			countOther++
+		}
+		if inV && inOther {
+			intersection++
+		}
+	}
+	return countV, countOther, intersection, nil
+}
+
+// Dice returns the Dice coefficient 2|A∩B| / (|A|+|B|) between the voxels with the label in v and in other. If the
+// label is absent from both, the masks are considered identical and 1 is returned
+func (v *Voxels) Dice(other *Voxels, label float64) (float64, error) {
+	countV, countOther, intersection, err := v.labelOverlap(other, label)
+	if err != nil {
+		return 0, err
+	}
+	if countV+countOther == 0 {
+		return 1, nil
+	}
+	return 2 * float64(intersection) / float64(countV+countOther), nil
+}
+
+// Jaccard returns the Jaccard index |A∩B| / |A∪B| between the voxels with the label in v and in other. If the label
+// is absent from both, the masks are considered identical and 1 is returned
+func (v *Voxels) Jaccard(other *Voxels, label float64) (float64, error) {
+	countV, countOther, intersection, err := v.labelOverlap(other, label)
+	if err != nil {
+		return 0, err
+	}
+	union := countV + countOther - intersection
+	if union == 0 {
+		return 1, nil
+	}
+	return float64(intersection) / float64(union), nil
+}
+
+// CenterOfMass returns the voxel coordinates (x, y, z) of the centroid of the voxels equal to the label, i.e. the mean
+// of their coordinates. The voxels of all the volumes are counted. false is returned if the label is absent
+func (v *Voxels) CenterOfMass(label float64) ([3]float64, bool) {
+	var sum [3]float64
+	var count int64
+	v.ForEachInStorageOrder(func(idx int64, x, y, z, t int64, val float64) {
+		if val != label {
+			return
+		}
+		sum[0] += float64(x)
+		sum[1] += float64(y)
+		sum[2] += float64(z)
+		count++
+	})
+	if count == 0 {
+		return [3]float64{}, false
+	}
+	for i := range sum {
+		sum[i] /= float64(count)
+	}
+	return sum, true
+}
+
+// Overlay merges the nonzero voxels of other into v. With OVERLAY_OVERWRITE they replace the existing values, with
+// OVERLAY_FILL_GAPS they are only copied where v is zero
+func (v *Voxels) Overlay(other *Voxels, priority OverlayMode) error {
+	err := v.checkSameShape(other)
+	if err != nil {
+		return err
+	}
+	if priority != OVERLAY_OVERWRITE && priority != OVERLAY_FILL_GAPS {
+		return fmt.Errorf("invalid overlay mode %d", priority)
+	}
+
+	for idx, val := range other.voxel {
+		if val == 0 {
+			continue
+		}
+		if priority == OVERLAY_FILL_GAPS && v.voxel[idx] != 0 {
+			continue
+		}
+		v.voxel[idx] = val
+	}
+	return nil
+}
+
+// StreamEncodeRLE writes the whole volume to w as a compact little-endian binary RLE without building intermediate
+// arrays. The stream starts with the four dimensions (int64) and the datatype (int32), followed by the runs in storage
+// order, each written as the value (float64) and the run length (uint64)
+func (v *Voxels) StreamEncodeRLE(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, 36)
+	binary.LittleEndian.PutUint64(header[0:8], uint64(v.dimX))
+	binary.LittleEndian.PutUint64(header[8:16], uint64(v.dimY))
+	binary.LittleEndian.PutUint64(header[16:24], uint64(v.dimZ))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(v.dimT))
+	binary.LittleEndian.PutUint32(header[32:36], uint32(v.datatype))
+	_, err := bw.Write(header)
+	if err != nil {
+		return err
+	}
+
+	run := make([]byte, 16)
+	writeRun := func(value float64, length uint64) error {
+		binary.LittleEndian.PutUint64(run[0:8], math.Float64bits(value))
+		binary.LittleEndian.PutUint64(run[8:16], length)
+		_, err := bw.Write(run)
+		return err
+	}
+
+	if len(v.voxel) > 0 {
+		value, length := v.voxel[0], uint64(1)
+		for _, val := range v.voxel[1:] {
+			if val == value {
+				length++
+				continue
+			}
+			err = writeRun(value, length)
+			if err != nil {
+				return err
+			}
+			value, length = val, 1
+		}
+		err = writeRun(value, length)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// maxStreamVoxels is the largest number of voxels StreamDecodeRLE allocates for a stream
+const maxStreamVoxels = 1 << 30
+
+// StreamDecodeRLE reads the binary RLE written by StreamEncodeRLE from r and returns the decoded voxels. Streams of
+// more than maxStreamVoxels voxels are rejected
+func StreamDecodeRLE(r io.Reader) (*Voxels, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, 36)
+	_, err := io.ReadFull(br, header)
+	if err != nil {
+		return nil, err
+	}
+	// The voxel count is checked before each product so that a corrupt header cannot overflow it or allocate too much
+	var dims [4]int64
+	nVox := int64(1)
+	for i := range dims {
+		dims[i] = int64(binary.LittleEndian.Uint64(header[i*8 : (i+1)*8]))
+		if dims[i] < 0 {
+			return nil, fmt.Errorf("invalid negative dimension %d", dims[i])
+		}
+		if dims[i] != 0 && nVox > maxStreamVoxels/dims[i] {
+			return nil, fmt.Errorf("dimensions %v exceed the maximum of %d voxels", dims[:i+1], int64(maxStreamVoxels))
+		}
+		nVox *= dims[i]
+	}
+	datatype := int32(binary.LittleEndian.Uint32(header[32:36]))
+	if !IsValidDatatype(datatype) {
+		return nil, fmt.Errorf("unknown datatype value %d", datatype)
+	}
+
+	v := NewVoxels(dims[0], dims[1], dims[2], dims[3], datatype)
+
+	run := make([]byte, 16)
+	for idx := uint64(0); idx < uint64(len(v.voxel)); {
+		_, err = io.ReadFull(br, run)
+		if err != nil {
+			return nil, err
+		}
+		value := math.Float64frombits(binary.LittleEndian.Uint64(run[0:8]))
+		length := binary.LittleEndian.Uint64(run[8:16])
+		if length > uint64(len(v.voxel))-idx {
+			return nil, errors.New("run length exceeds the volume size")
+		}
+		for end := idx + length; idx < end; idx++ {
+			v.voxel[idx] = value
+		}
+	}
+	return v, nil
+}
+
 // ImportAsRLE import the NIfTI image as an array of RLE-encoded segment
 func (v *Voxels) ImportAsRLE() ([]SegmentRLE, error) {
 	valMapper := v.MapValueOccurrence()
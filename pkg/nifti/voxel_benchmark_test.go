@@ -0,0 +1,111 @@
+package nifti
+
+import (
+	"testing"
+)
+
+func newBenchmarkVoxels() *Voxels {
+	vox := NewVoxels(256, 256, 128, 1, DT_FLOAT32)
+	for idx := range vox.voxel {
+		vox.voxel[idx] = float64(idx % 251)
+	}
+	return vox
+}
+
+func BenchmarkVoxels_ForEachInStorageOrder(b *testing.B) {
+	vox := newBenchmarkVoxels()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var sum float64
+		vox.ForEachInStorageOrder(func(idx int64, x, y, z, t int64, val float64) {
+			sum += val
+		})
+	}
+}
+
+func BenchmarkVoxels_NestedXOuter(b *testing.B) {
+	vox := newBenchmarkVoxels()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var sum float64
+		for x := int64(0); x < vox.dimX; x++ {
+			for y := int64(0); y < vox.dimY; y++ {
+				for z := int64(0); z < vox.dimZ; z++ {
+					for t := int64(0); t < vox.dimT; t++ {
+						sum += vox.Get(x, y, z, t)
+					}
+				}
+			}
+		}
+	}
+}
+
+func newBenchmarkFloat32Image() (*Nii, []float32) {
+	img := newTestNii(256, 256, 128, 1, DT_FLOAT32)
+	data := make([]float32, img.NVox)
+	for idx := range data {
+		data[idx] = float32(idx % 251)
+	}
+	return img, data
+}
+
+func BenchmarkNii_SetFloat32Volume(b *testing.B) {
+	img, data := newBenchmarkFloat32Image()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := img.SetFloat32Volume(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNii_SetVoxelToRawVolumeFloat32(b *testing.B) {
+	img, _ := newBenchmarkFloat32Image()
+	vox := newBenchmarkVoxels()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := img.SetVoxelToRawVolume(vox)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNii_GetVolumeFlat(b *testing.B) {
+	img, data := newBenchmarkFloat32Image()
+	img.Nt = 1
+	err := img.SetFloat32Volume(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := img.GetVolumeFlat(0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNii_GetVolume(b *testing.B) {
+	img, data := newBenchmarkFloat32Image()
+	img.Nt = 1
+	err := img.SetFloat32Volume(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := img.GetVolume(0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
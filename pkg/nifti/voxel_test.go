@@ -0,0 +1,293 @@
+package nifti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/okieraised/gonii/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// newLabelVoxels returns a 4x4x2 label map with 3 voxels of label 1, 5 of label 2 and 1 of label 7
+func newLabelVoxels() *Voxels {
+	vox := NewVoxels(4, 4, 2, 1, DT_UINT8)
+	for _, idx := range []int64{0, 1, 2} {
+		vox.voxel[idx] = 1
+	}
+	for _, idx := range []int64{5, 6, 17, 18, 31} {
+		vox.voxel[idx] = 2
+	}
+	vox.voxel[20] = 7
+	return vox
+}
+
+func TestVoxels_TopValues(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := newLabelVoxels()
+	assert.Equal([]ValueCount{{Value: 2, Count: 5}, {Value: 1, Count: 3}}, vox.TopValues(2))
+	assert.Equal([]ValueCount{{Value: 2, Count: 5}, {Value: 1, Count: 3}, {Value: 7, Count: 1}}, vox.TopValues(0))
+	assert.Len(vox.TopValues(10), 3)
+}
+
+func TestVoxels_Remap(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := newLabelVoxels()
+	vox.Remap(map[float64]float64{1: 10, 2: 20})
+	assert.Equal(map[float64]int{0: 23, 10: 3, 20: 5, 7: 1}, vox.MapValueOccurrence())
+}
+
+func TestVoxels_DiceJaccard(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := newLabelVoxels()
+
+	// Identical masks
+	dice, err := vox.Dice(newLabelVoxels(), 2)
+	assert.NoError(err)
+	assert.Equal(1.0, dice)
+	jaccard, err := vox.Jaccard(newLabelVoxels(), 2)
+	assert.NoError(err)
+	assert.Equal(1.0, jaccard)
+
+	// Disjoint masks
+	other := NewVoxels(4, 4, 2, 1, DT_UINT8)
+	other.voxel[10], other.voxel[11] = 2, 2
+	dice, err = vox.Dice(other, 2)
+	assert.NoError(err)
+	assert.Equal(0.0, dice)
+	jaccard, err = vox.Jaccard(other, 2)
+	assert.NoError(err)
+	assert.Equal(0.0, jaccard)
+
+	// Partial overlap: 5 and 2 voxels sharing 1
+	other.voxel[5] = 2
+	other.voxel[11] = 0
+	dice, err = vox.Dice(other, 2)
+	assert.NoError(err)
+	assert.InDelta(2.0/7.0, dice, 1e-12)
+	jaccard, err = vox.Jaccard(other, 2)
+	assert.NoError(err)
+	assert.InDelta(1.0/6.0, jaccard, 1e-12)
+
+	_, err = vox.Dice(NewVoxels(4, 4, 3, 1, DT_UINT8), 2)
+	assert.Error(err)
+}
+
+func TestVoxels_Overlay(t *testing.T) {
+	assert := assert.New(t)
+
+	// Manual corrections: relabel voxel 0 and add voxel 3
+	corrections := NewVoxels(4, 4, 2, 1, DT_UINT8)
+	corrections.voxel[0] = 5
+	corrections.voxel[3] = 5
+
+	vox := newLabelVoxels()
+	err := vox.Overlay(corrections, OVERLAY_OVERWRITE)
+	assert.NoError(err)
+	assert.Equal(5.0, vox.voxel[0])
+	assert.Equal(5.0, vox.voxel[3])
+	assert.Equal(1.0, vox.voxel[1])
+
+	vox = newLabelVoxels()
+	err = vox.Overlay(corrections, OVERLAY_FILL_GAPS)
+	assert.NoError(err)
+	assert.Equal(1.0, vox.voxel[0])
+	assert.Equal(5.0, vox.voxel[3])
+
+	err = vox.Overlay(NewVoxels(4, 4, 2, 2, DT_UINT8), OVERLAY_OVERWRITE)
+	assert.Error(err)
+	err = vox.Overlay(corrections, OverlayMode(5))
+	assert.Error(err)
+}
+
+func TestVoxels_StreamEncodeRLE(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := newLabelVoxels()
+	vox.voxel[20] = 2.5
+	var buf bytes.Buffer
+	err := vox.StreamEncodeRLE(&buf)
+	assert.NoError(err)
+
+	decoded, err := StreamDecodeRLE(&buf)
+	assert.NoError(err)
+	assert.Equal(vox, decoded)
+
+	// Truncated stream
+	buf.Reset()
+	err = vox.StreamEncodeRLE(&buf)
+	assert.NoError(err)
+	_, err = StreamDecodeRLE(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Error(err)
+
+	// Malformed headers are rejected before allocating the voxels
+	for _, hdr := range []struct {
+		dims     [4]uint64
+		datatype uint32
+	}{
+		{[4]uint64{1 << 32, 1 << 32, 1, 1}, uint32(DT_UINT8)},
+		{[4]uint64{1 << 62, 4, 1, 1}, uint32(DT_UINT8)},
+		{[4]uint64{1024, 1024, 1024, 2}, uint32(DT_UINT8)},
+		{[4]uint64{1 << 63, 1, 1, 1}, uint32(DT_UINT8)},
+		{[4]uint64{4, 4, 2, 1}, 3},
+	} {
+		header := make([]byte, 36)
+		for i, dim := range hdr.dims {
+			binary.LittleEndian.PutUint64(header[i*8:], dim)
+		}
+		binary.LittleEndian.PutUint32(header[32:], hdr.datatype)
+		_, err = StreamDecodeRLE(bytes.NewReader(header))
+		assert.Error(err, "header %v", hdr)
+	}
+}
+
+func TestVoxels_ForEachInStorageOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := NewVoxels(3, 4, 2, 2, DT_FLOAT32)
+	for idx := range vox.voxel {
+		vox.voxel[idx] = float64(idx)
+	}
+
+	var visited int64
+	vox.ForEachInStorageOrder(func(idx int64, x, y, z, t int64, val float64) {
+		assert.Equal(visited, idx)
+		assert.Equal(vox.Get(x, y, z, t), val)
+		visited++
+	})
+	assert.Equal(int64(vox.Len()), visited)
+}
+
+func TestVoxels_IsBinaryMask(t *testing.T) {
+	assert := assert.New(t)
+
+	mask := NewVoxels(4, 4, 2, 1, DT_UINT8)
+	mask.Set(1, 1, 0, 0, 1)
+	mask.Set(2, 3, 1, 0, 1)
+	assert.True(mask.IsBinaryMask())
+	assert.True(mask.IsIntegerLabeled())
+
+	labels := newLabelVoxels()
+	assert.False(labels.IsBinaryMask())
+	assert.True(labels.IsIntegerLabeled())
+
+	probability := NewVoxels(4, 4, 2, 1, DT_FLOAT32)
+	probability.Set(1, 1, 0, 0, 0.25)
+	probability.Set(2, 3, 1, 0, 1)
+	assert.False(probability.IsBinaryMask())
+	assert.False(probability.IsIntegerLabeled())
+}
+
+func TestVoxels_GradientMagnitude(t *testing.T) {
+	assert := assert.New(t)
+
+	// Linear ramp increasing by 3 per voxel along x and 8 per voxel along y. With dy = 2, the gradient is (3, 4, 0) everywhere
+	vox := NewVoxels(5, 4, 3, 2, DT_FLOAT32)
+	vox.ForEachInStorageOrder(func(idx int64, x, y, z, t int64, val float64) {
+		vox.voxel[idx] = 3*float64(x) + 4*float64(y)*2 + float64(t)
+	})
+
+	gradient := vox.GradientMagnitude(1, 2, 0.5)
+	assert.Equal(DT_FLOAT32, gradient.datatype)
+	assert.Equal(vox.Len(), gradient.Len())
+	for _, magnitude := range gradient.voxel {
+		assert.InDelta(5.0, magnitude, 1e-9)
+	}
+
+	// A single slice has no z derivative
+	flat := NewVoxels(3, 1, 1, 1, DT_FLOAT32)
+	flat.voxel = []float64{0, 2, 4}
+	assert.Equal([]float64{2, 2, 2}, flat.GradientMagnitude(1, 1, 1).voxel)
+}
+
+func TestVoxels_HistogramRange(t *testing.T) {
+	assert := assert.New(t)
+
+	vox := NewVoxels(10, 1, 1, 1, DT_FLOAT32)
+	vox.voxel = []float64{-5, 0, 1, 2, 3, 4, 4, 5, 9, 20}
+	counts := func(buckets []utils.Bucket) []int {
+		res := make([]int, len(buckets))
+		for i, bkt := range buckets {
+			res[i] = bkt.Count
+		}
+		return res
+	}
+
+	// [0, 4] in 4 bins of width 1, the max is included in the last bin
+	hist, err := vox.HistogramRange(4, 0, 4, false)
+	assert.NoError(err)
+	assert.Equal([]int{1, 1, 1, 3}, counts(hist.Buckets))
+	assert.Equal(6, hist.Count)
+	assert.Equal(1, hist.Min)
+	assert.Equal(3, hist.Max)
+	assert.Equal(0.0, hist.Buckets[0].Min)
+	assert.Equal(4.0, hist.Buckets[3].Max)
+
+	// The outliers go to the edge bins
+	hist, err = vox.HistogramRange(4, 0, 4, true)
+	assert.NoError(err)
+	assert.Equal([]int{2, 1, 1, 6}, counts(hist.Buckets))
+	assert.Equal(10, hist.Count)
+
+	_, err = vox.HistogramRange(4, 4, 0, true)
+	assert.Error(err)
+	_, err = vox.HistogramRange(0, 0, 4, true)
+	assert.Error(err)
+}
+
+func TestVoxels_OtsuThreshold(t *testing.T) {
+	assert := assert.New(t)
+
+	// Background around 20 and foreground around 200
+	vox := NewVoxels(10, 10, 10, 1, DT_FLOAT32)
+	for idx := range vox.voxel {
+		if idx%3 == 0 {
+			vox.voxel[idx] = 200 + float64(idx%11) - 5
+		} else {
+			vox.voxel[idx] = 20 + float64(idx%7) - 3
+		}
+	}
+
+	threshold := vox.OtsuThreshold()
+	assert.Greater(threshold, 23.0)
+	assert.Less(threshold, 195.0)
+
+	var foreground int
+	for idx, val := range vox.voxel {
+		assert.Equal(idx%3 == 0, val > threshold)
+		if val > threshold {
+			foreground++
+		}
+	}
+	assert.Equal(334, foreground)
+
+	constant := NewVoxels(2, 2, 1, 1, DT_FLOAT32)
+	constant.voxel = []float64{5, 5, 5, 5}
+	assert.Equal(5.0, constant.OtsuThreshold())
+}
+
+func TestVoxels_CenterOfMass(t *testing.T) {
+	assert := assert.New(t)
+
+	// A 4x4x4 cube of label 2 centered in a 6x6x6 grid, with a single voxel of label 1 in a corner
+	vox := NewVoxels(6, 6, 6, 1, DT_UINT8)
+	for z := int64(1); z <= 4; z++ {
+		for y := int64(1); y <= 4; y++ {
+			for x := int64(1); x <= 4; x++ {
+				vox.Set(x, y, z, 0, 2)
+			}
+		}
+	}
+	vox.Set(5, 0, 5, 0, 1)
+
+	center, ok := vox.CenterOfMass(2)
+	assert.True(ok)
+	assert.Equal([3]float64{2.5, 2.5, 2.5}, center)
+	center, ok = vox.CenterOfMass(1)
+	assert.True(ok)
+	assert.Equal([3]float64{5, 0, 5}, center)
+	_, ok = vox.CenterOfMass(3)
+	assert.False(ok)
+}
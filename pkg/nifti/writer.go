@@ -5,8 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	gzip "github.com/klauspost/pgzip"
 	"github.com/okieraised/gonii/internal/system"
+	"io"
 	"math"
 	"os"
 	"strings"
@@ -23,6 +23,13 @@ type Writer interface {
 	WriteToBytes() ([]byte, error)
 }
 
+// PairWriter is implemented by the writers that can write a .hdr/.img pair to arbitrary writers. NiiWriter implements
+// it
+type PairWriter interface {
+	// WritePairTo writes the header and the image of a .hdr/.img pair to the writers
+	WritePairTo(hdrW, imgW io.Writer) error
+}
+
 // NiiWriter define the NIfTI writer structure.
 //
 // Parameters:
@@ -32,6 +39,11 @@ type Writer interface {
 //   - `niiData`          : Input NIfTI data to write to file
 //   - `header`           : Input NIfTI header to write to file. If nil, the default header will be constructed
 //   - `version`          : Specify the version (NIfTI-1 or NIfTI-2) to export
+//   - `writeASCII`       : Whether to write the header as NIfTI-ASCII text followed by the binary image data
+//   - `legacyPairNames`  : Whether to name the NIfTI pair as <filePath>_nifti.hdr/.img instead of <base>.hdr/.img
+//   - `atomic`           : Whether to write to a temporary file first then rename it to the target path
+//   - `noClobber`        : Whether to return an error instead of overwriting an existing file
+//   - `preserveHeader`   : Whether to write the user-specified header and the bytes up to vox_offset verbatim
 type NiiWriter struct {
 	filePath        string      // Export file path to write NIfTI image
 	writeHeaderFile bool        // Whether to write NIfTI file pair (hdr + img file)
@@ -39,6 +51,11 @@ type NiiWriter struct {
 	niiData         *Nii        // Input NIfTI data to write to file
 	header          interface{} // Input NIfTI header to write to file. If nil, the default header will be constructed
 	version         int         //Specify the version (NIfTI-1 or NIfTI-2) to export
+	writeASCII      bool        // Whether to write the header as NIfTI-ASCII text (.nia)
+	legacyPairNames bool        // Whether to name the NIfTI pair as <filePath>_nifti.hdr/.img
+	atomic          bool        // Whether to write to a temporary file first then rename it to the target path
+	noClobber       bool        // Whether to return an error instead of overwriting an existing file
+	preserveHeader  bool        // Whether to write the user-specified header verbatim
 }
 
 func (w *NiiWriter) SetFilePath(filePath string) {
@@ -65,28 +82,95 @@ func (w *NiiWriter) SetVersion(version int) {
 	w.version = version
 }
 
+func (w *NiiWriter) SetLegacyPairNames(legacyPairNames bool) {
+	w.legacyPairNames = legacyPairNames
+}
+
+func (w *NiiWriter) SetWriteASCII(writeASCII bool) {
+	w.writeASCII = writeASCII
+}
+
+func (w *NiiWriter) SetAtomic(atomic bool) {
+	w.atomic = atomic
+}
+
+func (w *NiiWriter) SetNoClobber(noClobber bool) {
+	w.noClobber = noClobber
+}
+
+func (w *NiiWriter) SetPreserveHeader(preserveHeader bool) {
+	w.preserveHeader = preserveHeader
+}
+
 func (w *NiiWriter) WriteToBytes() ([]byte, error) {
-	// Convert image to header
-	switch w.version {
-	case NIIVersion1:
-		err := w.convertImageToNii1Header()
+	// NIfTI-ASCII does not need a binary header structure
+	if w.writeASCII {
+		return w.reconstructASCIIDataset()
+	}
+
+	// The dataset is always laid out as a single file
+	err := w.prepareHeader(false)
+	if err != nil {
+		return nil, err
+	}
+	return w.reconstructDataset()
+}
+
+// WriteToFile write the header and image to either a single NIfTI file or a pair of .hdr/.img file
+func (w *NiiWriter) WriteToFile() error {
+	// NIfTI-ASCII does not need a binary header structure
+	if w.writeASCII {
+		return w.writeASCIINii()
+	}
+
+	err := w.prepareHeader(w.writeHeaderFile)
+	if err != nil {
+		return err
+	}
+
+	// convert image structure to file
+	// If user decides to write to a separate hdr/img file pair
+	if w.writeHeaderFile {
+		err := w.writePairNii()
 		if err != nil {
-			return nil, err
+			return err
 		}
-	case NIIVersion2:
-		err := w.convertImageToNii2Header()
+	} else { // Just one file for both header and the image data
+		err := w.writeSingleNii()
 		if err != nil {
-			return nil, err
+			return err
 		}
-	default:
-		return nil, fmt.Errorf("unknown NIfTI version %d", w.version)
 	}
+	return nil
+}
 
-	return w.reconstructDataset()
+// WritePairTo writes the header (with the extensions) and the image data of a .hdr/.img pair to hdrW and imgW, e.g. to
+// write into a virtual filesystem. Both are compressed with gzip if the compression option is set. The file path and
+// the file options (atomic, noClobber) are not used
+func (w *NiiWriter) WritePairTo(hdrW, imgW io.Writer) error {
+	if w.writeASCII {
+		return errors.New("NIfTI-ASCII cannot be written as a pair")
+	}
+
+	err := w.prepareHeader(true)
+	if err != nil {
+		return err
+	}
+	bHeader, err := w.reconstructPairHeader()
+	if err != nil {
+		return err
+	}
+
+	err = writeDataset(hdrW, w.compression, bHeader)
+	if err != nil {
+		return err
+	}
+	return writeDataset(imgW, w.compression, w.niiData.Volume)
 }
 
-// WriteToFile write the header and image to either a single NIfTI file or a pair of .hdr/.img file
-func (w *NiiWriter) WriteToFile() error {
+// prepareHeader converts the image to the header of the NIfTI version and sets the magic string for a single file or a
+// pair
+func (w *NiiWriter) prepareHeader(pair bool) error {
 	// Convert image to header
 	switch w.version {
 	case NIIVersion1:
@@ -102,19 +186,60 @@ func (w *NiiWriter) WriteToFile() error {
 	default:
 		return fmt.Errorf("unknown NIfTI version %d", w.version)
 	}
+	if w.preserveHeader && w.header != nil {
+		return w.checkPreservedHeader(pair)
+	}
+	return w.setFileTypeMagic(pair)
+}
 
-	// convert image structure to file
-	// If user decides to write to a separate hdr/img file pair
-	if w.writeHeaderFile {
-		err := w.writePairNii()
-		if err != nil {
-			return err
-		}
-	} else { // Just one file for both header and the image data
-		err := w.writeSingleNii()
-		if err != nil {
-			return err
+// sameExtensions returns whether the raw bytes following the header hold the encoded extender and extensions. Only the
+// first byte of the extender is meaningful, the other three may hold anything
+func sameExtensions(gap, encoded []byte) bool {
+	if len(gap) < len(encoded) {
+		return false
+	}
+	return (gap[0] != 0) == (encoded[0] != 0) && bytes.Equal(gap[4:len(encoded)], encoded[4:])
+}
+
+// checkPreservedHeader verifies that the user-specified header can be written verbatim: its magic string must match
+// the file type, its vox_offset must leave room for the extensions and it must describe the dimensions and the datatype
+// of the image
+func (w *NiiWriter) checkPreservedHeader(pair bool) error {
+	if w.niiData == nil {
+		return errors.New("image data structure is nil")
+	}
+
+	var magic []byte
+	var dim [8]int64
+	var datatype int32
+	var voxOffset, sizeofHdr int64
+	switch hdr := w.header.(type) {
+	case *Nii1Header:
+		magic = hdr.Magic[:]
+		for i := range hdr.Dim {
+			dim[i] = int64(hdr.Dim[i])
 		}
+		datatype = int32(hdr.Datatype)
+		voxOffset, sizeofHdr = int64(hdr.VoxOffset), int64(hdr.SizeofHdr)
+	case *Nii2Header:
+		magic = hdr.Magic[:]
+		dim = hdr.Dim
+		datatype = int32(hdr.Datatype)
+		voxOffset, sizeofHdr = hdr.VoxOffset, int64(hdr.SizeofHdr)
+	default:
+		return fmt.Errorf("unknown header type")
+	}
+
+	isPair := bytes.Equal(magic, NIFTI_1_MAGIC_PAIR[:]) || bytes.Equal(magic, NIFTI_2_MAGIC_PAIR[:])
+	isSingle := bytes.Equal(magic, NIFTI_1_MAGIC_SINGLE[:]) || bytes.Equal(magic, NIFTI_2_MAGIC_SINGLE[:])
+	if (pair && !isPair) || (!pair && !isSingle) {
+		return fmt.Errorf("preserved header magic %q does not match the output file type", bytes.TrimRight(magic, "\x00"))
+	}
+	if !pair && voxOffset < sizeofHdr+int64(w.niiData.extensionSize()) {
+		return fmt.Errorf("preserved header vox_offset %d leaves no room for the extensions", voxOffset)
+	}
+	if dim != w.niiData.Dim || datatype != w.niiData.Datatype {
+		return errors.New("preserved header does not match the dimensions and the datatype of the image")
 	}
 	return nil
 }
@@ -139,9 +264,25 @@ func (w *NiiWriter) reconstructDataset() ([]byte, error) {
 		offset = make([]byte, DefaultHeaderPadding, DefaultHeaderPadding)
 	}
 
-	// Make a buffer and write the header to it with default system endian
+	// The extender and the extensions are placed at the start of the offset
+	bExtension, err := w.niiData.encodeExtensions(w.byteOrder())
+	if err != nil {
+		return nil, err
+	}
+	if len(bExtension) > len(offset) {
+		return nil, fmt.Errorf("vox_offset leaves %d bytes after the header but the extensions require %d bytes", len(offset), len(bExtension))
+	}
+	// A preserved header keeps the original bytes up to vox_offset, unless the extensions have changed since
+	gap := w.niiData.HeaderGap
+	if w.preserveHeader && w.header != nil && len(gap) == len(offset) && sameExtensions(gap, bExtension) {
+		copy(offset, gap)
+	} else {
+		copy(offset, bExtension)
+	}
+
+	// Make a buffer and write the header to it with the byte order of the image data
 	hdrBuf := &bytes.Buffer{}
-	err := binary.Write(hdrBuf, system.NativeEndian, w.header)
+	err = binary.Write(hdrBuf, w.byteOrder(), w.header)
 	if err != nil {
 		return nil, err
 	}
@@ -160,87 +301,131 @@ func (w *NiiWriter) reconstructDataset() ([]byte, error) {
 // writePairNii writes the header and NIfTI image Nii as 2 separate files
 func (w *NiiWriter) writePairNii() error {
 	var headerFilePath string
-
-	// Check if the user-specified filePath suffix is ending with '.nii' or '.gz'.
-	// If not, we append '.nii' to the end to signify the file is NIfTI format
-	if !strings.HasSuffix(w.filePath, NIFTI_EXT) && !strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
-		// If user specifies the file with '.gz' extension then default to compression
-		if strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
-			w.compression = true
-		}
-		w.filePath = w.filePath + NIFTI_EXT
+	if w.legacyPairNames {
+		headerFilePath = w.legacyPairFilePaths()
+	} else {
+		headerFilePath = w.pairFilePaths()
 	}
 
-	headerFilePath = w.filePath
-	// Now replace the suffix to identify the header and img file
-	headerFilePath = strings.ReplaceAll(w.filePath, NIFTI_EXT, "_nifti.hdr")
-	w.filePath = strings.ReplaceAll(w.filePath, NIFTI_EXT, "_nifti.img")
-
-	// Check if the user-specified filePath suffix is ending with '.gz'.
-	// If not, we append '.gz' to the end to signify the file is compressed
-	if w.compression {
-		if !strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
-			w.filePath = w.filePath + NIFTI_COMPRESSED_EXT
-			headerFilePath = headerFilePath + NIFTI_COMPRESSED_EXT
-		}
+	bHeader, err := w.reconstructPairHeader()
+	if err != nil {
+		return err
 	}
 
-	// Write header structure as bytes
-	hdrBuf := &bytes.Buffer{}
-	err := binary.Write(hdrBuf, system.NativeEndian, w.header)
+	// Check both files before writing anything so that an existing image file does not leave a lone header behind
+	err = w.checkNoClobber(headerFilePath, w.filePath)
 	if err != nil {
 		return err
 	}
-	bHeader := hdrBuf.Bytes()
-
-	// Image data
-	bData := w.niiData.Volume
 
-	// Create header file object
-	fHeader, err := os.Create(headerFilePath)
+	// If compression option is set to true, write both the header and image data as compressed files
+	err = w.writeFile(headerFilePath, bHeader)
 	if err != nil {
 		return err
 	}
-	defer fHeader.Close()
+	return w.writeFile(w.filePath, w.niiData.Volume)
+}
 
-	// Create data file object
-	fData, err := os.Create(w.filePath)
+// reconstructPairHeader returns the content of the header file of a pair: the header structure followed by the
+// extensions, if any
+func (w *NiiWriter) reconstructPairHeader() ([]byte, error) {
+	// Write header structure as bytes
+	hdrBuf := &bytes.Buffer{}
+	err := binary.Write(hdrBuf, w.byteOrder(), w.header)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer fData.Close()
+	bHeader := hdrBuf.Bytes()
 
-	// If compression option is set to true, write both the header and image data as compressed files
-	if w.compression {
-		// Write compressed header to file
-		gzipWriter := gzip.NewWriter(fHeader)
-		_, err = gzipWriter.Write(bHeader)
+	// The extensions are stored in the header file right after the header structure
+	if len(w.niiData.Nifti1Ext) > 0 {
+		bExtension, err := w.niiData.encodeExtensions(w.byteOrder())
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = gzipWriter.Close()
+		bHeader = append(bHeader, bExtension...)
+	}
+	return bHeader, nil
+}
 
-		// Write compressed data to file
-		gzipWriter = gzip.NewWriter(fData)
-		_, err = gzipWriter.Write(bData)
-		if err != nil {
+// writeFile writes the content to filePath, compressed if the compression option is set
+func (w *NiiWriter) writeFile(filePath string, content []byte) error {
+	if w.atomic {
+		return writeToFileAtomic(filePath, w.compression, content, w.noClobber)
+	}
+	return writeToFile(filePath, w.compression, content, w.noClobber)
+}
+
+// checkNoClobber returns an error if the noClobber option is set and any of the files already exists. This is only an
+// early check, writeFile creates the files so that an existing file is never overwritten
+func (w *NiiWriter) checkNoClobber(filePaths ...string) error {
+	if !w.noClobber {
+		return nil
+	}
+	for _, filePath := range filePaths {
+		_, err := os.Stat(filePath)
+		if err == nil {
+			return fmt.Errorf("file %s already exists", filePath)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
-		err = gzipWriter.Close()
+	}
+	return nil
+}
 
-	} else { // Write both the header and image data normally
-		_, err = fHeader.Write(bHeader)
-		if err != nil {
-			return err
+// pairFilePaths sets the image file path to <base>.img and returns the header file path <base>.hdr, where <base> is
+// the user-specified filePath without any '.nii', '.hdr', '.img' or '.gz' suffix. Both paths end with '.gz' if compressed
+func (w *NiiWriter) pairFilePaths() string {
+	base := w.filePath
+
+	// If user specifies the file with '.gz' extension then default to compression
+	if strings.HasSuffix(base, NIFTI_COMPRESSED_EXT) {
+		w.compression = true
+		base = strings.TrimSuffix(base, NIFTI_COMPRESSED_EXT)
+	}
+	for _, ext := range []string{NIFTI_EXT, NIFTI_PAIR_HDR_EXT, NIFTI_PAIR_IMG_EXT} {
+		if strings.HasSuffix(base, ext) {
+			base = strings.TrimSuffix(base, ext)
+			break
 		}
+	}
 
-		_, err = fData.Write(bData)
-		if err != nil {
-			return err
+	headerFilePath := base + NIFTI_PAIR_HDR_EXT
+	w.filePath = base + NIFTI_PAIR_IMG_EXT
+	if w.compression {
+		headerFilePath = headerFilePath + NIFTI_COMPRESSED_EXT
+		w.filePath = w.filePath + NIFTI_COMPRESSED_EXT
+	}
+	return headerFilePath
+}
+
+// legacyPairFilePaths sets the image file path to <filePath>_nifti.img and returns the header file path
+// <filePath>_nifti.hdr
+func (w *NiiWriter) legacyPairFilePaths() string {
+	// Check if the user-specified filePath suffix is ending with '.nii' or '.gz'.
+	// If not, we append '.nii' to the end to signify the file is NIfTI format
+	if !strings.HasSuffix(w.filePath, NIFTI_EXT) && !strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
+		// If user specifies the file with '.gz' extension then default to compression
+		if strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
+			w.compression = true
 		}
+		w.filePath = w.filePath + NIFTI_EXT
 	}
 
-	return nil
+	// Now replace the suffix to identify the header and img file
+	headerFilePath := strings.ReplaceAll(w.filePath, NIFTI_EXT, "_nifti.hdr")
+	w.filePath = strings.ReplaceAll(w.filePath, NIFTI_EXT, "_nifti.img")
+
+	// Check if the user-specified filePath suffix is ending with '.gz'.
+	// If not, we append '.gz' to the end to signify the file is compressed
+	if w.compression {
+		if !strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
+			w.filePath = w.filePath + NIFTI_COMPRESSED_EXT
+			headerFilePath = headerFilePath + NIFTI_COMPRESSED_EXT
+		}
+	}
+	return headerFilePath
 }
 
 // writeSingleNii writes the header and NIfTI image Nii to a single NIfTI file
@@ -303,13 +488,34 @@ func (w *NiiWriter) writeSingleNii() error {
 	}
 
 	// Create a file object from the specified filePath
-	err = WriteToFile(w.filePath, w.compression, dataset)
+	err = w.writeFile(w.filePath, dataset)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// writeASCIINii writes the NIfTI-ASCII text header and the image data to a single file
+func (w *NiiWriter) writeASCIINii() error {
+	dataset, err := w.reconstructASCIIDataset()
+	if err != nil {
+		return err
+	}
+
+	// Check if the user-specified filePath suffix is ending with '.nia' or '.gz'.
+	// If not, we append '.nia' to the end to signify the file is NIfTI-ASCII format
+	if !strings.HasSuffix(w.filePath, NIFTI_ASCII_EXT) && !strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
+		w.filePath = w.filePath + NIFTI_ASCII_EXT
+	}
+	if strings.HasSuffix(w.filePath, NIFTI_COMPRESSED_EXT) {
+		w.compression = true
+	} else if w.compression {
+		w.filePath = w.filePath + NIFTI_COMPRESSED_EXT
+	}
+
+	return w.writeFile(w.filePath, dataset)
+}
+
 // convertImageToNii1Header returns the header from a NIfTI image structure
 func (w *NiiWriter) convertImageToNii1Header() error {
 	if w.header != nil {
@@ -377,16 +583,18 @@ func (w *NiiWriter) convertImageToNii1Header() error {
 	header.Toffset = float32(w.niiData.TOffset)
 
 	if w.niiData.QformCode > 0 {
+		qform := w.niiData.qformForWrite()
 		header.QformCode = int16(w.niiData.QformCode)
-		header.QuaternB = float32(w.niiData.QuaternB)
-		header.QuaternC = float32(w.niiData.QuaternC)
-		header.QuaternD = float32(w.niiData.QuaternD)
+		header.QuaternB = float32(qform.QuaternB)
+		header.QuaternC = float32(qform.QuaternC)
+		header.QuaternD = float32(qform.QuaternD)
 
-		header.QoffsetX = float32(w.niiData.QoffsetX)
-		header.QoffsetY = float32(w.niiData.QoffsetY)
-		header.QoffsetZ = float32(w.niiData.QoffsetZ)
+		header.QoffsetX = float32(qform.QoffsetX)
+		header.QoffsetY = float32(qform.QoffsetY)
+		header.QoffsetZ = float32(qform.QoffsetZ)
+		header.Pixdim[1], header.Pixdim[2], header.Pixdim[3] = float32(math.Abs(qform.Dx)), float32(math.Abs(qform.Dy)), float32(math.Abs(qform.Dz))
 
-		if w.niiData.QFac >= 0 {
+		if qform.QFac >= 0 {
 			header.Pixdim[0] = 1.0
 		} else {
 			header.Pixdim[0] = -1.0
@@ -411,6 +619,10 @@ func (w *NiiWriter) convertImageToNii1Header() error {
 		header.SrowZ[3] = float32(w.niiData.StoXYZ.M[2][3])
 	}
 
+	// The unused fields are kept as read for byte-exact round-trips
+	header.DataTypeUnused = w.niiData.DataTypeStr
+	header.DbName = w.niiData.DbName
+
 	header.DimInfo = convertFPSIntoDimInfo(w.niiData.FreqDim, w.niiData.PhaseDim, w.niiData.SliceDim)
 
 	header.SliceCode = uint8(w.niiData.SliceCode)
@@ -418,19 +630,7 @@ func (w *NiiWriter) convertImageToNii1Header() error {
 	header.SliceEnd = int16(w.niiData.SliceEnd)
 	header.SliceDuration = float32(w.niiData.SliceDuration)
 
-	// Load NIFTI specific stuff into the header
-	if w.writeHeaderFile {
-		header.Magic = NIFTI_1_MAGIC_PAIR // ni1
-		header.VoxOffset = 0
-	} else {
-		header.Magic = NIFTI_1_MAGIC_SINGLE // n+1
-		// This is for a case where we read the image as .hdr/.img pair but then want to write to a single file.
-		// We have to update the VoxOffset value
-		if int(header.VoxOffset)-int(header.SizeofHdr) <= 0 {
-			header.VoxOffset = float32(header.SizeofHdr + DefaultHeaderPadding)
-		}
-	}
-
+	// The magic string and the VoxOffset depend on the output file type, see setFileTypeMagic
 	w.header = header
 
 	return nil
@@ -502,16 +702,18 @@ func (w *NiiWriter) convertImageToNii2Header() error {
 	header.Toffset = w.niiData.TOffset
 
 	if w.niiData.QformCode > 0 {
+		qform := w.niiData.qformForWrite()
 		header.QformCode = w.niiData.QformCode
-		header.QuaternB = w.niiData.QuaternB
-		header.QuaternC = w.niiData.QuaternC
-		header.QuaternD = w.niiData.QuaternD
+		header.QuaternB = qform.QuaternB
+		header.QuaternC = qform.QuaternC
+		header.QuaternD = qform.QuaternD
 
-		header.QoffsetX = w.niiData.QoffsetX
-		header.QoffsetY = w.niiData.QoffsetY
-		header.QoffsetZ = w.niiData.QoffsetZ
+		header.QoffsetX = qform.QoffsetX
+		header.QoffsetY = qform.QoffsetY
+		header.QoffsetZ = qform.QoffsetZ
+		header.Pixdim[1], header.Pixdim[2], header.Pixdim[3] = math.Abs(qform.Dx), math.Abs(qform.Dy), math.Abs(qform.Dz)
 
-		if w.niiData.QFac >= 0 {
+		if qform.QFac >= 0 {
 			header.Pixdim[0] = 1.0
 		} else {
 			header.Pixdim[0] = -1.0
@@ -536,6 +738,9 @@ func (w *NiiWriter) convertImageToNii2Header() error {
 		header.SrowZ[3] = w.niiData.StoXYZ.M[2][3]
 	}
 
+	// The unused field is kept as read for byte-exact round-trips
+	header.UnusedStr = w.niiData.UnusedStr
+
 	header.DimInfo = convertFPSIntoDimInfo(w.niiData.FreqDim, w.niiData.PhaseDim, w.niiData.SliceDim)
 
 	header.SliceCode = w.niiData.SliceCode
@@ -543,22 +748,69 @@ func (w *NiiWriter) convertImageToNii2Header() error {
 	header.SliceEnd = w.niiData.SliceEnd
 	header.SliceDuration = w.niiData.SliceDuration
 
-	// Load NIFTI specific stuff into the header
-	if w.writeHeaderFile {
-		header.Magic = NIFTI_2_MAGIC_PAIR // ni2
-		header.VoxOffset = 0
+	// The magic string and the VoxOffset depend on the output file type, see setFileTypeMagic
+	w.header = header
+
+	return nil
+}
+
+// setFileTypeMagic sets the magic string and the VoxOffset of the header according to the output file type:
+//   - .hdr/.img pair : 'ni1' or 'ni2' with a VoxOffset of 0 since the image data is in a separate file
+//   - single file    : 'n+1' or 'n+2' with a VoxOffset leaving room for the extender and the extensions
+//
+// This also applies to user-specified headers, which are copied so that the input structure is left untouched
+func (w *NiiWriter) setFileTypeMagic(pair bool) error {
+	var minVoxOffset int
+	if w.niiData != nil {
+		minVoxOffset = w.niiData.extensionSize()
 	} else {
-		header.Magic = NIFTI_2_MAGIC_SINGLE // n+2
-		// This is for a case where we read the image as .hdr/.img pair but then want to write to a single file.
-		// We have to update the VoxOffset value
-		header.VoxOffset = int64(header.SizeofHdr + DefaultHeaderPadding)
+		minVoxOffset = DefaultHeaderPadding
 	}
 
-	w.header = header
-
+	switch hdr := w.header.(type) {
+	case *Nii1Header:
+		header := *hdr
+		if pair {
+			header.Magic = NIFTI_1_MAGIC_PAIR // ni1
+			header.VoxOffset = 0
+		} else {
+			header.Magic = NIFTI_1_MAGIC_SINGLE // n+1
+			// This is for a case where we read the image as .hdr/.img pair but then want to write to a single file.
+			// We have to update the VoxOffset value
+			minVoxOffset += int(header.SizeofHdr)
+			if header.VoxOffset < float32(minVoxOffset) {
+				header.VoxOffset = float32(minVoxOffset)
+			}
+		}
+		w.header = &header
+	case *Nii2Header:
+		header := *hdr
+		if pair {
+			header.Magic = NIFTI_2_MAGIC_PAIR // ni2
+			header.VoxOffset = 0
+		} else {
+			header.Magic = NIFTI_2_MAGIC_SINGLE // n+2
+			minVoxOffset += int(header.SizeofHdr)
+			if header.VoxOffset < int64(minVoxOffset) {
+				header.VoxOffset = int64(minVoxOffset)
+			}
+		}
+		w.header = &header
+	default:
+		return fmt.Errorf("unknown header type")
+	}
 	return nil
 }
 
+// byteOrder returns the byte order used to serialize the header. The voxel data is written as-is, so the header must
+// follow the byte order the volume was decoded with. Falls back to the native endian if the image does not carry one
+func (w *NiiWriter) byteOrder() binary.ByteOrder {
+	if w.niiData != nil {
+		return w.niiData.volumeByteOrder()
+	}
+	return system.NativeEndian
+}
+
 // GetNiiData returns the current NIfTI image data
 func (w *NiiWriter) GetNiiData() *Nii {
 	return w.niiData
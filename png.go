@@ -0,0 +1,95 @@
+package gonii
+
+import (
+	"errors"
+	"fmt"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+)
+
+// autoWindowPercentiles are the percentiles of the volume intensities mapped to black and white when the image has no
+// calibration range
+var autoWindowPercentiles = []float64{2, 98}
+
+// WriteMontagePNG writes all the z slices of the volume at time t of the image as a single 8-bit grayscale PNG, tiled
+// in a grid of cols columns filled row by row. The montage is cols*Nx wide and ceil(Nz/cols)*Ny high, the unused tiles
+// are black. The intensities are windowed with the calibration range (CalMin, CalMax) if set, otherwise with the 2nd
+// and 98th percentiles of the volume
+func WriteMontagePNG(img *nifti.Nii, t int64, cols int, path string) error {
+	if img == nil {
+		return errors.New("image data structure is nil")
+	}
+	if cols <= 0 {
+		return fmt.Errorf("invalid number of columns %d", cols)
+	}
+
+	flat, err := img.GetVolumeFlat(t)
+	if err != nil {
+		return err
+	}
+	low, high, err := autoWindow(img, flat)
+	if err != nil {
+		return err
+	}
+
+	nx, ny, nz := dimOrOne(img.Nx), dimOrOne(img.Ny), dimOrOne(img.Nz)
+	rows := (nz + int64(cols) - 1) / int64(cols)
+	montage := image.NewGray(image.Rect(0, 0, int(int64(cols)*nx), int(rows*ny)))
+	for z := int64(0); z < nz; z++ {
+		slice := renderSlice(flat[z*nx*ny:(z+1)*nx*ny], nx, ny, low, high)
+		col, row := z%int64(cols), z/int64(cols)
+		origin := image.Pt(int(col*nx), int(row*ny))
+		draw.Draw(montage, slice.Bounds().Add(origin), slice, image.Point{}, draw.Src)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, montage)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// autoWindow returns the intensities mapped to black and white: the calibration range of the image if set, otherwise
+// the autoWindowPercentiles of the volume values
+func autoWindow(img *nifti.Nii, values []float64) (float64, float64, error) {
+	if img.CalMax > img.CalMin {
+		return img.CalMin, img.CalMax, nil
+	}
+
+	vox := nifti.NewVoxels(int64(len(values)), 1, 1, 1, nifti.DT_FLOAT64)
+	for i, val := range values {
+		vox.Set(int64(i), 0, 0, 0, val)
+	}
+	window, err := vox.Percentiles(autoWindowPercentiles...)
+	if err != nil {
+		return 0, 0, err
+	}
+	return window[0], window[1], nil
+}
+
+// renderSlice renders the nx*ny slice values in storage order as an 8-bit grayscale image, mapping low to black and
+// high to white. The y axis points up, so that the first row of the slice is at the bottom of the image
+func renderSlice(values []float64, nx, ny int64, low, high float64) *image.Gray {
+	out := image.NewGray(image.Rect(0, 0, int(nx), int(ny)))
+	for y := int64(0); y < ny; y++ {
+		for x := int64(0); x < nx; x++ {
+			var gray float64
+			if high > low {
+				gray = math.Round(255 * (values[y*nx+x] - low) / (high - low))
+			} else if values[y*nx+x] > low {
+				gray = 255
+			}
+			out.Pix[(ny-1-y)*int64(out.Stride)+x] = uint8(math.Max(0, math.Min(255, gray)))
+		}
+	}
+	return out
+}
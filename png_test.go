@@ -0,0 +1,41 @@
+package gonii
+
+import (
+	"encoding/binary"
+	"github.com/okieraised/gonii/pkg/nifti"
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteMontagePNG(t *testing.T) {
+	assert := assert.New(t)
+
+	img := newTestImage(6, 4, 5, 1, nifti.DT_INT16, binary.LittleEndian)
+	for z := int64(0); z < 5; z++ {
+		assert.NoError(img.SetAt(float64(10*(z+1)), 2, 1, z, 0))
+	}
+	path := filepath.Join(t.TempDir(), "montage.png")
+	assert.NoError(WriteMontagePNG(img, 0, 3, path))
+
+	file, err := os.Open(path)
+	assert.NoError(err)
+	defer file.Close()
+	montage, err := png.Decode(file)
+	assert.NoError(err)
+	// 3 columns of 6 voxels by ceil(5/3) = 2 rows of 4 voxels
+	assert.Equal(3*6, montage.Bounds().Dx())
+	assert.Equal(2*4, montage.Bounds().Dy())
+
+	// The slice z = 4 is the second tile of the second row, its voxel (2, 1) is at the maximal intensity
+	r, _, _, _ := montage.At(1*6+2, 1*4+(4-1-1)).RGBA()
+	assert.Equal(uint32(0xffff), r)
+	r, _, _, _ = montage.At(2*6+2, 1*4+(4-1-1)).RGBA()
+	assert.Equal(uint32(0), r)
+
+	assert.Error(WriteMontagePNG(img, 0, 0, path))
+	assert.Error(WriteMontagePNG(img, 1, 3, path))
+	assert.Error(WriteMontagePNG(nil, 0, 3, path))
+}